@@ -8,9 +8,46 @@
 * environment variables from the .env file.
 *
 * The environment variables we use are:
-*  - 'MONGODB_URI' : The mongodb uri for the kms database
+*  - 'MONGODB_URI' : The mongodb uri for the kms database, which must be
+*                    a replica set or sharded cluster, as flows writing
+*                    to several collections run as transactions (see
+*                    controllers/transaction.go)
 *  - 'Port' 	   : The port to run the server on (Default: 8080)
 *
+* Notifications (see notifiers/notifier.go):
+*  - 'NOTIFIER'                : 'log', 'smtp', or 'webhook' (Default: log)
+*  - 'SMTP_HOST'               : The smtp server host (Default: localhost)
+*  - 'SMTP_PORT'               : The smtp server port (Default: 25)
+*  - 'SMTP_USERNAME'           : The smtp username (Default: no auth)
+*  - 'SMTP_PASSWORD'           : The smtp password
+*  - 'SMTP_FROM'               : The sender address of notification emails
+*  - 'NOTIFIER_WEBHOOK_URL'    : The url notifications are POSTed to
+*  - 'QUOTA_NOTIFY_THRESHOLDS' : Comma separated percentages of a key's
+*                                quota consumed at which the owner is
+*                                notified (Default: 80,100)
+*
+* Quotas:
+*  - 'QUOTA_BATCH_REFRESH'     : 'true' or 'false', whether keys whose
+*                                quota period has ended are reset in
+*                                batches, rather than only as they are
+*                                consumed (Default: true)
+*  - 'DEFAULT_BASIC_PLAN'      : The name of the plan new basic keys
+*                                subscribe to (Default: none)
+*  - 'DEFAULT_ADVANCED_PLAN'   : The name of the plan new advanced keys
+*                                subscribe to (Default: none)
+*
+* Keys:
+*  - 'KEY_RETENTION_DAYS'      : Days deleted keys can be restored for,
+*                                after which they are purged (Default: 30)
+*  - 'KEY_CLAIM_TTL_HOURS'     : Hours the claim token of a new advanced
+*                                key is valid for (Default: 72)
+*  - 'KEY_INACTIVITY_DAYS'     : Days keys can go unused before they are
+*                                disabled, unless their service sets its
+*                                own policy (Default: 0, never disabled)
+*  - 'KEY_INACTIVITY_WARNING_DAYS' : Days before being disabled for
+*                                inactivity that owners are warned
+*                                (Default: 14)
+*
 * Written by Adam Brunn (amb150230) at The University of Texas at Dallas
 * for CS4485.0W1 (Nebula Platform CS Project) starting March 10, 2023.
 **************************************************************************/
@@ -21,6 +58,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 )
@@ -46,3 +86,158 @@ func GetEnvMongoURI() string {
 
 	return uri
 }
+
+func GetEnvNotifier() string {
+
+	notifier, exist := os.LookupEnv("NOTIFIER")
+	if !exist {
+		notifier = "log"
+	}
+
+	return notifier
+}
+
+func GetEnvSMTPAddress() string {
+
+	host, exist := os.LookupEnv("SMTP_HOST")
+	if !exist {
+		host = "localhost"
+	}
+
+	port, exist := os.LookupEnv("SMTP_PORT")
+	if !exist {
+		port = "25"
+	}
+
+	return fmt.Sprintf("%s:%s", host, port)
+}
+
+func GetEnvSMTPUsername() string {
+	return os.Getenv("SMTP_USERNAME")
+}
+
+func GetEnvSMTPPassword() string {
+	return os.Getenv("SMTP_PASSWORD")
+}
+
+func GetEnvSMTPFrom() string {
+
+	from, exist := os.LookupEnv("SMTP_FROM")
+	if !exist {
+		log.Fatalf("Error loading 'SMTP_FROM' from the .env file")
+	}
+
+	return from
+}
+
+func GetEnvNotifierWebhookURL() string {
+
+	url, exist := os.LookupEnv("NOTIFIER_WEBHOOK_URL")
+	if !exist {
+		log.Fatalf("Error loading 'NOTIFIER_WEBHOOK_URL' from the .env file")
+	}
+
+	return url
+}
+
+func GetEnvQuotaNotifyThresholds() []int {
+
+	thresholdsString, exist := os.LookupEnv("QUOTA_NOTIFY_THRESHOLDS")
+	if !exist {
+		return []int{80, 100}
+	}
+
+	thresholds := []int{}
+	for _, thresholdString := range strings.Split(thresholdsString, ",") {
+		threshold, err := strconv.Atoi(strings.TrimSpace(thresholdString))
+		if err != nil || threshold <= 0 || threshold > 100 {
+			log.Fatalf("Invalid 'QUOTA_NOTIFY_THRESHOLDS': Must be comma separated percentages between 1 and 100")
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	return thresholds
+}
+
+func GetEnvQuotaBatchRefresh() bool {
+
+	batchRefreshString, exist := os.LookupEnv("QUOTA_BATCH_REFRESH")
+	if !exist {
+		return true
+	}
+
+	batchRefresh, err := strconv.ParseBool(batchRefreshString)
+	if err != nil {
+		log.Fatalf("Invalid 'QUOTA_BATCH_REFRESH': Must be 'true' or 'false'")
+	}
+
+	return batchRefresh
+}
+
+func GetEnvKeyRetention() time.Duration {
+
+	retentionDaysString, exist := os.LookupEnv("KEY_RETENTION_DAYS")
+	if !exist {
+		return 30 * 24 * time.Hour
+	}
+
+	retentionDays, err := strconv.Atoi(retentionDaysString)
+	if err != nil || retentionDays < 0 {
+		log.Fatalf("Invalid 'KEY_RETENTION_DAYS': Must be a non-negative integer")
+	}
+
+	return time.Duration(retentionDays) * 24 * time.Hour
+}
+
+func GetEnvKeyClaimTTL() time.Duration {
+
+	claimTTLHoursString, exist := os.LookupEnv("KEY_CLAIM_TTL_HOURS")
+	if !exist {
+		return 72 * time.Hour
+	}
+
+	claimTTLHours, err := strconv.Atoi(claimTTLHoursString)
+	if err != nil || claimTTLHours <= 0 {
+		log.Fatalf("Invalid 'KEY_CLAIM_TTL_HOURS': Must be a positive integer")
+	}
+
+	return time.Duration(claimTTLHours) * time.Hour
+}
+
+func GetEnvKeyInactivityDays() int {
+
+	inactivityDaysString, exist := os.LookupEnv("KEY_INACTIVITY_DAYS")
+	if !exist {
+		return 0
+	}
+
+	inactivityDays, err := strconv.Atoi(inactivityDaysString)
+	if err != nil || inactivityDays < 0 {
+		log.Fatalf("Invalid 'KEY_INACTIVITY_DAYS': Must be a non-negative integer")
+	}
+
+	return inactivityDays
+}
+
+func GetEnvKeyInactivityWarningDays() int {
+
+	warningDaysString, exist := os.LookupEnv("KEY_INACTIVITY_WARNING_DAYS")
+	if !exist {
+		return 14
+	}
+
+	warningDays, err := strconv.Atoi(warningDaysString)
+	if err != nil || warningDays < 0 {
+		log.Fatalf("Invalid 'KEY_INACTIVITY_WARNING_DAYS': Must be a non-negative integer")
+	}
+
+	return warningDays
+}
+
+func GetEnvDefaultPlan(keyType string) string {
+
+	if keyType == "Basic" {
+		return os.Getenv("DEFAULT_BASIC_PLAN")
+	}
+	return os.Getenv("DEFAULT_ADVANCED_PLAN")
+}
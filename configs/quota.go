@@ -0,0 +1,155 @@
+package configs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	// Embed the IANA time zone database so quota time zones can always be loaded
+	_ "time/tzdata"
+)
+
+// Quotas of keys not subscribed to a plan
+const DefaultBasicKeyQuota = 100
+const DefaultAdvancedKeyQuota = 1000
+const DefaultQuotaNumDays = 1
+
+// Units of calendar-aligned quota windows
+var QuotaWindowUnits = []string{"Minute", "Hour", "Day", "Week", "Month"}
+
+// Load the location of a quota's time zone, an empty time zone being UTC
+func LoadQuotaLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+// Resolve the time zone and reset hour of a key's quota.
+// The key's own settings take precedence over its service's, and
+// quotas reset at midnight UTC when neither are set.
+func ResolveQuotaSchedule(keyTimezone string, keyResetHour *int, serviceTimezone string, serviceResetHour *int) (*time.Location, int) {
+	timezone := keyTimezone
+	if timezone == "" {
+		timezone = serviceTimezone
+	}
+	loc, err := LoadQuotaLocation(timezone)
+	if err != nil {
+		// @INFO: Time zones are validated when set, so this should not occur
+		loc = time.UTC
+	}
+
+	resetHour := 0
+	if keyResetHour != nil {
+		resetHour = *keyResetHour
+	} else if serviceResetHour != nil {
+		resetHour = *serviceResetHour
+	}
+
+	return loc, resetHour
+}
+
+// Resolve the rollover policy of a key's quota, returning the percent of
+// unused usage carried into the next quota period and the cap on the
+// carried usage (nil being the key's quota).
+// The key's own policy takes precedence over its service's.
+func ResolveQuotaRollover(keyPercent *int, keyCap *int, servicePercent *int, serviceCap *int) (int, *int) {
+	if keyPercent != nil {
+		return *keyPercent, keyCap
+	}
+	if servicePercent != nil {
+		return *servicePercent, serviceCap
+	}
+	return 0, nil
+}
+
+// Get the aggregation expression of the usage a key carries into its
+// next quota period: percent of its unused usage remaining, up to cap.
+func RolloverCarriedExpression(percent int, cap *int) interface{} {
+	if percent <= 0 {
+		return 0
+	}
+
+	var carriedCap interface{} = "$quota"
+	if cap != nil {
+		carriedCap = *cap
+	}
+
+	unused := bson.D{{Key: "$max", Value: bson.A{"$usage_remaining", 0}}}
+	carried := bson.D{{Key: "$toInt", Value: bson.D{{Key: "$floor", Value: bson.D{{Key: "$divide", Value: bson.A{bson.D{{Key: "$multiply", Value: bson.A{unused, percent}}}, 100}}}}}}}
+
+	return bson.D{{Key: "$min", Value: bson.A{carried, carriedCap}}}
+}
+
+// Get the usage a key carries into its next quota period, as
+// RolloverCarriedExpression does, given its quota and unused usage remaining.
+func RolloverCarried(quota int, usageRemaining int, percent int, cap *int) int {
+	if percent <= 0 || usageRemaining <= 0 {
+		return 0
+	}
+
+	carriedCap := quota
+	if cap != nil {
+		carriedCap = *cap
+	}
+
+	carried := usageRemaining * percent / 100
+	if carried > carriedCap {
+		return carriedCap
+	}
+	return carried
+}
+
+// Get the next quota timestamp of a quota period of numDays which
+// resets at resetHour local time in loc, given the current time.
+//
+// The current period began at the latest reset hour at or before now,
+// and the next period begins numDays calendar days later. Using calendar
+// days keeps the reset at the same local hour across DST transitions.
+func NextQuotaTimestamp(now time.Time, numDays int, loc *time.Location, resetHour int) time.Time {
+	if numDays <= 0 {
+		numDays = 1
+	}
+
+	local := now.In(loc)
+	day := local.Day()
+	if local.Hour() < resetHour {
+		// The current period began yesterday
+		day--
+	}
+
+	next := time.Date(local.Year(), local.Month(), day+numDays, resetHour, 0, 0, 0, loc)
+
+	// When DST skips the reset hour, reset at the first hour after it
+	for next.Hour() < resetHour {
+		next = next.Add(time.Hour)
+	}
+
+	return next
+}
+
+// Get the start of the next quota window of the given unit after t.
+// Windows are aligned to the calendar in loc, weeks start on Monday
+// and months start on the 1st.
+func NextQuotaWindowReset(unit string, t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch unit {
+	case "Minute":
+		return t.Truncate(time.Minute).Add(time.Minute)
+	case "Hour":
+		// @INFO: Add absolute hours so the repeated hour when DST ends is its own window
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+		for !next.After(t) {
+			next = next.Add(time.Hour)
+		}
+		return next
+	case "Day":
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+	case "Week":
+		daysUntilMonday := 7 - (int(t.Weekday())+6)%7
+		return time.Date(t.Year(), t.Month(), t.Day()+daysUntilMonday, 0, 0, 0, 0, loc)
+	case "Month":
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
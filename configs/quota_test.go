@@ -0,0 +1,121 @@
+package configs
+
+import (
+	"testing"
+	"time"
+)
+
+// In 2026, America/Chicago springs forward at 2:00 CST on March 8 (skipping
+// to 3:00 CDT) and falls back at 2:00 CDT on November 1 (repeating 1:00).
+func loadChicago(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := LoadQuotaLocation("America/Chicago")
+	if err != nil {
+		t.Fatalf("Unable to load America/Chicago: %v", err)
+	}
+	return loc
+}
+
+func parseUTC(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("Unable to parse %q: %v", value, err)
+	}
+	return parsed
+}
+
+func TestNextQuotaTimestampAcrossDST(t *testing.T) {
+	loc := loadChicago(t)
+
+	tests := []struct {
+		name      string
+		now       string
+		numDays   int
+		resetHour int
+		want      string
+	}{
+		// Spring forward
+		{"reset hour in the gap resets at the first hour after it", "2026-03-07T08:30:00Z", 1, 2, "2026-03-08T08:00:00Z"},
+		{"reset hour in the gap from just before the gap", "2026-03-08T07:30:00Z", 1, 2, "2026-03-08T08:00:00Z"},
+		{"reset hour in the gap after the transition", "2026-03-08T09:30:00Z", 1, 2, "2026-03-09T07:00:00Z"},
+		{"reset hour just after the gap", "2026-03-07T09:00:00Z", 1, 3, "2026-03-08T08:00:00Z"},
+		{"midnight reset on the short day", "2026-03-08T06:30:00Z", 1, 0, "2026-03-09T05:00:00Z"},
+		{"weekly period spanning the transition", "2026-03-05T16:00:00Z", 7, 0, "2026-03-12T05:00:00Z"},
+
+		// Fall back
+		{"reset hour in the repeated hour resets at its first occurrence", "2026-10-31T06:30:00Z", 1, 1, "2026-11-01T06:00:00Z"},
+		{"first occurrence of the repeated hour does not reset again", "2026-11-01T06:30:00Z", 1, 1, "2026-11-02T07:00:00Z"},
+		{"second occurrence of the repeated hour does not reset again", "2026-11-01T07:30:00Z", 1, 1, "2026-11-02T07:00:00Z"},
+		{"reset hour just after the repeated hour", "2026-10-31T07:00:00Z", 1, 2, "2026-11-01T08:00:00Z"},
+		{"midnight reset on the long day", "2026-11-01T12:00:00Z", 1, 0, "2026-11-02T06:00:00Z"},
+		{"weekly period spanning the transition", "2026-10-29T15:00:00Z", 7, 9, "2026-11-05T15:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NextQuotaTimestamp(parseUTC(t, test.now), test.numDays, loc, test.resetHour)
+			want := parseUTC(t, test.want)
+			if !got.Equal(want) {
+				t.Errorf("NextQuotaTimestamp(%s, %d, %d) = %s, want %s", test.now, test.numDays, test.resetHour, got.UTC().Format(time.RFC3339), test.want)
+			}
+		})
+	}
+}
+
+func TestNextQuotaWindowResetAcrossDST(t *testing.T) {
+	loc := loadChicago(t)
+
+	tests := []struct {
+		name string
+		unit string
+		t    string
+		want string
+	}{
+		// Spring forward
+		{"hour before the gap ends at the first hour after it", "Hour", "2026-03-08T07:30:00Z", "2026-03-08T08:00:00Z"},
+		{"hour after the gap", "Hour", "2026-03-08T08:30:00Z", "2026-03-08T09:00:00Z"},
+		{"day before the transition", "Day", "2026-03-07T18:00:00Z", "2026-03-08T06:00:00Z"},
+		{"short day ends at local midnight", "Day", "2026-03-08T18:00:00Z", "2026-03-09T05:00:00Z"},
+		{"week spanning the transition", "Week", "2026-03-05T18:00:00Z", "2026-03-09T05:00:00Z"},
+
+		// Fall back
+		{"hour before the repeated hour", "Hour", "2026-11-01T05:30:00Z", "2026-11-01T06:00:00Z"},
+		{"first occurrence of the repeated hour is its own window", "Hour", "2026-11-01T06:30:00Z", "2026-11-01T07:00:00Z"},
+		{"second occurrence of the repeated hour is its own window", "Hour", "2026-11-01T07:30:00Z", "2026-11-01T08:00:00Z"},
+		{"long day ends at local midnight", "Day", "2026-11-01T12:00:00Z", "2026-11-02T06:00:00Z"},
+		{"month ends at local midnight on the 1st", "Month", "2026-10-15T12:00:00Z", "2026-11-01T05:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NextQuotaWindowReset(test.unit, parseUTC(t, test.t), loc)
+			want := parseUTC(t, test.want)
+			if !got.Equal(want) {
+				t.Errorf("NextQuotaWindowReset(%s, %s) = %s, want %s", test.unit, test.t, got.UTC().Format(time.RFC3339), test.want)
+			}
+		})
+	}
+}
+
+func TestRolloverCarried(t *testing.T) {
+	cap := 30
+	cases := []struct {
+		name           string
+		usageRemaining int
+		percent        int
+		cap            *int
+		want           int
+	}{
+		{"no rollover", 80, 0, nil, 0},
+		{"overage", -5, 50, nil, 0},
+		{"rounds down", 75, 50, nil, 37},
+		{"capped at quota", 300, 100, nil, 100},
+		{"capped", 80, 50, &cap, 30},
+	}
+	for _, c := range cases {
+		if got := RolloverCarried(100, c.usageRemaining, c.percent, c.cap); got != c.want {
+			t.Errorf("%s: expected %d carried, got %d", c.name, c.want, got)
+		}
+	}
+}
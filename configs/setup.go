@@ -5,73 +5,90 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func InitConfig() {
 	rand.Seed(time.Now().UTC().UnixNano())
+	ConnectDB()
+	CreateIndexes()
 }
 
-func ConnectDB() *mongo.Client {
-	client, err := mongo.NewClient(options.Client().ApplyURI(GetEnvMongoURI()))
+// Create the client, which is only connected to the database by ConnectDB
+// @INFO: This allows packages to be loaded (e.g. by tests) without a database
+func NewDBClient() *mongo.Client {
+	clientOptions := options.Client()
+	if uri, exist := os.LookupEnv("MONGODB_URI"); exist {
+		clientOptions.ApplyURI(uri)
+	}
+
+	client, err := mongo.NewClient(clientOptions)
 	if err != nil {
 		log.Fatalf("Unable to create MongoDB client: %v", err)
 	}
+	return client
+}
+
+func ConnectDB() {
+	// Verify the uri is configured
+	GetEnvMongoURI()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err = client.Connect(ctx)
+	err := DB.Connect(ctx)
 	if err != nil {
 		log.Fatalf("Unable to connect to database: %v", err)
 	}
 
 	//ping the database
-	err = client.Ping(ctx, nil)
+	err = DB.Ping(ctx, nil)
 	if err != nil {
 		log.Fatalf("Unable to ping database: %v", err)
 	}
 	fmt.Println("Connected to MongoDB")
-	return client
+}
+
+// Indexes of each collection which must be unique, so concurrent requests
+// cannot both create a document (e.g. a second pending quota request)
+var uniqueIndexes = map[string][]mongo.IndexModel{
+	"plans": {
+		{
+			Keys:    bson.D{{Key: "plan_name", Value: 1}},
+			Options: options.Index().SetName("plan_name").SetUnique(true),
+		},
+	},
+	"quota_requests": {
+		{
+			Keys:    bson.D{{Key: "key_id", Value: 1}},
+			Options: options.Index().SetName("pending_key_id").SetUnique(true).SetPartialFilterExpression(bson.D{{Key: "status", Value: "Pending"}}),
+		},
+	},
+}
+
+// Create the unique indexes of each collection, should they not exist
+func CreateIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for collectionName, indexes := range uniqueIndexes {
+		_, err := GetCollection(DB, collectionName).Indexes().CreateMany(ctx, indexes)
+		if err != nil {
+			log.Fatalf("Unable to create indexes of %s: %v", collectionName, err)
+		}
+	}
 }
 
 // Client instance
-var DB *mongo.Client = ConnectDB()
+var DB *mongo.Client = NewDBClient()
 
 // getting database collections
 func GetCollection(client *mongo.Client, collectionName string) *mongo.Collection {
 	collection := client.Database("kmsDB").Collection(collectionName)
 	return collection
 }
-
-// creates a goroutine for refreshing keys' quotaTimestamp
-func RefreshUsageRemainingGoroutine() {
-	RefreshUsageRemainingOperation()
-
-	// Calculate the duration until the next midnight UTC
-	now := time.Now().UTC()
-	nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
-	duration := time.Until(nextMidnight)
-
-	// Create a ticker with the duration until the next midnight
-	ticker := time.NewTicker(duration)
-
-	go func() {
-		for {
-			<-ticker.C // Wait for the ticker to fire
-
-			// RefreshUsageRemainingOperation will be triggered every day exactly at midnight UTC
-			RefreshUsageRemainingOperation()
-			fmt.Println("Daily Quotas Refreshing...")
-
-			// Reset the ticker for the next day
-			now := time.Now().UTC()
-			nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
-			duration := time.Until(nextMidnight)
-			ticker.Reset(duration)
-		}
-	}()
-}
@@ -0,0 +1,30 @@
+package configs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Build the write model recording a key's consumption over its quota
+// period ending at periodEnd, given its quota details before the period
+// is reset. Periods are recorded once per key and periodEnd, so
+// recording a period again overwrites it.
+func UsageHistoryWriteModel(keyID primitive.ObjectID, periodEnd time.Time, quotaNumDays int, loc *time.Location, quota int, rolloverCarried int, usageRemaining int, now time.Time) mongo.WriteModel {
+	if quotaNumDays <= 0 {
+		quotaNumDays = 1
+	}
+	periodStart := periodEnd.In(loc).AddDate(0, 0, -quotaNumDays)
+
+	used := quota + rolloverCarried - usageRemaining
+	if used < 0 {
+		used = 0
+	}
+
+	filter := bson.D{{Key: "key_id", Value: keyID}, {Key: "period_end", Value: periodEnd}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "period_start", Value: periodStart}, {Key: "quota", Value: quota}, {Key: "rollover_carried", Value: rolloverCarried}, {Key: "used", Value: used}, {Key: "recorded_at", Value: now}}}}
+
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+}
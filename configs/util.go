@@ -2,11 +2,15 @@ package configs
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Layout for parsing Bson Date strings
@@ -40,21 +44,116 @@ func GenerateKey() string {
 	return sb.String()
 }
 
-func RefreshUsageRemainingOperation() {
+// Refreshes the usage remaining of keys whose quota period has ended.
+//
+// Each key's next quota_timestamp is calculated in the time zone and at
+// the reset hour of the key, or otherwise its service, so that quotas
+// reset at the same local time across DST transitions.
+//
+// Keys with a rollover policy, or whose service has one, carry part of
+// their unused usage into the next period (see ResolveQuotaRollover).
+//
+// Each key's consumption over the ended period is recorded in the
+// 'usage_history' collection before it is reset.
+//
+// Sliding quota keys have no periodic reset, and Credit quota keys
+// deduct from a balance which never resets, so both are skipped.
+//
+// This is run periodically by the job scheduler (see jobs/scheduler.go).
+func RefreshUsageRemainingOperation(ctx context.Context) error {
 
 	keyCollection := GetCollection(DB, "keys")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	usageHistoryCollection := GetCollection(DB, "usage_history")
 
-	matchQuotaTimestamps := bson.D{{Key: "$match", Value: bson.D{{Key: "quota_timestamp", Value: bson.D{{Key: "$lt", Value: time.Now()}}}}}}
-	setQuotaDetails := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_remaining", Value: "$quota"}, {Key: "updated_at", Value: time.Now()}, {Key: "quota_timestamp", Value: bson.D{{Key: "$dateAdd", Value: bson.D{{Key: "startDate", Value: bson.D{{Key: "$dateTrunc", Value: bson.D{{Key: "date", Value: time.Now()}, {Key: "unit", Value: "day"}}}}}, {Key: "unit", Value: "day"}, {Key: "amount", Value: "$quota_num_days"}}}}}}}}
-	mergeToKeysCollection := bson.D{{Key: "$merge", Value: "keys"}}
+	now := time.Now()
 
-	refreshQuotaPipeline := bson.A{matchQuotaTimestamps, setQuotaDetails, mergeToKeysCollection}
+	// Keys due for a refresh, along with their quota schedule
+	var dueKeys []struct {
+		ID               primitive.ObjectID `bson:"_id"`
+		QuotaTimestamp   time.Time          `bson:"quota_timestamp"`
+		QuotaNumDays     int                `bson:"quota_num_days"`
+		Quota            int                `bson:"quota"`
+		UsageRemaining   int                `bson:"usage_remaining"`
+		RolloverCarried  int                `bson:"rollover_carried"`
+		Timezone         string             `bson:"timezone"`
+		ResetHour        *int               `bson:"reset_hour"`
+		ServiceTimezone  string             `bson:"service_timezone"`
+		ServiceResetHour *int               `bson:"service_reset_hour"`
 
-	_, err := keyCollection.Aggregate(ctx, refreshQuotaPipeline)
+		RolloverPercent        *int `bson:"rollover_percent"`
+		RolloverCap            *int `bson:"rollover_cap"`
+		ServiceRolloverPercent *int `bson:"service_rollover_percent"`
+		ServiceRolloverCap     *int `bson:"service_rollover_cap"`
+	}
+
+	// @INFO: Sliding and Credit quota keys have no periodic reset
+	matchQuotaTimestamps := bson.D{{Key: "$match", Value: bson.D{{Key: "quota_timestamp", Value: bson.D{{Key: "$lt", Value: now}}}, {Key: "quota_mode", Value: bson.D{{Key: "$nin", Value: bson.A{"Sliding", "Credit"}}}}}}}
+	lookupService := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "services"}, {Key: "localField", Value: "service_id"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "service"}}}}
+	projectQuotaSchedule := bson.D{{Key: "$project", Value: bson.D{{Key: "quota_timestamp", Value: 1}, {Key: "quota_num_days", Value: 1}, {Key: "quota", Value: 1}, {Key: "usage_remaining", Value: 1}, {Key: "rollover_carried", Value: 1}, {Key: "timezone", Value: 1}, {Key: "reset_hour", Value: 1}, {Key: "service_timezone", Value: bson.D{{Key: "$first", Value: "$service.timezone"}}}, {Key: "service_reset_hour", Value: bson.D{{Key: "$first", Value: "$service.reset_hour"}}}, {Key: "rollover_percent", Value: 1}, {Key: "rollover_cap", Value: 1}, {Key: "service_rollover_percent", Value: bson.D{{Key: "$first", Value: "$service.rollover_percent"}}}, {Key: "service_rollover_cap", Value: bson.D{{Key: "$first", Value: "$service.rollover_cap"}}}}}}
+
+	dueKeysPipeline := bson.A{matchQuotaTimestamps, lookupService, projectQuotaSchedule}
+
+	cursor, err := keyCollection.Aggregate(ctx, dueKeysPipeline)
 	if err != nil {
-		// @TODO: Log Error
-		panic(err)
+		return fmt.Errorf("unable to find keys due for a refresh: %w", err)
+	}
+	err = cursor.All(ctx, &dueKeys)
+	if err != nil {
+		return fmt.Errorf("unable to find keys due for a refresh: %w", err)
+	}
+
+	if len(dueKeys) == 0 {
+		return nil
 	}
+
+	// Reset each key's usage remaining and advance its quota timestamp
+	historyModels := []mongo.WriteModel{}
+	refreshModels := []mongo.WriteModel{}
+	for _, dueKey := range dueKeys {
+		loc, resetHour := ResolveQuotaSchedule(dueKey.Timezone, dueKey.ResetHour, dueKey.ServiceTimezone, dueKey.ServiceResetHour)
+		historyModels = append(historyModels, UsageHistoryWriteModel(dueKey.ID, dueKey.QuotaTimestamp, dueKey.QuotaNumDays, loc, dueKey.Quota, dueKey.RolloverCarried, dueKey.UsageRemaining, now))
+
+		quotaTimestamp := NextQuotaTimestamp(now, dueKey.QuotaNumDays, loc, resetHour)
+		rolloverPercent, rolloverCap := ResolveQuotaRollover(dueKey.RolloverPercent, dueKey.RolloverCap, dueKey.ServiceRolloverPercent, dueKey.ServiceRolloverCap)
+
+		// @INFO: The carried usage is calculated from the usage remaining before it is reset
+		setRolloverCarried := bson.D{{Key: "$set", Value: bson.D{{Key: "rollover_carried", Value: RolloverCarriedExpression(rolloverPercent, rolloverCap)}}}}
+		setQuotaDetails := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_remaining", Value: bson.D{{Key: "$add", Value: bson.A{"$quota", "$rollover_carried"}}}}, {Key: "notified_thresholds", Value: bson.A{}}, {Key: "updated_at", Value: now}, {Key: "quota_timestamp", Value: quotaTimestamp}}}}
+
+		// @INFO: Matching the previous quota_timestamp skips keys updated since they were found
+		refreshModel := mongo.NewUpdateOneModel().SetFilter(bson.D{{Key: "_id", Value: dueKey.ID}, {Key: "quota_timestamp", Value: dueKey.QuotaTimestamp}}).SetUpdate(bson.A{setRolloverCarried, setQuotaDetails})
+		refreshModels = append(refreshModels, refreshModel)
+	}
+
+	// Record usage history before the usage is reset
+	_, err = usageHistoryCollection.BulkWrite(ctx, historyModels, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("unable to record usage history: %w", err)
+	}
+
+	_, err = keyCollection.BulkWrite(ctx, refreshModels, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("unable to refresh keys: %w", err)
+	}
+
+	return nil
+}
+
+// Permanently deletes keys which were deleted longer ago than the
+// retention window (see GetEnvKeyRetention), after which they can no
+// longer be restored.
+//
+// This is run periodically by the job scheduler (see jobs/scheduler.go).
+func PurgeDeletedKeysOperation(ctx context.Context) error {
+
+	keyCollection := GetCollection(DB, "keys")
+
+	purgeBefore := time.Now().Add(-GetEnvKeyRetention())
+
+	_, err := keyCollection.DeleteMany(ctx, bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$lte", Value: purgeBefore}}}})
+	if err != nil {
+		return fmt.Errorf("unable to purge deleted keys: %w", err)
+	}
+
+	return nil
 }
@@ -12,11 +12,30 @@
 * Should the key be valid, be active, have usage remaining, and be for
 * the requested service, then access should be granted.
 *
+* Keys with a 'Sliding' quota mode have their usage counted over a
+* rolling window rather than reset periodically, and keys may carry
+* additional calendar-aligned quota windows (see controllers/quota.go).
+* Keys whose quota period has ended are reset as they are consumed.
+* Services may limit the requests of all of their keys with an aggregate
+* quota, which is reported as 'Service quota reached' when exhausted.
+* Keys in a pool also draw from the pool's shared quota, which is
+* reported as 'Pool quota reached' when exhausted, and 'pool_only' keys
+* draw only from the pool rather than from their own quota as well.
+* Suspended keys are reported as 'Key is suspended', along with the
+* category and message of the suspension, and 'RetryAfter' should the
+* suspension be lifted automatically.
+* Keys with a 'Credit' quota mode deduct from their prepaid credit
+* balance, which is reported as 'Credit balance exhausted' when empty.
+* When a key's quota is reached, 'RetryAfter' informs how many seconds
+* remain until it has quota again.
+*
 * The 'IsAllowed' field of the response informs whether the request
 * should be granted.
 *
 * NOTE: Basic keys are for any service of service type 'Basic' while
-*       Advanced keys are for a specific service.
+*       Advanced keys are for a specific service. Test keys only reach
+*       Staging services, and Live keys only reach the others (see
+*       controllers/environment.go).
 *
 * Written by Adam Brunn (amb150230) at The University of Texas at Dallas
 * for CS4485.0W1 (Nebula Platform CS Project) starting March 10, 2023.
@@ -26,12 +45,15 @@ package controllers
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/UTDNebula/kms/configs"
 	"github.com/UTDNebula/kms/models"
 	"github.com/UTDNebula/kms/responses"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/exp/slices"
 
@@ -70,7 +92,8 @@ func Allowed() gin.HandlerFunc {
 		// enables the database operation to be atomic, which is very much prefered.
 
 		// Find Key
-		err := keyCollection.FindOne(ctx, bson.D{{Key: "key", Value: authKey}}).Decode(&key)
+		// @INFO: Deleted keys are treated as invalid keys
+		err := keyCollection.FindOne(ctx, bson.D{{Key: "key", Value: authKey}, {Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}}).Decode(&key)
 		if err != nil {
 			// Invalid Key
 			if err == mongo.ErrNoDocuments {
@@ -82,9 +105,34 @@ func Allowed() gin.HandlerFunc {
 			return
 		}
 
+		now := time.Now()
+
+		// Key is suspended
+		// @INFO: Checked before quota, so the key's owner is told why it was suspended
+		if index := activeKeySuspension(key); index != -1 {
+			suspension := key.Suspensions[index]
+			res := responses.AllowedResponse{Status: http.StatusOK, Message: "Key is suspended", IsAllowed: false, SuspensionCategory: suspension.Category, SuspensionMessage: suspension.Message}
+			if !suspension.LiftAt.IsZero() {
+				res.RetryAfter = retryAfterSeconds(suspension.LiftAt.Sub(now))
+			}
+			c.JSON(http.StatusOK, res)
+			return
+		}
+
 		// Key has no usage remaining
-		if key.UsageRemaining <= 0 {
-			c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Quota reached", IsAllowed: false})
+		// @INFO: Sliding quota keys are checked atomically when consuming usage,
+		// and keys whose quota period has ended are reset when consuming usage
+		// @INFO: Keys can make their overage of requests beyond their quota
+		// @INFO: Pool only keys do not draw from their own quota
+		if !key.PoolOnly && key.QuotaMode != "Sliding" && key.QuotaMode != "Credit" && key.UsageRemaining <= -key.Overage && now.Before(key.QuotaTimestamp) {
+			c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Quota reached", IsAllowed: false, RetryAfter: retryAfterSeconds(key.QuotaTimestamp.Sub(now))})
+			return
+		}
+
+		// Key has no credit remaining
+		// @INFO: Credit balances never reset, so there is no time to retry after
+		if !key.PoolOnly && key.QuotaMode == "Credit" && key.CreditBalance <= 0 {
+			c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Credit balance exhausted", IsAllowed: false})
 			return
 		}
 
@@ -99,7 +147,12 @@ func Allowed() gin.HandlerFunc {
 			// Here we check for an overlap between the basic services and the sourceIdentifier.
 			// Since we already know the key is valid for all basic services,
 			// we just need to check if they are requesting a valid basic service.
-			err = serviceCollection.FindOne(ctx, bson.D{{Key: "service_type", Value: "Basic"}, {Key: "source_identifiers", Value: sourceIdentifier}}).Decode(&service)
+			// @INFO: Test basic keys are instead for any Staging service
+			serviceType := "Basic"
+			if keyEnvironment(key) == "Test" {
+				serviceType = "Staging"
+			}
+			err = serviceCollection.FindOne(ctx, bson.D{{Key: "service_type", Value: serviceType}, {Key: "source_identifiers", Value: sourceIdentifier}}).Decode(&service)
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
 					c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Invalid Requested-service", IsAllowed: false})
@@ -123,18 +176,179 @@ func Allowed() gin.HandlerFunc {
 				c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Invalid Requested-service", IsAllowed: false})
 				return
 			}
+
+			// Verify key environment matches the service
+			if err := verifyKeyEnvironment(keyEnvironment(key), service); err != nil {
+				c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: err.Error(), IsAllowed: false})
+				return
+			}
+		}
+
+		// Time zone, reset hour, and rollover policy of the key's quota
+		// @INFO: Basic keys are not for a specific service, so only use their own
+		quotaService := service
+		if key.Type == "Basic" {
+			quotaService = models.Service{}
 		}
+		loc, resetHour := configs.ResolveQuotaSchedule(key.Timezone, key.ResetHour, quotaService.Timezone, quotaService.ResetHour)
+		rolloverPercent, rolloverCap := configs.ResolveQuotaRollover(key.RolloverPercent, key.RolloverCap, quotaService.RolloverPercent, quotaService.RolloverCap)
 
-		// Update key's usage remaining
-		key.UsageRemaining -= 1
-		updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_remaining", Value: key.UsageRemaining}, {Key: "last_used", Value: time.Now()}}}}
-		_, err = keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}}, updateKey)
+		// Consume usage of the service's aggregate quota
+		service, consumed, err := consumeServiceAggregateQuota(ctx, service, now)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
 			return
 		}
+		if !consumed {
+			c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Service quota reached", IsAllowed: false, RetryAfter: retryAfterSeconds(service.AggregateQuotaTimestamp.Sub(now))})
+			return
+		}
+
+		// Consume usage of the key's pool
+		var pool models.Pool
+		if key.PoolID != primitive.NilObjectID {
+			pool, consumed, err = consumePoolQuota(ctx, key.PoolID, service, now)
+			if err != nil {
+				refundFailedRequestUsage(ctx, service, models.Pool{}, models.Key{})
+				c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+				return
+			}
+			if !consumed {
+				// Return the usage consumed from the service's aggregate quota
+				err = refundConsumedUsage(ctx, service, models.Pool{}, models.Key{})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+					return
+				}
+				c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Pool quota reached", IsAllowed: false, RetryAfter: retryAfterSeconds(pool.QuotaTimestamp.Sub(now))})
+				return
+			}
+		}
+
+		// Consume usage of the key's quota windows
+		key, consumed, err = consumeQuotaWindows(ctx, key, now, loc)
+		if err != nil {
+			refundFailedRequestUsage(ctx, service, pool, models.Key{})
+			c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+			return
+		}
+		if !consumed {
+			// Return the usage consumed from the service's aggregate quota and the key's pool
+			// @INFO: The key's quota windows were not consumed, so none are refunded
+			err = refundConsumedUsage(ctx, service, pool, models.Key{})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+				return
+			}
+			c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Quota reached", IsAllowed: false, RetryAfter: retryAfterSeconds(quotaWindowsRetryAfter(key, now))})
+			return
+		}
+
+		// @INFO: Pool only keys draw only from their pool, not their own quota
+		if key.QuotaMode == "Sliding" && !key.PoolOnly {
+			// Consume usage within the key's sliding window
+			key, consumed, err = consumeSlidingWindowQuota(ctx, key, now)
+			if err != nil {
+				refundFailedRequestUsage(ctx, service, pool, key)
+				c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+				return
+			}
+			if !consumed {
+				// Return the usage consumed from the service's aggregate quota, the key's pool, and the key's quota windows
+				err = refundConsumedUsage(ctx, service, pool, key)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+					return
+				}
+				c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Quota reached", IsAllowed: false, RetryAfter: retryAfterSeconds(slidingWindowRetryAfter(key, now))})
+				return
+			}
+		} else if key.QuotaMode == "Credit" && !key.PoolOnly {
+			// Deduct a credit from the key's credit balance
+			key, consumed, err = consumeCreditBalance(ctx, key, now)
+			if err != nil {
+				refundFailedRequestUsage(ctx, service, pool, key)
+				c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+				return
+			}
+			if !consumed {
+				// Return the usage consumed from the service's aggregate quota, the key's pool, and the key's quota windows
+				err = refundConsumedUsage(ctx, service, pool, key)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+					return
+				}
+				c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Credit balance exhausted", IsAllowed: false})
+				return
+			}
+
+			// Notify the owner of any credit alert balances reached
+			notifyCreditAlertBalances(ctx, key)
+		} else if !key.PoolOnly {
+			// Consume usage of the key's quota period, resetting it should it have ended
+			key, consumed, err = consumeFixedQuota(ctx, key, now, loc, resetHour, rolloverPercent, rolloverCap)
+			if err != nil {
+				refundFailedRequestUsage(ctx, service, pool, key)
+				c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+				return
+			}
+			if !consumed {
+				// Return the usage consumed from the service's aggregate quota, the key's pool, and the key's quota windows
+				err = refundConsumedUsage(ctx, service, pool, key)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, responses.AllowedResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error(), IsAllowed: false})
+					return
+				}
+				c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "Quota reached", IsAllowed: false, RetryAfter: retryAfterSeconds(key.QuotaTimestamp.Sub(now))})
+				return
+			}
+
+			// Notify the owner of any quota thresholds reached
+			// @INFO: Sliding quota keys have no period to notify once within
+			notifyQuotaThresholds(ctx, key)
+		}
+
+		// Record the key was used, should it not have consumed its own quota
+		// @INFO: Otherwise the inactivity policy would disable pool only keys in use
+		if key.PoolOnly {
+			err = recordKeyUsed(ctx, key, now)
+			if err != nil {
+				log.Printf("Unable to record key %s was used: %v", key.ID.Hex(), err)
+			}
+		}
 
 		// Authorization Granted
 		c.JSON(http.StatusOK, responses.AllowedResponse{Status: http.StatusOK, Message: "success", IsAllowed: true})
 	}
 }
+
+// Convert a duration until a key has quota again into whole seconds, rounding up
+func retryAfterSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// Return the usage a denied request consumed from the service's aggregate quota, the key's pool,
+// and the key's quota windows, skipping any the request did not consume (e.g. an empty pool)
+func refundConsumedUsage(ctx context.Context, service models.Service, pool models.Pool, key models.Key) error {
+	err := refundServiceAggregateQuota(ctx, service)
+	if err != nil {
+		return err
+	}
+	err = refundPoolQuota(ctx, pool)
+	if err != nil {
+		return err
+	}
+	return refundQuotaWindows(ctx, key)
+}
+
+// Return the usage a request which failed consumed, as refundConsumedUsage does,
+// logging should it not be returned so the request's own error is reported
+func refundFailedRequestUsage(ctx context.Context, service models.Service, pool models.Pool, key models.Key) {
+	err := refundConsumedUsage(ctx, service, pool, key)
+	if err != nil {
+		log.Printf("Unable to return the usage consumed by a failed request: %v", err)
+	}
+}
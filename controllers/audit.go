@@ -0,0 +1,200 @@
+/**************************************************************************
+* Audit endpoint logic.
+*
+* Every management action performed through the kms (key creation,
+* disabling, quota changes, ownership transfers, etc.) records an
+* append-only audit event in the 'audit_events' collection describing
+* who did what, to which key, user, or service, and the values of the
+* changed fields before and after the action.
+*
+* Admins can view all audit events.
+* Leads can only view audit events for services they are leads for,
+* including those of keys moved to or from their services.
+*
+* Reponses are built using responses/audit_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var auditCollection *mongo.Collection = configs.GetCollection(configs.DB, "audit_events")
+
+// Default and maximum number of audit events returned by GetAuditEvents
+const defaultAuditEventLimit = 100
+const maxAuditEventLimit = 1000
+
+// Match events targeting the given service(s), including keys moved from it
+func auditServiceFilter(service interface{}) bson.E {
+	return bson.E{Key: "$or", Value: bson.A{bson.D{{Key: "target_service_id", Value: service}}, bson.D{{Key: "previous_target_service_id", Value: service}}}}
+}
+
+/**************************************************************************
+* Record Audit Event
+* This fills in the id, timestamp, and request metadata of the given
+* event and appends it to the audit log.
+*
+* Events recorded by background jobs are given no request (c is nil),
+* and so have no request metadata.
+*
+* Failing to record an event does not undo the action being audited,
+* so errors are logged rather than returned to the client.
+**************************************************************************/
+func recordAuditEvent(ctx context.Context, c *gin.Context, event models.AuditEvent) {
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now().UTC()
+	if c != nil {
+		event.RequestMetadata = models.AuditRequestMetadata{
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+	}
+
+	_, err := auditCollection.InsertOne(ctx, event)
+	if err != nil {
+		log.Printf("Unable to record audit event '%s' by %s: %v", event.Action, event.ActorUserID.Hex(), err)
+	}
+}
+
+/**************************************************************************
+* Get Audit Events
+* This returns the audit events the user (user_id) has permissions
+* to view, most recent first.
+*
+* Results can optionally be filtered by key_id, service_id, action,
+* and limited in number (limit).
+**************************************************************************/
+func GetAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var events []models.AuditEvent
+
+		filter := bson.D{}
+		limit := defaultAuditEventLimit
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.AuditResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.AuditResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID (optional)
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if exists {
+			keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.AuditResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			filter = append(filter, bson.E{Key: "target_key_id", Value: keyID})
+		}
+
+		// Get serviceID (optional)
+		var serviceID primitive.ObjectID
+		serviceIDQuery, filterByService := c.GetQuery("service_id")
+		if filterByService {
+			serviceID, err = primitive.ObjectIDFromHex(serviceIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.AuditResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		// Get action (optional)
+		action, exists := c.GetQuery("action")
+		if exists {
+			filter = append(filter, bson.E{Key: "action", Value: action})
+		}
+
+		// Get limit (optional)
+		limitQuery, exists := c.GetQuery("limit")
+		if exists {
+			limit, err = strconv.Atoi(limitQuery)
+			if err != nil || limit <= 0 || limit > maxAuditEventLimit {
+				c.JSON(http.StatusBadRequest, responses.AuditResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid limit: Must be between 1 and " + strconv.Itoa(maxAuditEventLimit)})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.AuditResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.AuditResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Scope events by user type
+		if user.Type == "Admin" {
+			if filterByService {
+				filter = append(filter, auditServiceFilter(serviceID))
+			}
+		} else if user.Type == "Lead" {
+			if filterByService {
+				if !slices.Contains(user.Services, serviceID) {
+					c.JSON(http.StatusConflict, responses.AuditResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to view audit events for the given service"})
+					return
+				}
+				filter = append(filter, auditServiceFilter(serviceID))
+			} else {
+				if len(user.Services) == 0 { // Short circuit
+					c.JSON(http.StatusOK, responses.AuditResponse{Status: http.StatusOK, Message: "success", Data: []models.AuditEvent{}})
+					return
+				}
+				filter = append(filter, auditServiceFilter(bson.D{{Key: "$in", Value: user.Services}}))
+			}
+		} else {
+			c.JSON(http.StatusConflict, responses.AuditResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not a Lead or Admin"})
+			return
+		}
+
+		// Find events, most recent first
+		findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+		cursor, err := auditCollection.Find(ctx, filter, findOptions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.AuditResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		events = []models.AuditEvent{}
+		err = cursor.All(ctx, &events)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.AuditResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.AuditResponse{Status: http.StatusOK, Message: "success", Data: events})
+	}
+}
@@ -0,0 +1,345 @@
+/**************************************************************************
+* Bulk key endpoint logic.
+*
+* This enables Leads and Admins to act on every key matching a filter at
+* once (e.g. disabling every key of a compromised service), rather than
+* calling the key endpoints once per key.
+*
+* Keys are matched by service (service_id), owner (owner_id), type
+* (key_type), and tags, being key labels matched by label (label, e.g.
+* label=app=portal, see parseKeyLabelFilters), of which at least one
+* must be given. Deleted keys are never matched.
+*
+* The usual permission rules apply to each key, as in controllers/key.go:
+* Admins can act on any key. Leads can only act on advanced keys for
+* services they are leads for. Keys the user cannot act on are skipped.
+*
+* Each key is only updated should it be unchanged since it was matched,
+* so no 'updated_at' is required. The result of each key is returned.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of keys a single bulk operation can act on
+const maxBulkKeys = 1000
+
+// Result of a bulk operation on a single key
+// @TODO: Refactor to key_response type
+type bulkKeyResult struct {
+	KeyID     primitive.ObjectID `json:"key_id"`
+	Outcome   string             `json:"outcome"` // @TODO: Enum (?) (Succeeded, Skipped, Failed)
+	Message   string             `json:"message,omitempty"`
+	UpdatedAt string             `json:"updated_at,omitempty"`
+}
+
+/**************************************************************************
+* Bulk Update Keys
+* This enables Leads and Admins (user_id) to apply an operation
+* (operation) to every key matching the given filter:
+*  - 'Disable'      : Disables the key, keeping it disabled once its
+*                     suspension is lifted and its schedule windows end.
+*  - 'Enable'       : Enables the key.
+*  - 'SetQuota'     : Sets the key's quota (quota), and optionally its
+*                     quota_num_days, restarting its quota period.
+*  - 'RestoreQuota' : Restores the key's usage remaining to its quota.
+*
+* Returns the number of keys with each outcome and the result of each key.
+**************************************************************************/
+func BulkUpdateKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var keys []models.Key
+
+		// @INFO: Acting on many keys can take longer than other requests
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get operation
+		operation, exists := c.GetQuery("operation")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'operation' field"})
+			return
+		}
+		if operation != "Disable" && operation != "Enable" && operation != "SetQuota" && operation != "RestoreQuota" {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid operation. Must be 'Disable', 'Enable', 'SetQuota', or 'RestoreQuota'"})
+			return
+		}
+
+		// Get quota and quotaNumDays of SetQuota
+		var quota int
+		var quotaNumDays int
+		if operation == "SetQuota" {
+			quotaStr, exists := c.GetQuery("quota")
+			if !exists {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'quota' field"})
+				return
+			}
+			quota, err = strconv.Atoi(quotaStr)
+			if err != nil || quota < 0 {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota: Must not be negative"})
+				return
+			}
+
+			quotaNumDaysStr, exists := c.GetQuery("quota_num_days")
+			if exists {
+				quotaNumDays, err = strconv.Atoi(quotaNumDaysStr)
+				if err != nil || quotaNumDays <= 0 {
+					c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota_num_days: Must be positive"})
+					return
+				}
+			}
+		}
+
+		// Build key filter
+		keyFilter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}}
+		filtered := false
+
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if exists {
+			serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			keyFilter = append(keyFilter, bson.E{Key: "service_id", Value: serviceID})
+			filtered = true
+		}
+
+		ownerIDQuery, exists := c.GetQuery("owner_id")
+		if exists {
+			ownerID, err := primitive.ObjectIDFromHex(ownerIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			keyFilter = append(keyFilter, bson.E{Key: "owner_id", Value: ownerID})
+			filtered = true
+		}
+
+		keyType, exists := c.GetQuery("key_type")
+		if exists {
+			if keyType != "Basic" && keyType != "Advanced" {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid key_type. Must be 'Basic' or 'Advanced'"})
+				return
+			}
+			keyFilter = append(keyFilter, bson.E{Key: "key_type", Value: keyType})
+			filtered = true
+		}
+
+		labelConditions, err := parseKeyLabelFilters(c.QueryArray("label"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+		if len(labelConditions) > 0 {
+			keyFilter = append(keyFilter, bson.E{Key: "$and", Value: labelConditions})
+			filtered = true
+		}
+
+		// @INFO: Prevents acting on every key by mistake
+		if !filtered {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include at least one of the 'service_id', 'owner_id', 'key_type', or 'label' fields"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Only Leads and Admins can act on keys in bulk
+		if user.Type != "Admin" && user.Type != "Lead" {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to update keys in bulk"})
+			return
+		}
+
+		// Get matching keys
+		count, err := keyCollection.CountDocuments(ctx, keyFilter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if count > maxBulkKeys {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "The filter matches " + strconv.FormatInt(count, 10) + " keys, more than the " + strconv.Itoa(maxBulkKeys) + " a bulk operation can act on"})
+			return
+		}
+		cursor, err := keyCollection.Find(ctx, keyFilter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		err = cursor.All(ctx, &keys)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Apply the operation to each key
+		counts := map[string]int{"Succeeded": 0, "Skipped": 0, "Failed": 0}
+		results := []bulkKeyResult{}
+		for _, key := range keys {
+			result := bulkUpdateKey(ctx, c, user, key, operation, quota, quotaNumDays)
+			counts[result.Outcome]++
+			results = append(results, result)
+		}
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Operation string          `json:"operation"`
+			Matched   int             `json:"matched"`
+			Counts    map[string]int  `json:"counts"`
+			Results   []bulkKeyResult `json:"results"`
+		}{
+			Operation: operation,
+			Matched:   len(keys),
+			Counts:    counts,
+			Results:   results,
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Bulk Update Key
+* This applies the bulk operation to a single key on behalf of the user,
+* should they have the authority to, recording an audit event as the
+* single key endpoints do.
+**************************************************************************/
+func bulkUpdateKey(ctx context.Context, c *gin.Context, user models.User, key models.Key, operation string, quota int, quotaNumDays int) bulkKeyResult {
+	result := bulkKeyResult{KeyID: key.ID}
+
+	// Check if user is an Admin, or a lead of the key's service
+	if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+		result.Outcome = "Skipped"
+		result.Message = "The given user does not have the authority to update this key"
+		return result
+	}
+
+	now := time.Now().UTC()
+	var action string
+	var before bson.M
+	var after bson.M
+	var update bson.D
+
+	switch operation {
+	case "Disable", "Enable":
+		isActive := operation == "Enable"
+		if key.IsActive && isActive {
+			result.Outcome = "Skipped"
+			result.Message = "Key is already enabled"
+			return result
+		}
+		// @INFO: Suspended or scheduled keys can be disabled, which leaves them disabled once their suspension is lifted and windows end
+		suspensionIndex := activeKeySuspension(key)
+		if !key.IsActive && !isActive && !keyDisabledTemporarily(key) {
+			result.Outcome = "Skipped"
+			result.Message = "Key is already disabled"
+			return result
+		}
+		if isActive && suspensionIndex != -1 {
+			result.Outcome = "Skipped"
+			result.Message = "Key is suspended"
+			return result
+		}
+		action = operation + "Key"
+		before = bson.M{"is_active": key.IsActive}
+		after = bson.M{"is_active": isActive}
+		setKey := bson.D{{Key: "updated_at", Value: now}, {Key: "is_active", Value: isActive}}
+		if !isActive && suspensionIndex != -1 {
+			setKey = append(setKey, keepSuspendedKeyDisabled(suspensionIndex))
+			after["suspension_id"] = key.Suspensions[suspensionIndex].ID
+		}
+		if scheduleFields, scheduleIDs := keepScheduledKeyDisabled(key); !isActive && len(scheduleIDs) > 0 {
+			setKey = append(setKey, scheduleFields...)
+			after["schedule_ids"] = scheduleIDs
+		}
+		update = bson.D{{Key: "$set", Value: setKey}}
+
+	case "SetQuota":
+		if quotaNumDays == 0 {
+			quotaNumDays = key.QuotaNumDays
+		}
+		loc, resetHour, err := findKeyQuotaSchedule(ctx, key)
+		if err != nil {
+			result.Outcome = "Failed"
+			result.Message = err.Error()
+			return result
+		}
+		quotaTimestamp := configs.NextQuotaTimestamp(now, quotaNumDays, loc, resetHour)
+		// @INFO: Keys keep following their plan should their quota be unchanged
+		quotaOverride := key.QuotaOverride || (key.PlanID != primitive.NilObjectID && (quota != key.Quota || quotaNumDays != key.QuotaNumDays))
+
+		action = "SetKeyQuota"
+		before = bson.M{"quota_override": key.QuotaOverride, "quota": key.Quota, "quota_num_days": key.QuotaNumDays, "usage_remaining": key.UsageRemaining, "quota_timestamp": key.QuotaTimestamp}
+		after = bson.M{"quota_override": quotaOverride, "quota": quota, "quota_num_days": quotaNumDays, "usage_remaining": quota, "quota_timestamp": quotaTimestamp}
+		update = bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "quota_override", Value: quotaOverride}, {Key: "quota", Value: quota}, {Key: "quota_num_days", Value: quotaNumDays}, {Key: "usage_buckets", Value: bson.A{}}, {Key: "rollover_carried", Value: 0}, {Key: "quota_timestamp", Value: quotaTimestamp}, {Key: "usage_remaining", Value: quota}, {Key: "notified_thresholds", Value: bson.A{}}}}}
+
+	case "RestoreQuota":
+		usageRemaining := key.Quota + key.RolloverCarried
+
+		action = "RestoreKeyQuota"
+		before = bson.M{"usage_remaining": key.UsageRemaining}
+		after = bson.M{"usage_remaining": usageRemaining}
+		update = bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "usage_remaining", Value: usageRemaining}, {Key: "usage_buckets", Value: bson.A{}}, {Key: "notified_thresholds", Value: bson.A{}}}}}
+	}
+
+	// @INFO: Matching the updated_at leaves keys updated since they were matched
+	updateResult, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: key.UpdatedAt}}, update)
+	if err != nil {
+		result.Outcome = "Failed"
+		result.Message = err.Error()
+		return result
+	}
+	if updateResult.ModifiedCount == 0 {
+		result.Outcome = "Failed"
+		result.Message = "Out of date request: Key has been updated"
+		return result
+	}
+
+	// Record audit event
+	recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: user.ID, Action: action, TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: after})
+
+	result.Outcome = "Succeeded"
+	result.UpdatedAt = now.Format(configs.DateLayout)
+	return result
+}
@@ -0,0 +1,449 @@
+/**************************************************************************
+* Consistency endpoint logic.
+*
+* References between the users, keys, and services collections are
+* written separately and can drift apart. This checks the following
+* invariants, reporting violations by category:
+*  - 'KeyOwnerMissing'          : A key's owner_id must be an existing user.
+*  - 'KeyOwnerReferenceMissing' : A key must be its owner's basic_key (or
+*                                 test_basic_key, for Test keys), or be
+*                                 listed in its owner's advanced_keys.
+*  - 'UserKeyReferenceInvalid'  : A user's basic_key, test_basic_key, and
+*                                 advanced_keys must be existing keys of
+*                                 that type and environment they own.
+*  - 'KeyServiceMissing'        : An advanced key's service_id must be an
+*                                 existing service.
+*  - 'LeadServiceMissing'       : A user's services must be existing services.
+*  - 'BasicKeyHasService'       : Basic keys must have no service_id.
+*
+* Deleted keys are not listed by their owners, so are only checked for
+* stale references to them.
+*
+* Should fix be 'true', violations with a safe repair are repaired and
+* the report of what changed is stored in the 'consistency_reports'
+* collection. Violations without a safe repair (e.g. a missing owner)
+* are only reported, as repairing them requires a decision by an Admin.
+*
+* Only Admins can check consistency.
+*
+* Reponses are built using responses/consistency_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var consistencyReportCollection *mongo.Collection = configs.GetCollection(configs.DB, "consistency_reports")
+
+// Number of consistency reports returned by GetConsistencyReports
+const consistencyReportLimit = 20
+
+// A consistency violation, along with the update which safely repairs it, should there be one
+type consistencyRepair struct {
+	violation  models.ConsistencyViolation
+	collection *mongo.Collection
+	filter     bson.D
+	update     bson.D
+
+	// Keys which, should one now exist, make the violation valid, so the repair is no longer safe
+	validKeyFilter bson.D
+}
+
+/**************************************************************************
+* Find Consistency Violations
+* This scans the users, keys, and services collections for violations
+* of the invariants described above.
+*
+* Each violation is returned along with the update which safely repairs
+* it, should there be one. Repair filters match the values found by the
+* scan, so documents updated since are left as they are. Repairs of a
+* user's key references are also skipped should the key have since
+* become valid (e.g. been restored), as it is in the keys collection.
+**************************************************************************/
+func findConsistencyViolations(ctx context.Context) ([]consistencyRepair, error) {
+
+	var users []models.User
+	var keys []models.Key
+	var services []models.Service
+
+	// Get the references of each document
+	cursor, err := userCollection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.D{{Key: "user_type", Value: 1}, {Key: "basic_key", Value: 1}, {Key: "test_basic_key", Value: 1}, {Key: "advanced_keys", Value: 1}, {Key: "services", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err = keyCollection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.D{{Key: "key_type", Value: 1}, {Key: "environment", Value: 1}, {Key: "owner_id", Value: 1}, {Key: "service_id", Value: 1}, {Key: "deleted_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err = serviceCollection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	err = cursor.All(ctx, &services)
+	if err != nil {
+		return nil, err
+	}
+
+	usersByID := map[primitive.ObjectID]models.User{}
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+	keysByID := map[primitive.ObjectID]models.Key{}
+	for _, key := range keys {
+		keysByID[key.ID] = key
+	}
+	serviceExists := map[primitive.ObjectID]bool{}
+	for _, service := range services {
+		serviceExists[service.ID] = true
+	}
+
+	// Whether the user validly references the key as the given key type
+	ownsKey := func(userID primitive.ObjectID, keyID primitive.ObjectID, keyType string) bool {
+		key, exists := keysByID[keyID]
+		return exists && key.DeletedAt.IsZero() && key.Type == keyType && key.OwnerID == userID
+	}
+
+	// Whether the user validly references the key as their basic key of the given environment
+	ownsBasicKey := func(userID primitive.ObjectID, keyID primitive.ObjectID, environment string) bool {
+		return ownsKey(userID, keyID, "Basic") && keyEnvironment(keysByID[keyID]) == environment
+	}
+
+	repairs := []consistencyRepair{}
+
+	// @INFO: User references are checked first, so invalid basic keys are
+	// cleared before keys missing from their owner are referenced
+	for _, user := range users {
+		for _, environment := range []string{"Live", "Test"} {
+			field := basicKeyField(environment)
+			keyID := userBasicKey(user, environment)
+			if keyID != primitive.NilObjectID && !ownsBasicKey(user.ID, keyID, environment) {
+				repairs = append(repairs, consistencyRepair{
+					violation:  models.ConsistencyViolation{Category: "UserKeyReferenceInvalid", Description: "The user's " + field + " is not an existing " + environment + " basic key they own", UserID: user.ID, KeyID: keyID, Repair: "Clear the user's " + field},
+					collection: userCollection,
+					filter:     bson.D{{Key: "_id", Value: user.ID}, {Key: field, Value: keyID}},
+					update:     bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: primitive.NilObjectID}, {Key: "updated_at", Value: time.Now().UTC()}}}},
+
+					validKeyFilter: ownedKeyFilter(user.ID, keyID, "Basic", environment),
+				})
+			}
+		}
+
+		for _, keyID := range user.AdvancedKeys {
+			if !ownsKey(user.ID, keyID, "Advanced") {
+				repairs = append(repairs, consistencyRepair{
+					violation:  models.ConsistencyViolation{Category: "UserKeyReferenceInvalid", Description: "The user's advanced_keys lists a key which is not an existing advanced key they own", UserID: user.ID, KeyID: keyID, Repair: "Remove the key from the user's advanced_keys"},
+					collection: userCollection,
+					filter:     bson.D{{Key: "_id", Value: user.ID}, {Key: "advanced_keys", Value: keyID}},
+					update:     bson.D{{Key: "$pull", Value: bson.D{{Key: "advanced_keys", Value: keyID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}},
+
+					validKeyFilter: ownedKeyFilter(user.ID, keyID, "Advanced", ""),
+				})
+			}
+		}
+
+		for _, serviceID := range user.Services {
+			if !serviceExists[serviceID] {
+				repairs = append(repairs, consistencyRepair{
+					violation:  models.ConsistencyViolation{Category: "LeadServiceMissing", Description: "The user's services lists a service which does not exist", UserID: user.ID, ServiceID: serviceID, Repair: "Remove the service from the user's services"},
+					collection: userCollection,
+					filter:     bson.D{{Key: "_id", Value: user.ID}},
+					update:     bson.D{{Key: "$pull", Value: bson.D{{Key: "services", Value: serviceID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}},
+				})
+			}
+		}
+	}
+
+	for _, key := range keys {
+		// Deleted keys are no longer listed by their owners
+		if !key.DeletedAt.IsZero() {
+			continue
+		}
+
+		if key.Type == "Basic" && key.ServiceID != primitive.NilObjectID {
+			repairs = append(repairs, consistencyRepair{
+				violation:  models.ConsistencyViolation{Category: "BasicKeyHasService", Description: "The basic key has a service_id", KeyID: key.ID, ServiceID: key.ServiceID, Repair: "Remove the key's service_id"},
+				collection: keyCollection,
+				filter:     bson.D{{Key: "_id", Value: key.ID}, {Key: "key_type", Value: "Basic"}},
+				update:     bson.D{{Key: "$unset", Value: bson.D{{Key: "service_id", Value: ""}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}},
+			})
+		}
+
+		if key.Type == "Advanced" && !serviceExists[key.ServiceID] {
+			repairs = append(repairs, consistencyRepair{
+				violation: models.ConsistencyViolation{Category: "KeyServiceMissing", Description: "The advanced key's service does not exist", KeyID: key.ID, ServiceID: key.ServiceID},
+			})
+		}
+
+		owner, exists := usersByID[key.OwnerID]
+		if !exists {
+			repairs = append(repairs, consistencyRepair{
+				violation: models.ConsistencyViolation{Category: "KeyOwnerMissing", Description: "The key's owner does not exist", KeyID: key.ID, UserID: key.OwnerID},
+			})
+			continue
+		}
+
+		environment := keyEnvironment(key)
+		field := basicKeyField(environment)
+		ownerBasicKey := userBasicKey(owner, environment)
+		if key.Type == "Basic" && ownerBasicKey != key.ID {
+			repair := consistencyRepair{
+				violation: models.ConsistencyViolation{Category: "KeyOwnerReferenceMissing", Description: "The basic key is not its owner's " + field, KeyID: key.ID, UserID: owner.ID},
+			}
+			// @INFO: Only safe should the owner have no valid basic key of their own
+			if ownerBasicKey == primitive.NilObjectID || !ownsBasicKey(owner.ID, ownerBasicKey, environment) {
+				repair.violation.Repair = "Set the owner's " + field + " to the key"
+				repair.collection = userCollection
+				repair.filter = bson.D{{Key: "_id", Value: owner.ID}, {Key: field, Value: bson.D{{Key: "$in", Value: bson.A{primitive.NilObjectID, ownerBasicKey, nil}}}}}
+				repair.update = bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: key.ID}, {Key: "updated_at", Value: time.Now().UTC()}}}}
+				// Only one of the owner's unreferenced basic keys can become their basic key
+				if environment == "Test" {
+					owner.TestBasicKey = key.ID
+				} else {
+					owner.BasicKey = key.ID
+				}
+				usersByID[owner.ID] = owner
+			}
+			repairs = append(repairs, repair)
+		}
+
+		if key.Type == "Advanced" && !slices.Contains(owner.AdvancedKeys, key.ID) {
+			repairs = append(repairs, consistencyRepair{
+				violation:  models.ConsistencyViolation{Category: "KeyOwnerReferenceMissing", Description: "The advanced key is not listed in its owner's advanced_keys", KeyID: key.ID, UserID: owner.ID, Repair: "Add the key to the owner's advanced_keys"},
+				collection: userCollection,
+				filter:     bson.D{{Key: "_id", Value: owner.ID}},
+				update:     bson.D{{Key: "$addToSet", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}, {Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}},
+			})
+		}
+	}
+
+	return repairs, nil
+}
+
+// Filter of the keys collection matching the key should the user validly reference it as the given key type
+// and environment, or any environment should it be empty
+func ownedKeyFilter(userID primitive.ObjectID, keyID primitive.ObjectID, keyType string, environment string) bson.D {
+	filter := bson.D{{Key: "_id", Value: keyID}, {Key: "key_type", Value: keyType}, {Key: "owner_id", Value: userID}, {Key: "deleted_at", Value: bson.M{"$exists": false}}}
+	if environment == "Live" {
+		// @INFO: Keys without an environment are Live keys
+		filter = append(filter, bson.E{Key: "environment", Value: bson.M{"$in": bson.A{"Live", "", nil}}})
+	} else if environment != "" {
+		filter = append(filter, bson.E{Key: "environment", Value: environment})
+	}
+	return filter
+}
+
+// Whether the repair is still safe, as the keys collection has no key making the violation valid
+func repairStillSafe(ctx context.Context, repair consistencyRepair) (bool, error) {
+	if repair.validKeyFilter == nil {
+		return true, nil
+	}
+	count, err := keyCollection.CountDocuments(ctx, repair.validKeyFilter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+/**************************************************************************
+* Check Consistency
+* This enables Admins (user_id) to check the consistency of the users,
+* keys, and services collections, returning the violations found and
+* the number of violations of each category.
+*
+* Should fix be 'true', violations with a safe repair are repaired, and
+* the report is stored so it can later be viewed.
+**************************************************************************/
+func CheckConsistency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+
+		// @INFO: Scanning every user, key, and service can take longer than other requests
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ConsistencyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ConsistencyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get fix (optional)
+		fix := false
+		fixStr, exists := c.GetQuery("fix")
+		if exists {
+			fix, err = strconv.ParseBool(fixStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.ConsistencyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid fix: Must be 'true' or 'false'"})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ConsistencyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ConsistencyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.ConsistencyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Find violations
+		repairs, err := findConsistencyViolations(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ConsistencyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Build report, repairing violations should fix be set
+		report := models.ConsistencyReport{
+			ID:          primitive.NewObjectID(),
+			ActorUserID: userID,
+			Fix:         fix,
+			Counts:      map[string]int{},
+			Violations:  []models.ConsistencyViolation{},
+		}
+		for _, repair := range repairs {
+			violation := repair.violation
+			report.Counts[violation.Category]++
+
+			if fix && repair.collection != nil {
+				safe, err := repairStillSafe(ctx, repair)
+				var result *mongo.UpdateResult
+				if err == nil && safe {
+					result, err = repair.collection.UpdateOne(ctx, repair.filter, repair.update)
+				}
+				if err != nil {
+					violation.RepairError = err.Error()
+				} else if !safe {
+					violation.RepairError = "The key was updated since it was checked"
+				} else if result.ModifiedCount == 0 {
+					violation.RepairError = "The document was updated since it was checked"
+				} else {
+					violation.Repaired = true
+					report.Repaired++
+				}
+			}
+
+			report.Violations = append(report.Violations, violation)
+		}
+		report.CreatedAt = time.Now().UTC()
+
+		if fix {
+			// Store the report of what changed
+			_, err = consistencyReportCollection.InsertOne(ctx, report)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, responses.ConsistencyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+				return
+			}
+
+			// Record audit event
+			recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "RepairConsistency", After: bson.M{"report_id": report.ID, "counts": report.Counts, "repaired": report.Repaired}})
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.ConsistencyResponse{Status: http.StatusOK, Message: "success", Data: report})
+	}
+}
+
+/**************************************************************************
+* Get Consistency Reports
+* This returns the most recent reports of consistency checks which
+* repaired violations to Admins (user_id), newest first.
+**************************************************************************/
+func GetConsistencyReports() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var reports []models.ConsistencyReport
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ConsistencyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ConsistencyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ConsistencyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ConsistencyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.ConsistencyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get reports
+		findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(consistencyReportLimit)
+		cursor, err := consistencyReportCollection.Find(ctx, bson.D{}, findOptions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ConsistencyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		reports = []models.ConsistencyReport{}
+		err = cursor.All(ctx, &reports)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ConsistencyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.ConsistencyResponse{Status: http.StatusOK, Message: "success", Data: reports})
+	}
+}
@@ -0,0 +1,230 @@
+/**************************************************************************
+* Credit endpoint logic.
+*
+* Keys with a 'Credit' quota mode are granted a fixed block of prepaid
+* requests (credit_balance) rather than a periodic quota. Each allowed
+* request deducts one credit, and the balance never resets.
+*
+* Only Admins can top up credit balances. Every top up is recorded in
+* the audit log, along with an optional note (e.g. an invoice number).
+*
+* The owner is notified once as the balance falls to or below each of
+* the key's credit alert balances, until the key is next topped up.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**************************************************************************
+* Consume Credit Balance
+* This atomically deducts one credit from the key's credit balance,
+* should it have any remaining.
+*
+* Returns the updated key and whether the credit was consumed.
+**************************************************************************/
+func consumeCreditBalance(ctx context.Context, key models.Key, now time.Time) (models.Key, bool, error) {
+	var updatedKey models.Key
+
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "credit_balance", Value: bson.D{{Key: "$gt", Value: 0}}}}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "credit_balance", Value: -1}}}, {Key: "$set", Value: bson.D{{Key: "last_used", Value: now}}}}
+
+	err := keyCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// No credit remaining
+			return key, false, nil
+		}
+		return key, false, err
+	}
+
+	return updatedKey, true, nil
+}
+
+/**************************************************************************
+* Parse Credit Alert Balances
+* This parses comma separated credit balances (e.g. "1000,100") at or
+* below which the owner is notified, sorted from highest to lowest.
+**************************************************************************/
+func parseCreditAlertBalances(creditAlertBalancesStr string) ([]int, error) {
+	creditAlertBalances := []int{}
+	if creditAlertBalancesStr == "" {
+		return creditAlertBalances, nil
+	}
+
+	for _, balanceStr := range strings.Split(creditAlertBalancesStr, ",") {
+		balance, err := strconv.Atoi(strings.TrimSpace(balanceStr))
+		if err != nil || balance < 0 {
+			return nil, errors.New("Invalid credit_alert_balances: Each balance must be a non-negative integer")
+		}
+		creditAlertBalances = append(creditAlertBalances, balance)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(creditAlertBalances)))
+
+	return creditAlertBalances, nil
+}
+
+/**************************************************************************
+* Top Up Key Credit
+* This enables Admins (user_id) to add credits (credits) to the credit
+* balance of a 'Credit' quota key (key_id).
+*
+* The balances at or below which the key's owner is notified
+* (credit_alert_balances) can optionally be replaced, given as comma
+* separated balances, e.g. "1000,100". An empty value removes them.
+*
+* A note (note) can optionally be recorded with the top up.
+**************************************************************************/
+func TopUpKeyCredit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get credits
+		creditsStr, exists := c.GetQuery("credits")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'credits' field"})
+			return
+		}
+		credits, err := strconv.Atoi(creditsStr)
+		if err != nil || credits <= 0 {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid credits: Must be positive"})
+			return
+		}
+
+		// Get creditAlertBalances (optional)
+		creditAlertBalancesStr, setCreditAlertBalances := c.GetQuery("credit_alert_balances")
+		creditAlertBalances, err := parseCreditAlertBalances(creditAlertBalancesStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get note (optional)
+		note := c.Query("note")
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify key uses a credit balance
+		if key.QuotaMode != "Credit" {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Only keys with a 'Credit' quota_mode can be topped up"})
+			return
+		}
+
+		now := time.Now().UTC()
+
+		// Add credits and re-arm the alert balances now above the balance
+		// @INFO: Incrementing within the update keeps credits consumed concurrently
+		addCredits := bson.D{{Key: "credit_balance", Value: bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$credit_balance", 0}}}, credits}}}}, {Key: "updated_at", Value: now}}
+		if setCreditAlertBalances {
+			addCredits = append(addCredits, bson.E{Key: "credit_alert_balances", Value: creditAlertBalances})
+		}
+		rearmAlerts := bson.D{{Key: "notified_credit_alerts", Value: bson.D{{Key: "$filter", Value: bson.D{{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$notified_credit_alerts", bson.A{}}}}}, {Key: "as", Value: "balance"}, {Key: "cond", Value: bson.D{{Key: "$gte", Value: bson.A{"$$balance", "$credit_balance"}}}}}}}}}
+		updatePipeline := bson.A{bson.D{{Key: "$set", Value: addCredits}}, bson.D{{Key: "$set", Value: rearmAlerts}}}
+
+		var updatedKey models.Key
+		err = keyCollection.FindOneAndUpdate(ctx, bson.M{"_id": keyID, "quota_mode": "Credit"}, updatePipeline, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedKey)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "TopUpKeyCredit", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"credit_balance": updatedKey.CreditBalance - credits, "credit_alert_balances": key.CreditAlertBalances}, After: bson.M{"credit_balance": updatedKey.CreditBalance, "credit_alert_balances": updatedKey.CreditAlertBalances, "credits": credits, "note": note}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			CreditBalance       int    `json:"credit_balance" bson:"credit_balance"`
+			CreditAlertBalances []int  `json:"credit_alert_balances" bson:"credit_alert_balances"`
+			UpdatedAt           string `json:"updated_at" bson:"updated_at"`
+		}{
+			CreditBalance:       updatedKey.CreditBalance,
+			CreditAlertBalances: updatedKey.CreditAlertBalances,
+			UpdatedAt:           updatedKey.UpdatedAt.Format(configs.DateLayout),
+		}
+		if res.CreditAlertBalances == nil {
+			res.CreditAlertBalances = []int{}
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
@@ -0,0 +1,80 @@
+/**************************************************************************
+* Key environment logic.
+*
+* Keys are either 'Test' keys, which can only reach Staging services, or
+* 'Live' keys, which can only reach production services (any other
+* service type). Keys created before environments existed are Live.
+*
+* Users can hold both a Test and a Live basic key. Test basic keys are
+* for any Staging service, as Live basic keys are for any service of
+* service type 'Basic'. Advanced keys take the environment of their
+* service.
+**************************************************************************/
+
+package controllers
+
+import (
+	"errors"
+
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/**************************************************************************
+* Key Environment
+* This returns the environment of the key, 'Live' should it have none.
+**************************************************************************/
+func keyEnvironment(key models.Key) string {
+	if key.Environment == "" {
+		return "Live"
+	}
+	return key.Environment
+}
+
+/**************************************************************************
+* Service Environment
+* This returns the environment of keys which can reach the service.
+**************************************************************************/
+func serviceEnvironment(service models.Service) string {
+	if service.Type == "Staging" {
+		return "Test"
+	}
+	return "Live"
+}
+
+/**************************************************************************
+* Verify Key Environment
+* This verifies a key of the given environment can reach the service.
+**************************************************************************/
+func verifyKeyEnvironment(environment string, service models.Service) error {
+	if environment == serviceEnvironment(service) {
+		return nil
+	}
+	if environment == "Test" {
+		return errors.New("Test keys can only be used with Staging services")
+	}
+	return errors.New("Live keys cannot be used with Staging services")
+}
+
+/**************************************************************************
+* Basic Key Field
+* This returns the user field referencing their basic key of the given
+* environment.
+**************************************************************************/
+func basicKeyField(environment string) string {
+	if environment == "Test" {
+		return "test_basic_key"
+	}
+	return "basic_key"
+}
+
+/**************************************************************************
+* User Basic Key
+* This returns the user's basic key of the given environment.
+**************************************************************************/
+func userBasicKey(user models.User, environment string) primitive.ObjectID {
+	if environment == "Test" {
+		return user.TestBasicKey
+	}
+	return user.BasicKey
+}
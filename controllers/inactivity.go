@@ -0,0 +1,381 @@
+/**************************************************************************
+* Key inactivity logic.
+*
+* Keys which go unused for too long (e.g. those of students who have
+* graduated) are disabled by the EnforceKeyInactivity job. The policy is
+* set globally by KEY_INACTIVITY_DAYS and KEY_INACTIVITY_WARNING_DAYS,
+* and can be overridden per service (see SetServiceInactivityPolicy).
+*
+* A key's inactivity is measured from when it was last used, or created
+* should it never have been used. Owners are warned once before their
+* key is disabled. Keys re-enabled after being disabled for inactivity
+* are left enabled until they are next used.
+*
+* Leads and Admins can exempt keys from the policy. Every warning,
+* disable, and exemption is recorded in the audit log.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/notifiers"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Global inactivity policy, which services can override
+var keyInactivityDays int = configs.GetEnvKeyInactivityDays()
+var keyInactivityWarningDays int = configs.GetEnvKeyInactivityWarningDays()
+
+/**************************************************************************
+* Record Key Used
+* This records that the key was used by a granted request (now).
+*
+* Keys consuming their own quota record it as they consume it, so this
+* is only needed for keys which do not (e.g. pool only keys).
+**************************************************************************/
+func recordKeyUsed(ctx context.Context, key models.Key, now time.Time) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "last_used", Value: now}}}}
+	_, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}}, update)
+	return err
+}
+
+/**************************************************************************
+* Resolve Key Inactivity Policy
+* This returns the days the key can go unused before it is disabled
+* (0 never disables it), and the days beforehand its owner is warned.
+* Advanced keys follow their service's policy, should it set one.
+**************************************************************************/
+func resolveKeyInactivityPolicy(key models.Key, services map[primitive.ObjectID]models.Service) (int, int) {
+	days, warningDays := keyInactivityDays, keyInactivityWarningDays
+
+	service, exists := services[key.ServiceID]
+	if key.Type == "Advanced" && exists {
+		if service.InactivityDays != nil {
+			days = *service.InactivityDays
+		}
+		if service.InactivityWarningDays != nil {
+			warningDays = *service.InactivityWarningDays
+		}
+	}
+
+	return days, warningDays
+}
+
+/**************************************************************************
+* Enforce Key Inactivity Operation
+* This warns the owners of keys nearing their inactivity deadline, and
+* disables keys which have passed it.
+*
+* This is run periodically by the job scheduler (see jobs/scheduler.go).
+**************************************************************************/
+func EnforceKeyInactivityOperation(ctx context.Context) error {
+	var services []models.Service
+
+	now := time.Now().UTC()
+
+	// Get services overriding the global policy
+	cursor, err := serviceCollection.Find(ctx, bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "inactivity_days", Value: bson.D{{Key: "$exists", Value: true}}}}, bson.D{{Key: "inactivity_warning_days", Value: bson.D{{Key: "$exists", Value: true}}}}}}})
+	if err != nil {
+		return fmt.Errorf("unable to find service inactivity policies: %w", err)
+	}
+	err = cursor.All(ctx, &services)
+	if err != nil {
+		return fmt.Errorf("unable to find service inactivity policies: %w", err)
+	}
+	servicesByID := map[primitive.ObjectID]models.Service{}
+	for _, service := range services {
+		servicesByID[service.ID] = service
+	}
+
+	// Only keys unused for at least the shortest time before any policy warns need be checked
+	minUnusedDays := -1
+	checkPolicy := func(days int, warningDays int) {
+		if days == 0 {
+			return
+		}
+		unusedDays := days - warningDays
+		if unusedDays < 0 {
+			unusedDays = 0
+		}
+		if minUnusedDays == -1 || unusedDays < minUnusedDays {
+			minUnusedDays = unusedDays
+		}
+	}
+	checkPolicy(keyInactivityDays, keyInactivityWarningDays)
+	for _, service := range services {
+		checkPolicy(resolveKeyInactivityPolicy(models.Key{Type: "Advanced", ServiceID: service.ID}, servicesByID))
+	}
+	if minUnusedDays == -1 {
+		// No policy disables keys
+		return nil
+	}
+	unusedSince := now.AddDate(0, 0, -minUnusedDays)
+
+	// @INFO: Keys disabled for inactivity are only checked again once they have been used since
+	filter := bson.D{
+		{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "is_active", Value: true},
+		{Key: "inactivity_exempt", Value: bson.D{{Key: "$ne", Value: true}}},
+		{Key: "created_at", Value: bson.D{{Key: "$lte", Value: unusedSince}}},
+		{Key: "last_used", Value: bson.D{{Key: "$lte", Value: unusedSince}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "inactivity_disabled_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{"$last_used", "$inactivity_disabled_at"}}}}},
+		}},
+	}
+	cursor, err = keyCollection.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to find inactive keys: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	// @INFO: Keys which fail are retried on the next run
+	failed := 0
+	for cursor.Next(ctx) {
+		var key models.Key
+		err := cursor.Decode(&key)
+		if err != nil {
+			return fmt.Errorf("unable to find inactive keys: %w", err)
+		}
+
+		days, warningDays := resolveKeyInactivityPolicy(key, servicesByID)
+		if days == 0 {
+			continue
+		}
+
+		lastActive := key.LastUsed
+		if key.CreatedAt.After(lastActive) {
+			lastActive = key.CreatedAt
+		}
+		deadline := lastActive.AddDate(0, 0, days)
+
+		if !now.Before(deadline) {
+			err = disableInactiveKey(ctx, key, days, now)
+		} else if warningDays > 0 && !now.Before(deadline.AddDate(0, 0, -warningDays)) && !key.InactivityWarnedAt.After(lastActive) {
+			err = warnInactiveKey(ctx, key, deadline, now)
+		}
+		if err != nil {
+			log.Printf("Unable to enforce inactivity policy of key %s: %v", key.ID.Hex(), err)
+			failed++
+		}
+	}
+	err = cursor.Err()
+	if err != nil {
+		return fmt.Errorf("unable to find inactive keys: %w", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to enforce inactivity policy of %d keys", failed)
+	}
+	return nil
+}
+
+/**************************************************************************
+* Warn Inactive Key
+* This warns the key's owner that the key will be disabled at the
+* deadline should it not be used before then.
+**************************************************************************/
+func warnInactiveKey(ctx context.Context, key models.Key, deadline time.Time, now time.Time) error {
+	// @INFO: Matching the last_used leaves keys used since they were found
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "is_active", Value: true}, {Key: "last_used", Value: key.LastUsed}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "inactivity_warned_at", Value: now}}}}
+
+	result, err := keyCollection.UpdateOne(ctx, filter, update)
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+
+	// Record audit event
+	recordAuditEvent(ctx, nil, models.AuditEvent{Action: "WarnInactiveKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, After: bson.M{"last_used": key.LastUsed, "disable_at": deadline}})
+
+	notifyUser(key.OwnerID, notifiers.Notification{
+		Event:   "KeyInactivityWarning",
+		Subject: fmt.Sprintf("Your key '%s' will be disabled for inactivity", key.Name),
+		Message: fmt.Sprintf("Your key '%s' has not been used recently, and will be disabled at %s unless it is used before then.", key.Name, deadline.Format(configs.DateLayout)),
+		Data:    map[string]interface{}{"key_id": key.ID.Hex(), "disable_at": deadline.Format(configs.DateLayout)},
+	})
+
+	return nil
+}
+
+/**************************************************************************
+* Disable Inactive Key
+* This disables the key for having gone unused for the given days, and
+* notifies its owner.
+**************************************************************************/
+func disableInactiveKey(ctx context.Context, key models.Key, days int, now time.Time) error {
+	// @INFO: Matching the last_used leaves keys used since they were found
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "is_active", Value: true}, {Key: "last_used", Value: key.LastUsed}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "is_active", Value: false}, {Key: "inactivity_disabled_at", Value: now}}}}
+
+	result, err := keyCollection.UpdateOne(ctx, filter, update)
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+
+	// Record audit event
+	recordAuditEvent(ctx, nil, models.AuditEvent{Action: "DisableInactiveKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"is_active": true}, After: bson.M{"is_active": false, "last_used": key.LastUsed, "inactivity_days": days}})
+
+	notifyUser(key.OwnerID, notifiers.Notification{
+		Event:   "KeyDisabledInactive",
+		Subject: fmt.Sprintf("Your key '%s' has been disabled for inactivity", key.Name),
+		Message: fmt.Sprintf("Your key '%s' has been disabled, as it has not been used for %d days. Contact a lead of its service to have it re-enabled.", key.Name, days),
+		Data:    map[string]interface{}{"key_id": key.ID.Hex(), "inactivity_days": days},
+	})
+
+	return nil
+}
+
+/**************************************************************************
+* Set Key Inactivity Exempt
+* This enables Leads and Admins (user_id) to exempt a key (key_id) from
+* the inactivity policy, or remove its exemption (exempt). A reason
+* (reason) can optionally be recorded with the change.
+*
+* Admins can exempt any key.
+* Leads can only exempt advanced keys for services they are leads for.
+**************************************************************************/
+func SetKeyInactivityExempt() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err := time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get exempt
+		exemptStr, exists := c.GetQuery("exempt")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'exempt' field"})
+			return
+		}
+		exempt, err := strconv.ParseBool(exemptStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid exempt: Must be 'true' or 'false'"})
+			return
+		}
+
+		// Get reason (optional)
+		reason := c.Query("reason")
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		// @INFO: Assumes key.ServiceID is valid
+		if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to exempt this key"})
+			return
+		}
+
+		// Set exemption
+		wasExempt := key.InactivityExempt
+		key.InactivityExempt = exempt
+		key.UpdatedAt = time.Now().UTC()
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "inactivity_exempt", Value: key.InactivityExempt}}}}
+		result, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: keyID}, {Key: "updated_at", Value: updatedAt}}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetKeyInactivityExempt", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"inactivity_exempt": wasExempt}, After: bson.M{"inactivity_exempt": key.InactivityExempt, "reason": reason}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			InactivityExempt bool   `json:"inactivity_exempt" bson:"inactivity_exempt"`
+			UpdatedAt        string `json:"updated_at" bson:"updated_at"`
+		}{
+			InactivityExempt: key.InactivityExempt,
+			UpdatedAt:        key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
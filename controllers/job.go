@@ -0,0 +1,163 @@
+/**************************************************************************
+* Job endpoint logic.
+*
+* Background jobs (see jobs/scheduler.go) run periodically on a single
+* kms replica at a time. Admins can trigger a run of a job on demand
+* and view the outcome of its latest run.
+*
+* Reponses are built using responses/job_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/UTDNebula/kms/jobs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**************************************************************************
+* Trigger Job
+* This requests a run of the given job (job_name) by the given
+* user (user_id), who must be an Admin.
+*
+* The job runs in the background; its outcome can be viewed with
+* GetLatestJobRun once it finishes.
+**************************************************************************/
+func TriggerJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.JobResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.JobResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get jobName
+		jobName, exists := c.GetQuery("job_name")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.JobResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'job_name' field"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.JobResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.JobResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.JobResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Trigger job
+		err = jobs.Trigger(jobName, userID)
+		if err != nil {
+			if err == jobs.ErrUnknownJob {
+				c.JSON(http.StatusNotFound, responses.JobResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid job_name: Job does not exist"})
+				return
+			}
+			c.JSON(http.StatusConflict, responses.JobResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusAccepted, responses.JobResponse{Status: http.StatusAccepted, Message: "success", Data: "Job '" + jobName + "' has been triggered"})
+	}
+}
+
+/**************************************************************************
+* Get Latest Job Run
+* This returns the latest run of the given job (job_name) across all
+* replicas to the given user (user_id), who must be an Admin.
+**************************************************************************/
+func GetLatestJobRun() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.JobResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.JobResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get jobName
+		jobName, exists := c.GetQuery("job_name")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.JobResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'job_name' field"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.JobResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.JobResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.JobResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get latest run
+		run, err := jobs.LatestRun(ctx, jobName)
+		if err != nil {
+			if err == jobs.ErrUnknownJob {
+				c.JSON(http.StatusNotFound, responses.JobResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid job_name: Job does not exist"})
+				return
+			}
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.JobResponse{Status: http.StatusNotFound, Message: "error", Data: "Job '" + jobName + "' has not run yet"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.JobResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.JobResponse{Status: http.StatusOK, Message: "success", Data: run})
+	}
+}
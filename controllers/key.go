@@ -12,6 +12,9 @@
 * an accurate 'updated_at' timestamp, which can be acquired from a
 * request to GetUserKeys in controllers/user.go.
 *
+* Requests which write to both a key and its owner's keys do so within
+* a transaction (see controllers/transaction.go).
+*
 * Reponses are built using responses/key_response.go.
 *
 * Written by Adam Brunn (amb150230) at The University of Texas at Dallas
@@ -22,6 +25,7 @@ package controllers
 
 import (
 	"context"
+	"log"
 	"math/rand"
 	"net/http"
 	"strconv"
@@ -44,6 +48,11 @@ var keyCollection *mongo.Collection = configs.GetCollection(configs.DB, "keys")
 * Create Basic Key
 * This creates a basic key for the given user (user_id)
 * provided they do not already have one.
+*
+* Users can hold both a 'Live' and a 'Test' basic key (environment,
+* Default: Live), see controllers/environment.go.
+*
+* The key subscribes to the default basic plan, should one be configured.
 **************************************************************************/
 func CreateBasicKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -77,9 +86,16 @@ func CreateBasicKey() gin.HandlerFunc {
 			return
 		}
 
-		// Verify user has no basic key
-		if user.BasicKey != primitive.NilObjectID {
-			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "User already has a basic key"})
+		// Get environment (optional)
+		environment := c.DefaultQuery("environment", "Live")
+		if environment != "Live" && environment != "Test" {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid environment. Must be 'Live' or 'Test'"})
+			return
+		}
+
+		// Verify user has no basic key of the environment
+		if userBasicKey(user, environment) != primitive.NilObjectID {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "User already has a " + environment + " basic key"})
 			return
 		}
 
@@ -88,30 +104,44 @@ func CreateBasicKey() gin.HandlerFunc {
 		key.Type = "Basic"
 		key.Name = "Basic_Key"
 		key.OwnerID = userID
-		key.Quota = 100 // @TODO: Centralize const values
-		key.QuotaNumDays = 1
+		key.Environment = environment
+		key.Quota = configs.DefaultBasicKeyQuota
+		key.QuotaNumDays = configs.DefaultQuotaNumDays
 		key.UsageRemaining = key.Quota
 		key.CreatedAt = time.Now().UTC()
+
+		// Subscribe key to the default basic plan
+		plan, found, err := findDefaultPlan(ctx, key.Type)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if found {
+			subscribeKeyToPlan(&key, plan, key.CreatedAt, time.UTC)
+		}
 		key.QuotaTimestamp = key.CreatedAt
 		key.UpdatedAt = key.CreatedAt
 		key.IsActive = true
 		key.Key = configs.GenerateKey()
 
-		// Create Key
-		_, err = keyCollection.InsertOne(ctx, key)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+		// Create Key and update user with new key
+		// @INFO: Both writes are applied together, or not at all
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			updateUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}, {Key: basicKeyField(environment), Value: key.ID}}}}
+			return insertKeyWrites(sessCtx, keyCollection, userCollection, key, userID, updateUser)
+		})
+		if err == errUserOutOfDate {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
 			return
 		}
-
-		// Update user with new key
-		updateUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}, {Key: "basic_key", Value: key.ID}}}}
-		_, err = userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: userID}}, updateUser)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "CreateBasicKey", TargetKeyID: key.ID, TargetUserID: userID, After: bson.M{"name": key.Name, "environment": key.Environment, "plan_id": key.PlanID, "quota": key.Quota, "quota_num_days": key.QuotaNumDays, "is_active": key.IsActive}})
+
 		// Return the key
 		c.JSON(http.StatusCreated, responses.KeyResponse{Status: http.StatusCreated, Message: "success", Data: key})
 
@@ -125,6 +155,16 @@ func CreateBasicKey() gin.HandlerFunc {
 *
 * Admins can create advanced keys for any service (service_id).
 * Leads can only create advanced keys for services they are leads for.
+*
+* The key subscribes to the given plan (plan_id), or otherwise the
+* default advanced plan, should one be configured. A given quota
+* overrides the plan's quota.
+*
+* The key itself is hidden from the creator. Instead, the recipient is
+* sent a single-use claim token with which to obtain it (see ClaimKey).
+*
+* The key's environment ('Test' or 'Live') defaults to that of the
+* service, and must match it (see controllers/environment.go).
 **************************************************************************/
 func CreateAdvancedKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -201,6 +241,17 @@ func CreateAdvancedKey() gin.HandlerFunc {
 			return
 		}
 
+		// Get environment (optional) and verify it matches the service
+		environment := c.DefaultQuery("environment", serviceEnvironment(service))
+		if environment != "Live" && environment != "Test" {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid environment. Must be 'Live' or 'Test'"})
+			return
+		}
+		if err := verifyKeyEnvironment(environment, service); err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
 		// Verify recipientUserID is valid (user exists)
 		err = userCollection.FindOne(ctx, bson.M{"_id": recipientUserID}).Decode(&recipientUser)
 		if err != nil {
@@ -212,13 +263,47 @@ func CreateAdvancedKey() gin.HandlerFunc {
 			return
 		}
 
+		// Get plan (optional)
+		plan, found, err := findDefaultPlan(ctx, "Advanced")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		planIDQuery, exists := c.GetQuery("plan_id")
+		if exists {
+			planID, err := primitive.ObjectIDFromHex(planIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			err = planCollection.FindOne(ctx, bson.M{"_id": planID}).Decode(&plan)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid plan_id: Plan does not exist"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+				return
+			}
+			found = true
+		}
+
+		// Subscribe key to plan
+		key.Quota = configs.DefaultAdvancedKeyQuota
+		key.QuotaNumDays = configs.DefaultQuotaNumDays
+		if found {
+			loc, _ := configs.ResolveQuotaSchedule("", nil, service.Timezone, service.ResetHour)
+			subscribeKeyToPlan(&key, plan, time.Now().UTC(), loc)
+		}
+
 		// Grab remaining query fields
 		key.Name = c.Query("key_name")
-		key.Quota, err = strconv.Atoi(c.Query("quota"))
+		quota, err := strconv.Atoi(c.Query("quota"))
 
-		// Set quota if error or not given
-		if err != nil || key.Quota == 0 {
-			key.Quota = 1000 // @TODO: Centralize const values
+		// Override quota if given
+		if err == nil && quota != 0 {
+			key.Quota = quota
+			key.QuotaOverride = found
 		}
 
 		// Generate key name if not given
@@ -235,7 +320,7 @@ func CreateAdvancedKey() gin.HandlerFunc {
 		key.Type = "Advanced"
 		key.OwnerID = recipientUserID
 		key.ServiceID = serviceID
-		key.QuotaNumDays = 1
+		key.Environment = environment
 		key.UsageRemaining = key.Quota
 		key.CreatedAt = time.Now().UTC()
 		key.QuotaTimestamp = key.CreatedAt
@@ -243,28 +328,32 @@ func CreateAdvancedKey() gin.HandlerFunc {
 		key.IsActive = true
 		key.Key = configs.GenerateKey()
 
-		// Create Key
-		_, err = keyCollection.InsertOne(ctx, key)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+		// Create Key and update recipient user with new key
+		// @INFO: Both writes are applied together, or not at all
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			updateRecipientUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}, {Key: "$push", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+			return insertKeyWrites(sessCtx, keyCollection, userCollection, key, recipientUserID, updateRecipientUser)
+		})
+		if err == errUserOutOfDate {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
 			return
 		}
-
-		// Update recipient user with new key
-		updateRecipientUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}, {Key: "$push", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
-		_, err = userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: recipientUserID}}, updateRecipientUser)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
-		// Hide the actual key and return the remaining relevant data
-		key.Key = "_HIDDEN_"
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: creatorUserID, Action: "CreateAdvancedKey", TargetKeyID: key.ID, TargetUserID: recipientUserID, TargetServiceID: serviceID, After: bson.M{"name": key.Name, "owner_id": key.OwnerID, "service_id": key.ServiceID, "environment": key.Environment, "plan_id": key.PlanID, "quota_override": key.QuotaOverride, "quota": key.Quota, "quota_num_days": key.QuotaNumDays, "is_active": key.IsActive}})
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
-			return
+		// Issue a claim token with which the recipient can obtain the key
+		// @INFO: The recipient can still reveal the key should this fail (see RevealKey)
+		if err := issueKeyClaim(ctx, key); err != nil {
+			log.Printf("Unable to issue claim for key %s: %v", key.ID.Hex(), err)
 		}
+
+		// Hide the actual key and return the remaining relevant data
+		key.Key = "_HIDDEN_"
 		c.JSON(http.StatusCreated, responses.KeyResponse{Status: http.StatusCreated, Message: "success", Data: key})
 	}
 }
@@ -277,6 +366,10 @@ func CreateAdvancedKey() gin.HandlerFunc {
 * Key owners can delete their own advanced keys.
 * Admins can delete any advanced key.
 * Leads can only delete advanced keys for services they are leads for.
+*
+* Deleted keys are tombstoned (deleted_at) and removed from their owner's
+* keys, and can be restored using RestoreKey until they are purged once
+* the retention window has passed (see configs.PurgeDeletedKeysOperation).
 **************************************************************************/
 func DeleteKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -351,6 +444,12 @@ func DeleteKey() gin.HandlerFunc {
 			return
 		}
 
+		// Key is already deleted
+		if !key.DeletedAt.IsZero() {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid key_id: Key has already been deleted"})
+			return
+		}
+
 		// Verify matching updated_At
 		if !key.UpdatedAt.Equal(updatedAt) {
 			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
@@ -382,14 +481,204 @@ func DeleteKey() gin.HandlerFunc {
 		}
 
 		// Delete Key
-		_, err = keyCollection.DeleteOne(ctx, keyFilter)
+		// @INFO: Matching the updated_at prevents deleting a key updated since it was found
+		key.DeletedAt = time.Now().UTC()
+		key.UpdatedAt = key.DeletedAt
+
+		// Tombstone key and remove it from its owner
+		// @INFO: Both writes are applied together, or not at all
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			deleteKeyFilter := bson.D{{Key: "_id", Value: keyID}, {Key: "updated_at", Value: updatedAt}, {Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}}
+			updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: key.DeletedAt}, {Key: "updated_at", Value: key.UpdatedAt}}}}
+			err := updateKeyWrite(sessCtx, keyCollection, deleteKeyFilter, updateKey)
+			if err != nil {
+				return err
+			}
+
+			updateOwnerUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}, {Key: "$pull", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+			return updateUserWrite(sessCtx, userCollection, key.OwnerID, updateOwnerUser)
+		})
+		if err == errKeyOutOfDate || err == errUserOutOfDate {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "DeleteKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"name": key.Name, "owner_id": key.OwnerID, "service_id": key.ServiceID, "quota": key.Quota, "is_active": key.IsActive}, After: bson.M{"deleted_at": key.DeletedAt}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			DeletedAt string `json:"deleted_at" bson:"deleted_at"`
+			RestoreBy string `json:"restore_by" bson:"restore_by"`
+			UpdatedAt string `json:"updated_at" bson:"updated_at"`
+		}{
+			DeletedAt: key.DeletedAt.Format(configs.DateLayout),
+			RestoreBy: key.DeletedAt.Add(configs.GetEnvKeyRetention()).Format(configs.DateLayout),
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		}
+
 		// Response
-		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: nil})
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Restore Key
+* This enables key owners, Leads and Admins (user_id) to restore
+* deleted advanced keys, returning them to their owner's keys.
+*
+* Keys can only be restored within the retention window after they were
+* deleted (see configs.GetEnvKeyRetention).
+*
+* Key owners can restore their own advanced keys.
+* Admins can restore any advanced key.
+* Leads can only restore advanced keys for services they are leads for.
+**************************************************************************/
+func RestoreKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var userID primitive.ObjectID
+		var userFilter bson.M
+		var user models.User
+
+		var keyID primitive.ObjectID
+		var keyFilter bson.M
+		var key models.Key
+
+		var updatedAt time.Time
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err = time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err = primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify keyID is valid (key exists)
+		keyFilter = bson.M{"_id": keyID}
+
+		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Key is not deleted
+		if key.DeletedAt.IsZero() {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid key_id: Key is not deleted"})
+			return
+		}
+
+		// Key was deleted before the retention window
+		// @INFO: The key is yet to be purged, but can no longer be restored
+		now := time.Now().UTC()
+		if !now.Before(key.DeletedAt.Add(configs.GetEnvKeyRetention())) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid key_id: Key was deleted too long ago to be restored"})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Check if user is owner
+		// If not, verify permissions
+		// @INFO: We assume key.OwnerID is valid
+		if key.OwnerID != userID {
+			// Verify userID is valid (user exists and has permissions)
+			userFilter = bson.M{"_id": userID}
+			err = userCollection.FindOne(ctx, userFilter).Decode(&user)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+				return
+			}
+
+			// Check if user is an Admin, or a lead of the key's service
+			// @INFO: Assumes key.ServiceID is valid
+			if user.Type != "Admin" && (user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+				c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to restore this key"})
+				return
+			}
+		}
+
+		// Restore Key
+		previousDeletedAt := key.DeletedAt
+		key.DeletedAt = time.Time{}
+		key.UpdatedAt = now
+
+		// Clear the key's tombstone and return it to its owner
+		// @INFO: Both writes are applied together, or not at all
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			restoreKeyFilter := bson.D{{Key: "_id", Value: keyID}, {Key: "updated_at", Value: updatedAt}, {Key: "deleted_at", Value: previousDeletedAt}}
+			updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}}}, {Key: "$unset", Value: bson.D{{Key: "deleted_at", Value: ""}}}}
+			err := updateKeyWrite(sessCtx, keyCollection, restoreKeyFilter, updateKey)
+			if err != nil {
+				return err
+			}
+
+			updateOwnerUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}, {Key: "$addToSet", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+			return updateUserWrite(sessCtx, userCollection, key.OwnerID, updateOwnerUser)
+		})
+		if err == errKeyOutOfDate || err == errUserOutOfDate {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "RestoreKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"deleted_at": previousDeletedAt}, After: bson.M{}})
+
+		// Respond with formated key.UpdatedAt time
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: key.UpdatedAt.Format(configs.DateLayout)})
 	}
 }
 
@@ -400,6 +689,9 @@ func DeleteKey() gin.HandlerFunc {
 * Admins can disable any key.
 * Leads can only disable advanced keys for services they are leads for.
 *
+* Suspended keys can also be disabled, which leaves them disabled once
+* their suspension is lifted.
+*
 * In general, this is used when a key is compromised.
 **************************************************************************/
 func DisableKey() gin.HandlerFunc {
@@ -457,7 +749,7 @@ func DisableKey() gin.HandlerFunc {
 		}
 
 		// Verify keyID is valid (key exists)
-		keyFilter = bson.M{"_id": keyID}
+		keyFilter = bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
 		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
@@ -468,8 +760,8 @@ func DisableKey() gin.HandlerFunc {
 			return
 		}
 
-		// Verify key is enabled
-		if !key.IsActive {
+		// Verify key is enabled, or to be re-enabled as its suspension is lifted or its windows end
+		if !key.IsActive && !keyDisabledTemporarily(key) {
 			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Key is already disabled"})
 			return
 		}
@@ -499,16 +791,38 @@ func DisableKey() gin.HandlerFunc {
 			return
 		}
 
-		// Disable key
+		// Disable key, keeping it disabled once its suspension is lifted and its windows end
+		before := bson.M{"is_active": key.IsActive}
+		after := bson.M{"is_active": false}
+		suspensionIndex := activeKeySuspension(key)
+		scheduleFields, scheduleIDs := keepScheduledKeyDisabled(key)
 		key.IsActive = false
 		key.UpdatedAt = time.Now().UTC()
 
-		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "is_active", Value: key.IsActive}}}}
-		_, err = keyCollection.UpdateOne(ctx, keyFilter, update)
+		setKey := bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "is_active", Value: key.IsActive}}
+		if suspensionIndex != -1 {
+			setKey = append(setKey, keepSuspendedKeyDisabled(suspensionIndex))
+			after["suspension_id"] = key.Suspensions[suspensionIndex].ID
+		}
+		if len(scheduleIDs) > 0 {
+			setKey = append(setKey, scheduleFields...)
+			after["schedule_ids"] = scheduleIDs
+		}
+
+		// @INFO: Matching the updated_at prevents disabling a key updated since it was found
+		keyFilter["updated_at"] = updatedAt
+		result, err := keyCollection.UpdateOne(ctx, keyFilter, bson.D{{Key: "$set", Value: setKey}})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "DisableKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: after})
 
 		// Respond with formated key.UpdatedAt time
 		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: key.UpdatedAt.Format(configs.DateLayout)})
@@ -524,6 +838,9 @@ func DisableKey() gin.HandlerFunc {
 *
 * Key owners who lack advanced permissions and are looking to renenable
 * their keys need to make requests to the endpoint for RegenerateKey().
+*
+* Suspended keys cannot be enabled until the suspension is lifted (see
+* controllers/key_suspension.go).
 **************************************************************************/
 func EnableKey() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -580,7 +897,7 @@ func EnableKey() gin.HandlerFunc {
 		}
 
 		// Verify keyID is valid (key exists)
-		keyFilter = bson.M{"_id": keyID}
+		keyFilter = bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
 
 		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
 		if err != nil {
@@ -598,6 +915,12 @@ func EnableKey() gin.HandlerFunc {
 			return
 		}
 
+		// Verify key is not suspended
+		if activeKeySuspension(key) != -1 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Key is suspended: Lift the suspension to enable it"})
+			return
+		}
+
 		// Verify matching updated_At
 		if !key.UpdatedAt.Equal(updatedAt) {
 			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
@@ -634,6 +957,9 @@ func EnableKey() gin.HandlerFunc {
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "EnableKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"is_active": !key.IsActive}, After: bson.M{"is_active": key.IsActive}})
+
 		// Respond with formated key.UpdatedAt time
 		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: key.UpdatedAt.Format(configs.DateLayout)})
 	}
@@ -642,7 +968,8 @@ func EnableKey() gin.HandlerFunc {
 /**************************************************************************
 * Regenerate Key
 * This enables key owners, Leads and Admins (user_id) to regenerate keys.
-* This also enables the key, should it had been disabled.
+* This also enables the key, should it had been disabled, unless it is
+* suspended.
 *
 * Key owners
 * Admins can regenerate any key.
@@ -703,7 +1030,7 @@ func RegenerateKey() gin.HandlerFunc {
 		}
 
 		// Get Key
-		keyFilter = bson.M{"_id": keyID}
+		keyFilter = bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
 
 		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
 		if err != nil {
@@ -746,9 +1073,10 @@ func RegenerateKey() gin.HandlerFunc {
 		}
 
 		// Regenerate key
+		wasActive := key.IsActive
 		key.Key = configs.GenerateKey()
 		key.UpdatedAt = time.Now().UTC()
-		key.IsActive = true // Enable keys on regeneration
+		key.IsActive = activeKeySuspension(key) == -1 // Enable keys on regeneration, unless suspended
 
 		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "key", Value: key.Key}, {Key: "is_active", Value: key.IsActive}}}}
 		_, err = keyCollection.UpdateOne(ctx, keyFilter, update)
@@ -757,6 +1085,10 @@ func RegenerateKey() gin.HandlerFunc {
 			return
 		}
 
+		// Record audit event
+		// @INFO: The key itself is never recorded
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "RegenerateKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"is_active": wasActive}, After: bson.M{"is_active": key.IsActive}})
+
 		// @TODO: Refactor to key_response type
 		res := struct {
 			Key       string `json:"key" bson:"key"`
@@ -836,7 +1168,7 @@ func RenameKey() gin.HandlerFunc {
 		}
 
 		// Verify keyID is valid (key exists)
-		keyFilter = bson.M{"_id": keyID}
+		keyFilter = bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
 
 		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
 		if err != nil {
@@ -869,6 +1201,7 @@ func RenameKey() gin.HandlerFunc {
 		}
 
 		// Rename key
+		previousName := key.Name
 		key.Name = keyName
 		key.UpdatedAt = time.Now().UTC()
 
@@ -879,6 +1212,9 @@ func RenameKey() gin.HandlerFunc {
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "RenameKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"name": previousName}, After: bson.M{"name": key.Name}})
+
 		// Respond with formated key.UpdatedAt time
 		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: key.UpdatedAt.Format(configs.DateLayout)})
 	}
@@ -891,6 +1227,28 @@ func RenameKey() gin.HandlerFunc {
 * Admins can set key quotas for any key.
 * Leads can only set key quotas of advanced keys
 * for services they are leads for.
+*
+* The quota mode (quota_mode) can optionally be changed between
+* 'Fixed', 'Sliding', and 'Credit'. Sliding quotas require a window length
+* in hours (quota_window_hours). Credit quota keys keep their credit
+* balance, which is topped up using controllers/credit.go.
+*
+* The key's calendar-aligned quota windows can optionally be replaced
+* (quota_windows) given as unit:quota pairs, e.g. "Hour:1000,Month:20000".
+* An empty quota_windows removes all of the key's quota windows.
+*
+* The IANA time zone (timezone) and local hour (reset_hour) the key's
+* quota resets at can optionally be set, overriding the key's service.
+* Empty values fall back to the service's settings.
+*
+* The percent of unused usage the key carries into its next quota period
+* (rollover_percent) and the cap on the carried usage (rollover_cap) can
+* optionally be set, overriding the key's service. Empty values fall back
+* to the service's policy.
+*
+* Should the key subscribe to a plan, changing its quota, quota_num_days,
+* or quota_windows overrides the plan's, so the key is no longer updated
+* when the plan is edited.
 **************************************************************************/
 func SetKeyQuota() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -962,7 +1320,7 @@ func SetKeyQuota() gin.HandlerFunc {
 		quota = (int)(quotaI64)
 
 		// Verify keyID is valid (key exists)
-		keyFilter = bson.M{"_id": keyID}
+		keyFilter = bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
 
 		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
 		if err != nil {
@@ -975,6 +1333,7 @@ func SetKeyQuota() gin.HandlerFunc {
 		}
 
 		// Get quotaNumDays (optional)
+		previousQuotaNumDays := key.QuotaNumDays
 		quotaNumDaysStr, exists := c.GetQuery("quota_num_days")
 		if exists {
 			quotaNumDaysI64, err := strconv.ParseInt(quotaNumDaysStr, 10, 32)
@@ -986,6 +1345,115 @@ func SetKeyQuota() gin.HandlerFunc {
 			key.QuotaNumDays = (int)(quotaNumDaysI64)
 		}
 
+		// Get quotaMode (optional)
+		previousQuotaMode := key.QuotaMode
+		quotaMode, exists := c.GetQuery("quota_mode")
+		if exists {
+			if quotaMode != "Fixed" && quotaMode != "Sliding" && quotaMode != "Credit" {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota_mode. Must be 'Fixed', 'Sliding', or 'Credit'"})
+				return
+			}
+			key.QuotaMode = quotaMode
+		}
+
+		// Get quotaWindowHours (optional)
+		quotaWindowHoursStr, exists := c.GetQuery("quota_window_hours")
+		if exists {
+			quotaWindowHoursI64, err := strconv.ParseInt(quotaWindowHoursStr, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			key.QuotaWindowHours = (int)(quotaWindowHoursI64)
+		}
+
+		// Sliding quotas require a window
+		if key.QuotaMode == "Sliding" && key.QuotaWindowHours <= 0 {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Sliding quotas must include a positive 'quota_window_hours' field"})
+			return
+		}
+
+		// Get timezone (optional)
+		previousTimezone := key.Timezone
+		timezone, exists := c.GetQuery("timezone")
+		if exists {
+			_, err = configs.LoadQuotaLocation(timezone)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid timezone: Must be an IANA time zone (e.g. America/Chicago)"})
+				return
+			}
+			key.Timezone = timezone
+		}
+
+		// Get resetHour (optional)
+		previousResetHour := key.ResetHour
+		resetHourStr, exists := c.GetQuery("reset_hour")
+		if exists {
+			if resetHourStr == "" {
+				key.ResetHour = nil
+			} else {
+				resetHour, err := strconv.Atoi(resetHourStr)
+				if err != nil || resetHour < 0 || resetHour > 23 {
+					c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid reset_hour: Must be between 0 and 23"})
+					return
+				}
+				key.ResetHour = &resetHour
+			}
+		}
+
+		// Get rolloverPercent (optional)
+		previousRolloverPercent := key.RolloverPercent
+		rolloverPercentStr, exists := c.GetQuery("rollover_percent")
+		if exists {
+			if rolloverPercentStr == "" {
+				key.RolloverPercent = nil
+			} else {
+				rolloverPercent, err := strconv.Atoi(rolloverPercentStr)
+				if err != nil || rolloverPercent < 0 || rolloverPercent > 100 {
+					c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_percent: Must be between 0 and 100"})
+					return
+				}
+				key.RolloverPercent = &rolloverPercent
+			}
+		}
+
+		// Get rolloverCap (optional)
+		previousRolloverCap := key.RolloverCap
+		rolloverCapStr, exists := c.GetQuery("rollover_cap")
+		if exists {
+			if rolloverCapStr == "" {
+				key.RolloverCap = nil
+			} else {
+				rolloverCap, err := strconv.Atoi(rolloverCapStr)
+				if err != nil || rolloverCap < 0 {
+					c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_cap: Must not be negative"})
+					return
+				}
+				key.RolloverCap = &rolloverCap
+			}
+		}
+
+		// Get the time zone and reset hour of the key's quota
+		loc, resetHour, err := findKeyQuotaSchedule(ctx, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get quotaWindows (optional)
+		previousQuotaWindows := key.QuotaWindows
+		quotaWindowsStr, quotaWindowsExist := c.GetQuery("quota_windows")
+		if quotaWindowsExist {
+			key.QuotaWindows, err = parseQuotaWindows(quotaWindowsStr, time.Now().UTC(), loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+		if key.QuotaWindows == nil {
+			key.QuotaWindows = []models.QuotaWindow{}
+		}
+
 		// Verify matching updated_At
 		if !key.UpdatedAt.Equal(updatedAt) {
 			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
@@ -1013,32 +1481,61 @@ func SetKeyQuota() gin.HandlerFunc {
 
 		now := time.Now().UTC()
 
+		before := bson.M{"quota_override": key.QuotaOverride, "quota": key.Quota, "quota_num_days": previousQuotaNumDays, "quota_mode": previousQuotaMode, "quota_windows": previousQuotaWindows, "timezone": previousTimezone, "reset_hour": previousResetHour, "rollover_percent": previousRolloverPercent, "rollover_cap": previousRolloverCap, "usage_remaining": key.UsageRemaining, "quota_timestamp": key.QuotaTimestamp}
+
+		// Override the key's plan, should its quota be changed
+		// @INFO: Time zone, reset hour, and rollover changes leave the key following its plan
+		if key.PlanID != primitive.NilObjectID && (quota != key.Quota || key.QuotaNumDays != previousQuotaNumDays || quotaWindowsExist) {
+			key.QuotaOverride = true
+		}
+
 		// Set quota
 		key.Quota = quota
 		key.UsageRemaining = key.Quota
 		key.UpdatedAt = now
-		key.QuotaTimestamp = time.Date(now.Year(), now.Month(), now.Day()+key.QuotaNumDays, 0, 0, 0, 0, time.UTC)
+		key.QuotaTimestamp = configs.NextQuotaTimestamp(now, key.QuotaNumDays, loc, resetHour)
+		key.UsageBuckets = []models.UsageBucket{}
+		key.RolloverCarried = 0
 
-		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "quota", Value: key.Quota}, {Key: "quota_num_days", Value: key.QuotaNumDays}, {Key: "quota_timestamp", Value: key.QuotaTimestamp}, {Key: "usage_remaining", Value: key.UsageRemaining}}}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "quota_override", Value: key.QuotaOverride}, {Key: "quota", Value: key.Quota}, {Key: "quota_num_days", Value: key.QuotaNumDays}, {Key: "quota_mode", Value: key.QuotaMode}, {Key: "quota_window_hours", Value: key.QuotaWindowHours}, {Key: "usage_buckets", Value: key.UsageBuckets}, {Key: "quota_windows", Value: key.QuotaWindows}, {Key: "timezone", Value: key.Timezone}, {Key: "reset_hour", Value: key.ResetHour}, {Key: "rollover_percent", Value: key.RolloverPercent}, {Key: "rollover_cap", Value: key.RolloverCap}, {Key: "rollover_carried", Value: key.RolloverCarried}, {Key: "quota_timestamp", Value: key.QuotaTimestamp}, {Key: "usage_remaining", Value: key.UsageRemaining}, {Key: "notified_thresholds", Value: bson.A{}}}}}
 		_, err = keyCollection.UpdateOne(ctx, keyFilter, update)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetKeyQuota", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: bson.M{"quota_override": key.QuotaOverride, "quota": key.Quota, "quota_num_days": key.QuotaNumDays, "quota_mode": key.QuotaMode, "quota_windows": key.QuotaWindows, "timezone": key.Timezone, "reset_hour": key.ResetHour, "rollover_percent": key.RolloverPercent, "rollover_cap": key.RolloverCap, "usage_remaining": key.UsageRemaining, "quota_timestamp": key.QuotaTimestamp}})
+
 		// @TODO: Refactor to key_response type
 		res := struct {
-			Quota          int    `json:"quota" bson:"quota"`
-			QuotaNumDays   int    `json:"quota_num_days" bson:"quota_num_days"`
-			UsageRemaining int    `json:"usage_remaining" bson:"usage_remaining"`
-			QuotaTimestamp string `json:"quota_timestamp"`
-			UpdatedAt      string `json:"updated_at" bson:"updated_at"`
+			Quota            int                  `json:"quota" bson:"quota"`
+			QuotaNumDays     int                  `json:"quota_num_days" bson:"quota_num_days"`
+			QuotaMode        string               `json:"quota_mode,omitempty" bson:"quota_mode,omitempty"`
+			QuotaWindowHours int                  `json:"quota_window_hours,omitempty" bson:"quota_window_hours,omitempty"`
+			QuotaWindows     []models.QuotaWindow `json:"quota_windows" bson:"quota_windows"`
+			Timezone         string               `json:"timezone,omitempty" bson:"timezone,omitempty"`
+			ResetHour        *int                 `json:"reset_hour,omitempty" bson:"reset_hour,omitempty"`
+			RolloverPercent  *int                 `json:"rollover_percent,omitempty" bson:"rollover_percent,omitempty"`
+			RolloverCap      *int                 `json:"rollover_cap,omitempty" bson:"rollover_cap,omitempty"`
+			RolloverCarried  int                  `json:"rollover_carried" bson:"rollover_carried"`
+			UsageRemaining   int                  `json:"usage_remaining" bson:"usage_remaining"`
+			QuotaTimestamp   string               `json:"quota_timestamp"`
+			UpdatedAt        string               `json:"updated_at" bson:"updated_at"`
 		}{
-			Quota:          key.Quota,
-			QuotaNumDays:   key.QuotaNumDays,
-			UsageRemaining: key.UsageRemaining,
-			QuotaTimestamp: key.QuotaTimestamp.Format(configs.DateLayout),
-			UpdatedAt:      key.UpdatedAt.Format(configs.DateLayout),
+			Quota:            key.Quota,
+			QuotaNumDays:     key.QuotaNumDays,
+			QuotaMode:        key.QuotaMode,
+			QuotaWindowHours: key.QuotaWindowHours,
+			QuotaWindows:     key.QuotaWindows,
+			Timezone:         key.Timezone,
+			ResetHour:        key.ResetHour,
+			RolloverPercent:  key.RolloverPercent,
+			RolloverCap:      key.RolloverCap,
+			RolloverCarried:  key.RolloverCarried,
+			UsageRemaining:   key.UsageRemaining,
+			QuotaTimestamp:   key.QuotaTimestamp.Format(configs.DateLayout),
+			UpdatedAt:        key.UpdatedAt.Format(configs.DateLayout),
 		}
 
 		// Respond
@@ -1054,7 +1551,8 @@ func SetKeyQuota() gin.HandlerFunc {
 * Leads can only restore key quotas of advanced keys
 * for services they are leads for.
 *
-* This does not alter the quotaTimestamp.
+* This does not alter the quotaTimestamp, and keeps any usage carried
+* over from the previous quota period.
 **************************************************************************/
 func RestoreKeyQuota() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -1111,7 +1609,7 @@ func RestoreKeyQuota() gin.HandlerFunc {
 		}
 
 		// Verify keyID is valid (key exists)
-		keyFilter = bson.M{"_id": keyID}
+		keyFilter = bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
 
 		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
 		if err != nil {
@@ -1149,16 +1647,20 @@ func RestoreKeyQuota() gin.HandlerFunc {
 		}
 
 		// Set UsageRemaining
-		key.UsageRemaining = key.Quota
+		previousUsageRemaining := key.UsageRemaining
+		key.UsageRemaining = key.Quota + key.RolloverCarried
 		key.UpdatedAt = time.Now().UTC()
 
-		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "usage_remaining", Value: key.UsageRemaining}}}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "usage_remaining", Value: key.UsageRemaining}, {Key: "usage_buckets", Value: bson.A{}}, {Key: "notified_thresholds", Value: bson.A{}}}}}
 		_, err = keyCollection.UpdateOne(ctx, keyFilter, update)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "RestoreKeyQuota", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"usage_remaining": previousUsageRemaining}, After: bson.M{"usage_remaining": key.UsageRemaining}})
+
 		// @TODO: Refactor to key_response type
 		res := struct {
 			UsageRemaining int    `json:"usage_remaining" bson:"usage_remaining"`
@@ -1239,7 +1741,7 @@ func ChangeKeyHolder() gin.HandlerFunc {
 		}
 
 		// Verify keyID is valid (key exists)
-		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID}).Decode(&key)
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
@@ -1280,34 +1782,29 @@ func ChangeKeyHolder() gin.HandlerFunc {
 
 		// Get previous key owner
 		previousKeyOwnerUserID := key.OwnerID
+		updatedAt := key.UpdatedAt
 
 		// Set Key owner
 		key.OwnerID = recipientUserID
 		key.UpdatedAt = time.Now().UTC()
 
-		// Update Key
-		updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "owner_id", Value: key.OwnerID}, {Key: "updated_at", Value: key.UpdatedAt}}}}
-		_, err = keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}}, updateKey)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+		// Update Key, remove key from previous owner, and update recipient user with new key
+		// @INFO: All three writes are applied together, or not at all
+		// @INFO: Matching the updated_at prevents changing the holder of a key updated since it was found
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			return changeKeyHolderWrites(sessCtx, keyCollection, userCollection, key, updatedAt, previousKeyOwnerUserID, recipientUserID)
+		})
+		if err == errKeyOutOfDate || err == errUserOutOfDate {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
 			return
 		}
-
-		// Remove key from previous owner
-		updatePreviousKeyOwnerUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}, {Key: "$pull", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
-		_, err = userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: previousKeyOwnerUserID}}, updatePreviousKeyOwnerUser)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
-		// Update recipient user with new key
-		updateRecipientUser := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}}, {Key: "$push", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
-		_, err = userCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: recipientUserID}}, updateRecipientUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
-			return
-		}
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: assignerUserID, Action: "ChangeKeyHolder", TargetKeyID: key.ID, TargetUserID: recipientUserID, TargetServiceID: key.ServiceID, Before: bson.M{"owner_id": previousKeyOwnerUserID}, After: bson.M{"owner_id": key.OwnerID}})
 
 		// @TODO: Refactor to key_response type
 		res := struct {
@@ -1330,6 +1827,8 @@ func ChangeKeyHolder() gin.HandlerFunc {
 * Admins can change the service of any advanced key.
 * Leads can only change the service of advanced keys for services
 * they are leads for, to another service they lead.
+*
+* Pools are of a single service, so the key is removed from its pool.
 **************************************************************************/
 func ChangeKeyService() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -1388,7 +1887,7 @@ func ChangeKeyService() gin.HandlerFunc {
 		}
 
 		// Verify keyID is valid (key exists)
-		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID}).Decode(&key)
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
@@ -1427,18 +1926,31 @@ func ChangeKeyService() gin.HandlerFunc {
 			return
 		}
 
-		// Set Key service
+		// Verify key environment matches the given service
+		if err := verifyKeyEnvironment(keyEnvironment(key), service); err != nil {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Set Key service, removing it from its pool
+		before := bson.M{"service_id": key.ServiceID, "pool_id": key.PoolID, "pool_only": key.PoolOnly}
+		previousServiceID := key.ServiceID
 		key.ServiceID = serviceID
+		key.PoolID = primitive.NilObjectID
+		key.PoolOnly = false
 		key.UpdatedAt = time.Now().UTC()
 
 		// Update Key
-		updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "owner_id", Value: key.OwnerID}, {Key: "updated_at", Value: key.UpdatedAt}}}}
+		updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "service_id", Value: key.ServiceID}, {Key: "updated_at", Value: key.UpdatedAt}}}, {Key: "$unset", Value: bson.D{{Key: "pool_id", Value: ""}, {Key: "pool_only", Value: ""}}}}
 		_, err = keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}}, updateKey)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
 		}
 
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "ChangeKeyService", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, PreviousTargetServiceID: previousServiceID, Before: before, After: bson.M{"service_id": key.ServiceID, "pool_id": key.PoolID, "pool_only": key.PoolOnly}})
+
 		// @TODO: Refactor to key_response type
 		res := struct {
 			ServiceID primitive.ObjectID `json:"serive_id" bson:"service_id"`
@@ -0,0 +1,478 @@
+/**************************************************************************
+* Key details endpoint logic.
+*
+* Keys can record a free-form description and contact, and key/value
+* labels (e.g. app=portal, ticket=OPS-12), so Leads managing many keys
+* can tell which application, contact, or ticket each key belongs to.
+*
+* Key owners can edit the details of their own keys.
+* Admins can edit the details of any key.
+* Leads can only edit the details of advanced keys for services they
+* are leads for.
+*
+* Keys can be filtered by label when listed (see GetKeys) or updated in
+* bulk (see BulkUpdateKeys).
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on the details of a key
+const maxKeyDescriptionLength = 1024
+const maxKeyContactLength = 256
+const maxKeyLabels = 32
+const maxKeyLabelValueLength = 256
+
+// Label names are also used as field names, so they are restricted to safe characters
+var keyLabelNamePattern *regexp.Regexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+/**************************************************************************
+* Validate Key Labels
+* This verifies the given labels have valid names and values, and that
+* there are not too many of them.
+**************************************************************************/
+func validateKeyLabels(labels map[string]string) error {
+	if len(labels) > maxKeyLabels {
+		return errors.New("Invalid labels: A key can have at most " + strconv.Itoa(maxKeyLabels) + " labels")
+	}
+	for name, value := range labels {
+		if !keyLabelNamePattern.MatchString(name) {
+			return errors.New("Invalid labels: Label names must be 1 to 64 letters, digits, '_' or '-'")
+		}
+		if len(value) > maxKeyLabelValueLength {
+			return errors.New("Invalid labels: Label values must be at most " + strconv.Itoa(maxKeyLabelValueLength) + " characters")
+		}
+	}
+	return nil
+}
+
+/**************************************************************************
+* Parse Key Labels
+* This parses comma separated labels (e.g. "app=portal,ticket=OPS-12").
+**************************************************************************/
+func parseKeyLabels(labelsStr string) (map[string]string, error) {
+	labels := map[string]string{}
+	if labelsStr == "" {
+		return labels, nil
+	}
+
+	for _, labelStr := range strings.Split(labelsStr, ",") {
+		name, value, found := strings.Cut(labelStr, "=")
+		if !found {
+			return nil, errors.New("Invalid labels: Each label must be given as name=value")
+		}
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return labels, validateKeyLabels(labels)
+}
+
+/**************************************************************************
+* Parse Key Label Filters
+* This parses label filters into conditions keys must match. Filters are
+* given as "name=value", matching keys with that label value, or "name",
+* matching keys with that label set to any value.
+**************************************************************************/
+func parseKeyLabelFilters(labelFilters []string) ([]bson.D, error) {
+	conditions := []bson.D{}
+	for _, labelFilter := range labelFilters {
+		name, value, hasValue := strings.Cut(labelFilter, "=")
+		if !keyLabelNamePattern.MatchString(name) {
+			return nil, errors.New("Invalid label: Label names must be 1 to 64 letters, digits, '_' or '-'")
+		}
+		if hasValue {
+			conditions = append(conditions, bson.D{{Key: "labels." + name, Value: value}})
+		} else {
+			conditions = append(conditions, bson.D{{Key: "labels." + name, Value: bson.D{{Key: "$exists", Value: true}}}})
+		}
+	}
+	return conditions, nil
+}
+
+/**************************************************************************
+* Can Edit Key Details
+* This checks whether the user has the authority to edit the details of
+* the key, being its owner, an Admin, or a lead of its service.
+**************************************************************************/
+func canEditKeyDetails(user models.User, key models.Key) bool {
+	if key.OwnerID == user.ID || user.Type == "Admin" {
+		return true
+	}
+	return key.Type == "Advanced" && user.Type == "Lead" && slices.Contains(user.Services, key.ServiceID)
+}
+
+/**************************************************************************
+* Set Key Details
+* This enables key owners, Leads and Admins (user_id) to set the
+* description (description), contact (contact), and labels (labels) of
+* a key (key_id).
+*
+* Only the given details are changed. Labels are given as comma separated
+* name=value pairs (e.g. "app=portal,ticket=OPS-12"), and replace all of
+* the key's labels. An empty value removes a detail.
+**************************************************************************/
+func SetKeyDetails() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err := time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get description, contact, and labels (each optional)
+		description, setDescription := c.GetQuery("description")
+		if len(description) > maxKeyDescriptionLength {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid description: Must be at most " + strconv.Itoa(maxKeyDescriptionLength) + " characters"})
+			return
+		}
+		contact, setContact := c.GetQuery("contact")
+		if len(contact) > maxKeyContactLength {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid contact: Must be at most " + strconv.Itoa(maxKeyContactLength) + " characters"})
+			return
+		}
+		labelsStr, setLabels := c.GetQuery("labels")
+		labels, err := parseKeyLabels(labelsStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+		if !setDescription && !setContact && !setLabels {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include at least one of the 'description', 'contact', or 'labels' fields"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		keyFilter := bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
+		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Check user permissions
+		if !canEditKeyDetails(user, key) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to edit this key"})
+			return
+		}
+
+		// Set details
+		before := bson.M{}
+		after := bson.M{}
+		set := bson.D{}
+		unset := bson.D{}
+		if setDescription {
+			before["description"] = key.Description
+			after["description"] = description
+			key.Description = description
+			if description == "" {
+				unset = append(unset, bson.E{Key: "description", Value: ""})
+			} else {
+				set = append(set, bson.E{Key: "description", Value: description})
+			}
+		}
+		if setContact {
+			before["contact"] = key.Contact
+			after["contact"] = contact
+			key.Contact = contact
+			if contact == "" {
+				unset = append(unset, bson.E{Key: "contact", Value: ""})
+			} else {
+				set = append(set, bson.E{Key: "contact", Value: contact})
+			}
+		}
+		if setLabels {
+			before["labels"] = key.Labels
+			after["labels"] = labels
+			key.Labels = labels
+			if len(labels) == 0 {
+				unset = append(unset, bson.E{Key: "labels", Value: ""})
+			} else {
+				set = append(set, bson.E{Key: "labels", Value: labels})
+			}
+		}
+		key.UpdatedAt = time.Now().UTC()
+		set = append(set, bson.E{Key: "updated_at", Value: key.UpdatedAt})
+
+		update := bson.D{{Key: "$set", Value: set}}
+		if len(unset) > 0 {
+			update = append(update, bson.E{Key: "$unset", Value: unset})
+		}
+
+		// @INFO: Matching the updated_at prevents overwriting concurrent changes
+		result, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: keyID}, {Key: "updated_at", Value: updatedAt}}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetKeyDetails", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: after})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Description string            `json:"description" bson:"description"`
+			Contact     string            `json:"contact" bson:"contact"`
+			Labels      map[string]string `json:"labels" bson:"labels"`
+			UpdatedAt   string            `json:"updated_at" bson:"updated_at"`
+		}{
+			Description: key.Description,
+			Contact:     key.Contact,
+			Labels:      key.Labels,
+			UpdatedAt:   key.UpdatedAt.Format(configs.DateLayout),
+		}
+		if res.Labels == nil {
+			res.Labels = map[string]string{}
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Import Key Labels
+* This enables key owners, Leads and Admins (user_id) to set the labels
+* of many keys at once, given as a JSON array in the request body:
+*
+*   [{"key_id": "...", "labels": {"app": "portal", "ticket": "OPS-12"}}]
+*
+* By default (mode 'Merge') the given labels are added to each key's
+* labels, replacing labels of the same name. With mode 'Replace' they
+* replace all of each key's labels.
+*
+* The usual permission rules apply to each key, and keys the user
+* cannot edit are skipped. The result of each key is returned.
+**************************************************************************/
+func ImportKeyLabels() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var imports []struct {
+			KeyID  primitive.ObjectID `json:"key_id"`
+			Labels map[string]string  `json:"labels"`
+		}
+
+		// @INFO: Acting on many keys can take longer than other requests
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get mode (optional)
+		mode := c.DefaultQuery("mode", "Merge")
+		if mode != "Merge" && mode != "Replace" {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid mode. Must be 'Merge' or 'Replace'"})
+			return
+		}
+
+		// Get imports
+		if err := c.BindJSON(&imports); err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+		if len(imports) > maxBulkKeys {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request includes " + strconv.Itoa(len(imports)) + " keys, more than the " + strconv.Itoa(maxBulkKeys) + " a bulk operation can act on"})
+			return
+		}
+		for _, labelImport := range imports {
+			if err := validateKeyLabels(labelImport.Labels); err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Key " + labelImport.KeyID.Hex() + ": " + err.Error()})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Import the labels of each key
+		counts := map[string]int{"Succeeded": 0, "Skipped": 0, "Failed": 0}
+		results := []bulkKeyResult{}
+		for _, labelImport := range imports {
+			result := importKeyLabels(ctx, c, user, labelImport.KeyID, labelImport.Labels, mode)
+			counts[result.Outcome]++
+			results = append(results, result)
+		}
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Mode    string          `json:"mode"`
+			Counts  map[string]int  `json:"counts"`
+			Results []bulkKeyResult `json:"results"`
+		}{
+			Mode:    mode,
+			Counts:  counts,
+			Results: results,
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Import Key Labels (single key)
+* This imports the labels of a single key on behalf of the user, should
+* they have the authority to, recording an audit event as SetKeyDetails
+* does.
+**************************************************************************/
+func importKeyLabels(ctx context.Context, c *gin.Context, user models.User, keyID primitive.ObjectID, labels map[string]string, mode string) bulkKeyResult {
+	var key models.Key
+	result := bulkKeyResult{KeyID: keyID}
+
+	err := keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+	if err != nil {
+		result.Outcome = "Failed"
+		result.Message = err.Error()
+		if err == mongo.ErrNoDocuments {
+			result.Message = "Invalid key_id: Key does not exist"
+		}
+		return result
+	}
+
+	// Check user permissions
+	if !canEditKeyDetails(user, key) {
+		result.Outcome = "Skipped"
+		result.Message = "The given user does not have the authority to edit this key"
+		return result
+	}
+
+	// Build the key's new labels
+	newLabels := map[string]string{}
+	if mode == "Merge" {
+		for name, value := range key.Labels {
+			newLabels[name] = value
+		}
+	}
+	for name, value := range labels {
+		newLabels[name] = value
+	}
+	if err := validateKeyLabels(newLabels); err != nil {
+		result.Outcome = "Failed"
+		result.Message = err.Error()
+		return result
+	}
+
+	now := time.Now().UTC()
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "labels", Value: newLabels}}}}
+
+	// @INFO: Matching the updated_at leaves keys updated since they were found
+	updateResult, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: key.UpdatedAt}}, update)
+	if err != nil {
+		result.Outcome = "Failed"
+		result.Message = err.Error()
+		return result
+	}
+	if updateResult.ModifiedCount == 0 {
+		result.Outcome = "Failed"
+		result.Message = "Out of date request: Key has been updated"
+		return result
+	}
+
+	// Record audit event
+	recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: user.ID, Action: "SetKeyDetails", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"labels": key.Labels}, After: bson.M{"labels": newLabels}})
+
+	result.Outcome = "Succeeded"
+	result.UpdatedAt = now.Format(configs.DateLayout)
+	return result
+}
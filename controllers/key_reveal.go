@@ -0,0 +1,574 @@
+/**************************************************************************
+* Key reveal endpoint logic.
+*
+* Advanced keys are hidden (_HIDDEN_) from the user creating them, and
+* every key is hidden from the endpoints listing keys, so their secrets
+* can only be obtained through one of two flows:
+*
+*  - Claim: A single-use claim token is issued to the recipient of a new
+*    advanced key, and expires after KEY_CLAIM_TTL_HOURS. The recipient
+*    exchanges the token for the secret using ClaimKey.
+*  - Reveal: Key owners and Admins re-authenticate with a one-time code
+*    delivered to them (CreateKeyRevealChallenge), and exchange the code
+*    for the secret, or a newly regenerated secret (RevealKey).
+*
+* Claim tokens and reveal codes are delivered through the configured
+* notifier (see notifiers/notifier.go), and only their hashes are stored.
+* As the 'log' notifier redacts them, the flows can only be completed
+* with the 'smtp' or 'webhook' notifier.
+*
+* Every attempt, successful or not, is recorded in 'key_reveal_attempts'
+* and counts towards the user's hourly limit. Successful claims and
+* reveals are also recorded in the audit log.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/notifiers"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/gin-gonic/gin"
+)
+
+var keyClaimCollection *mongo.Collection = configs.GetCollection(configs.DB, "key_claims")
+var keyRevealChallengeCollection *mongo.Collection = configs.GetCollection(configs.DB, "key_reveal_challenges")
+var keyRevealAttemptCollection *mongo.Collection = configs.GetCollection(configs.DB, "key_reveal_attempts")
+
+// Maximum number of claim and reveal attempts a user can make per hour
+const maxKeyRevealAttemptsPerHour = 10
+
+// How long a reveal code is valid for, and how many guesses it allows
+const keyRevealCodeTTL = 10 * time.Minute
+const maxKeyRevealCodeAttempts = 5
+
+/**************************************************************************
+* Hash Key Reveal Secret
+* This hashes a claim token or reveal code for storage and comparison.
+**************************************************************************/
+func hashKeyRevealSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+/**************************************************************************
+* Key Reveal Rate Limited
+* This checks whether the given user has reached the maximum number of
+* claim and reveal attempts within the past hour.
+**************************************************************************/
+func keyRevealRateLimited(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	filter := bson.D{{Key: "user_id", Value: userID}, {Key: "created_at", Value: bson.D{{Key: "$gt", Value: time.Now().UTC().Add(-time.Hour)}}}}
+	attempts, err := keyRevealAttemptCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return attempts >= maxKeyRevealAttemptsPerHour, nil
+}
+
+/**************************************************************************
+* Record Key Reveal Attempt
+* This records a claim or reveal attempt, counting it towards the
+* user's hourly limit. Errors are logged.
+**************************************************************************/
+func recordKeyRevealAttempt(ctx context.Context, c *gin.Context, attempt models.KeyRevealAttempt) {
+	attempt.ID = primitive.NewObjectID()
+	attempt.ClientIP = c.ClientIP()
+	attempt.CreatedAt = time.Now().UTC()
+
+	_, err := keyRevealAttemptCollection.InsertOne(ctx, attempt)
+	if err != nil {
+		log.Printf("Unable to record '%s' attempt for key %s: %v", attempt.Flow, attempt.KeyID.Hex(), err)
+	}
+}
+
+/**************************************************************************
+* Issue Key Claim
+* This issues a new single-use claim token for the given key, replacing
+* any previously issued, and delivers it to the key's owner.
+**************************************************************************/
+func issueKeyClaim(ctx context.Context, key models.Key) error {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	claim := models.KeyClaim{
+		ID:        primitive.NewObjectID(),
+		KeyID:     key.ID,
+		UserID:    key.OwnerID,
+		TokenHash: hashKeyRevealSecret(token),
+		CreatedAt: time.Now().UTC(),
+	}
+	claim.ExpiresAt = claim.CreatedAt.Add(configs.GetEnvKeyClaimTTL())
+
+	_, err = keyClaimCollection.DeleteMany(ctx, bson.M{"key_id": key.ID})
+	if err != nil {
+		return err
+	}
+	_, err = keyClaimCollection.InsertOne(ctx, claim)
+	if err != nil {
+		return err
+	}
+
+	notifyUser(key.OwnerID, notifiers.Notification{
+		Event:   "KeyClaim",
+		Subject: fmt.Sprintf("Claim your new key '%s'", key.Name),
+		Message: fmt.Sprintf("An advanced key '%s' has been created for you. Claim it with the claim token '%s' before %s. The token can only be used once.", key.Name, token, claim.ExpiresAt.Format(configs.DateLayout)),
+		Data:    map[string]interface{}{"key_id": key.ID.Hex(), "claim_token": token, "expires_at": claim.ExpiresAt.Format(configs.DateLayout)},
+		Secrets: []string{token},
+	})
+
+	return nil
+}
+
+/**************************************************************************
+* Claim Key
+* This enables the owner (user_id) of a new advanced key (key_id) to
+* obtain its secret, using the claim token (claim_token) issued to them
+* when the key was created.
+*
+* Claim tokens can only be used once, and expire after
+* KEY_CLAIM_TTL_HOURS.
+**************************************************************************/
+func ClaimKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var claim models.KeyClaim
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get claimToken
+		claimToken, exists := c.GetQuery("claim_token")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'claim_token' field"})
+			return
+		}
+
+		// Check rate limit
+		rateLimited, err := keyRevealRateLimited(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if rateLimited {
+			c.JSON(http.StatusTooManyRequests, responses.KeyResponse{Status: http.StatusTooManyRequests, Message: "error", Data: "Too many attempts: Try again later"})
+			return
+		}
+
+		attempt := models.KeyRevealAttempt{KeyID: keyID, UserID: userID, Flow: "Claim"}
+		now := time.Now().UTC()
+
+		// Use claim
+		// @INFO: Marking the claim within the lookup ensures it is only used once
+		claimFilter := bson.D{{Key: "key_id", Value: keyID}, {Key: "user_id", Value: userID}, {Key: "token_hash", Value: hashKeyRevealSecret(claimToken)}, {Key: "claimed_at", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}}}
+		err = keyClaimCollection.FindOneAndUpdate(ctx, claimFilter, bson.D{{Key: "$set", Value: bson.D{{Key: "claimed_at", Value: now}}}}).Decode(&claim)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				attempt.Reason = "Invalid claim token"
+				recordKeyRevealAttempt(ctx, c, attempt)
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid claim_token: Token is invalid, expired, or already claimed"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "owner_id": userID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				attempt.Reason = "Key does not exist"
+				recordKeyRevealAttempt(ctx, c, attempt)
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record attempt and audit event
+		// @INFO: The key itself is never recorded
+		attempt.Succeeded = true
+		recordKeyRevealAttempt(ctx, c, attempt)
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "ClaimKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Key       string `json:"key" bson:"key"`
+			UpdatedAt string `json:"updated_at" bson:"updated_at"`
+		}{
+			Key:       key.Key,
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Create Key Reveal Challenge
+* This enables key owners and Admins (user_id) to request a one-time
+* code with which to reveal a key (key_id) using RevealKey.
+*
+* The code is delivered to the user, replacing any previously requested
+* for the key, and expires after 10 minutes.
+**************************************************************************/
+func CreateKeyRevealChallenge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check rate limit
+		rateLimited, err := keyRevealRateLimited(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if rateLimited {
+			c.JSON(http.StatusTooManyRequests, responses.KeyResponse{Status: http.StatusTooManyRequests, Message: "error", Data: "Too many attempts: Try again later"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		attempt := models.KeyRevealAttempt{KeyID: keyID, UserID: userID, Flow: "Challenge"}
+
+		// Check user permissions
+		if key.OwnerID != userID && user.Type != "Admin" {
+			attempt.Reason = "Not permitted"
+			recordKeyRevealAttempt(ctx, c, attempt)
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to reveal this key"})
+			return
+		}
+
+		// Generate code
+		codeNum, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		code := fmt.Sprintf("%06d", codeNum.Int64())
+
+		challenge := models.KeyRevealChallenge{
+			ID:        primitive.NewObjectID(),
+			KeyID:     keyID,
+			UserID:    userID,
+			CodeHash:  hashKeyRevealSecret(code),
+			CreatedAt: time.Now().UTC(),
+		}
+		challenge.ExpiresAt = challenge.CreatedAt.Add(keyRevealCodeTTL)
+
+		// Replace any previous challenges
+		_, err = keyRevealChallengeCollection.DeleteMany(ctx, bson.M{"key_id": keyID, "user_id": userID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		_, err = keyRevealChallengeCollection.InsertOne(ctx, challenge)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Deliver code
+		notifyUser(userID, notifiers.Notification{
+			Event:   "KeyRevealCode",
+			Subject: fmt.Sprintf("Your code to reveal key '%s'", key.Name),
+			Message: fmt.Sprintf("Use the code %s to reveal key '%s' before %s. If you did not request this code, your account may be compromised.", code, key.Name, challenge.ExpiresAt.Format(configs.DateLayout)),
+			Data:    map[string]interface{}{"key_id": keyID.Hex(), "code": code, "expires_at": challenge.ExpiresAt.Format(configs.DateLayout)},
+			Secrets: []string{code},
+		})
+
+		attempt.Succeeded = true
+		recordKeyRevealAttempt(ctx, c, attempt)
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			ExpiresAt string `json:"expires_at" bson:"expires_at"`
+		}{
+			ExpiresAt: challenge.ExpiresAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusCreated, responses.KeyResponse{Status: http.StatusCreated, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Reveal Key
+* This enables key owners and Admins (user_id) to reveal a key (key_id),
+* given the one-time code (code) from CreateKeyRevealChallenge.
+*
+* Should regenerate be true, the key is instead regenerated (and
+* enabled) and the new key is revealed.
+*
+* Each code can only be used once, and allows 5 guesses.
+**************************************************************************/
+func RevealKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+		var challenge models.KeyRevealChallenge
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get code
+		code, exists := c.GetQuery("code")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'code' field"})
+			return
+		}
+
+		// Get regenerate (optional)
+		regenerate := false
+		if regenerateStr, exists := c.GetQuery("regenerate"); exists {
+			regenerate, err = strconv.ParseBool(regenerateStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid regenerate: Must be true or false"})
+				return
+			}
+		}
+
+		// Check rate limit
+		rateLimited, err := keyRevealRateLimited(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if rateLimited {
+			c.JSON(http.StatusTooManyRequests, responses.KeyResponse{Status: http.StatusTooManyRequests, Message: "error", Data: "Too many attempts: Try again later"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		keyFilter := bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
+		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		attempt := models.KeyRevealAttempt{KeyID: keyID, UserID: userID, Flow: "Reveal"}
+
+		// Check user permissions
+		if key.OwnerID != userID && user.Type != "Admin" {
+			attempt.Reason = "Not permitted"
+			recordKeyRevealAttempt(ctx, c, attempt)
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to reveal this key"})
+			return
+		}
+
+		// Count guess against the active challenge
+		// @INFO: Counting within the lookup ensures concurrent guesses are each counted
+		now := time.Now().UTC()
+		challengeFilter := bson.D{{Key: "key_id", Value: keyID}, {Key: "user_id", Value: userID}, {Key: "used_at", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}}, {Key: "attempts", Value: bson.D{{Key: "$lt", Value: maxKeyRevealCodeAttempts}}}}
+		err = keyRevealChallengeCollection.FindOneAndUpdate(ctx, challengeFilter, bson.D{{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}}}).Decode(&challenge)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				attempt.Reason = "No active code"
+				recordKeyRevealAttempt(ctx, c, attempt)
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "No active code: Request a new code to reveal this key"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify code
+		if subtle.ConstantTimeCompare([]byte(challenge.CodeHash), []byte(hashKeyRevealSecret(code))) != 1 {
+			attempt.Reason = "Invalid code"
+			recordKeyRevealAttempt(ctx, c, attempt)
+			c.JSON(http.StatusUnauthorized, responses.KeyResponse{Status: http.StatusUnauthorized, Message: "error", Data: "Invalid code: Code does not match"})
+			return
+		}
+
+		// Use challenge
+		result, err := keyRevealChallengeCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: challenge.ID}, {Key: "used_at", Value: bson.D{{Key: "$exists", Value: false}}}}, bson.D{{Key: "$set", Value: bson.D{{Key: "used_at", Value: now}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			attempt.Reason = "Code already used"
+			recordKeyRevealAttempt(ctx, c, attempt)
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid code: Code has already been used"})
+			return
+		}
+
+		// Regenerate key
+		action := "RevealKey"
+		wasActive := key.IsActive
+		if regenerate {
+			action = "RegenerateAndRevealKey"
+			update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "key", Value: configs.GenerateKey()}, {Key: "is_active", Value: activeKeySuspension(key) == -1}}}} // Enable keys on regeneration, unless suspended
+			err = keyCollection.FindOneAndUpdate(ctx, keyFilter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		// Record attempt and audit event
+		// @INFO: The key itself is never recorded
+		attempt.Succeeded = true
+		recordKeyRevealAttempt(ctx, c, attempt)
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: action, TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"is_active": wasActive}, After: bson.M{"is_active": key.IsActive}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Key       string `json:"key" bson:"key"`
+			UpdatedAt string `json:"updated_at" bson:"updated_at"`
+			IsActive  bool   `json:"is_active" bson:"is_active"`
+		}{
+			Key:       key.Key,
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+			IsActive:  key.IsActive,
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
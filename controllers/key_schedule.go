@@ -0,0 +1,570 @@
+/**************************************************************************
+* Key schedule endpoint logic.
+*
+* Leads and Admins can schedule windows during which a key is disabled
+* (e.g. for exams, maintenance, or semester breaks), rather than calling
+* DisableKey and EnableKey at the right time. Windows are either one-off,
+* or recur Daily or Weekly in the key's quota time zone, optionally until
+* a given time.
+*
+* Schedules are applied by the ApplyKeySchedules job, which records each
+* transition on the schedule and in the audit log. Keys which were
+* already disabled as a window began, or were disabled during it, are
+* left disabled as it ends.
+*
+* The usual permission rules apply, as in controllers/key.go:
+* Admins can schedule any key. Leads can only schedule advanced keys for
+* services they are leads for.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Days between the windows of each recurrence
+var keyScheduleRecurrenceDays map[string]int = map[string]int{"Daily": 1, "Weekly": 7}
+
+// Maximum number of pending schedules per key, and transitions kept per schedule
+const maxKeySchedules = 10
+const maxKeyScheduleTransitions = 20
+
+/**************************************************************************
+* Advance Key Schedule
+* This moves a schedule on to its next window which has not yet ended,
+* or completes it should it not recur or its recurrence have ended.
+*
+* Windows recur at the same local time in loc, across daylight saving
+* changes.
+**************************************************************************/
+func advanceKeySchedule(schedule *models.KeySchedule, loc *time.Location, now time.Time) {
+	days, recurring := keyScheduleRecurrenceDays[schedule.Recurrence]
+	if !recurring {
+		schedule.Status = "Completed"
+		return
+	}
+
+	for !schedule.EnableAt.After(now) {
+		schedule.DisableAt = schedule.DisableAt.In(loc).AddDate(0, 0, days).UTC()
+		schedule.EnableAt = schedule.EnableAt.In(loc).AddDate(0, 0, days).UTC()
+	}
+
+	if !schedule.Until.IsZero() && schedule.DisableAt.After(schedule.Until) {
+		schedule.Status = "Completed"
+		return
+	}
+	schedule.Status = "Scheduled"
+}
+
+/**************************************************************************
+* Key Schedule Re-enables
+* This checks whether ending the given schedule's window re-enables the
+* key, being the case should the key have been active as the window
+* began, and neither another window nor a suspension currently be
+* disabling it.
+**************************************************************************/
+func keyScheduleReenables(key models.Key, schedule models.KeySchedule) bool {
+	if !schedule.KeyWasActive || activeKeySuspension(key) != -1 {
+		return false
+	}
+	for _, other := range key.Schedules {
+		if other.ID != schedule.ID && other.Status == "Disabled" {
+			return false
+		}
+	}
+	return true
+}
+
+/**************************************************************************
+* Key Disabled Temporarily
+* This checks whether the key is disabled only by its active suspension
+* or schedule windows, being the case should any of them have found the
+* key active, so that it is re-enabled once they end.
+**************************************************************************/
+func keyDisabledTemporarily(key models.Key) bool {
+	if index := activeKeySuspension(key); index != -1 && key.Suspensions[index].KeyWasActive {
+		return true
+	}
+	for _, schedule := range key.Schedules {
+		if schedule.Status == "Disabled" && schedule.KeyWasActive {
+			return true
+		}
+	}
+	return false
+}
+
+/**************************************************************************
+* Keep Scheduled Key Disabled
+* This returns the fields to set, alongside disabling a key, which leave
+* the key disabled once each of its current windows ends, along with the
+* ids of those windows. The update must match the key's updated_at, so
+* the windows are still at their indexes.
+**************************************************************************/
+func keepScheduledKeyDisabled(key models.Key) ([]bson.E, []primitive.ObjectID) {
+	var fields []bson.E
+	var scheduleIDs []primitive.ObjectID
+	for i, schedule := range key.Schedules {
+		if schedule.Status == "Disabled" {
+			fields = append(fields, bson.E{Key: "schedules." + strconv.Itoa(i) + ".key_was_active", Value: false})
+			scheduleIDs = append(scheduleIDs, schedule.ID)
+		}
+	}
+	return fields, scheduleIDs
+}
+
+/**************************************************************************
+* Update Key Schedule
+* This saves the schedule at the given index of the key, along with the
+* key's new is_active, should neither have changed since the key was
+* found. A transition is appended to the schedule and recorded in the
+* audit log as the given action on behalf of the given user.
+*
+* Returns whether the key was updated.
+**************************************************************************/
+func updateKeySchedule(ctx context.Context, c *gin.Context, action string, actorUserID primitive.ObjectID, key *models.Key, index int, schedule models.KeySchedule, isActive bool, transition models.KeyScheduleTransition) (bool, error) {
+	previous := key.Schedules[index]
+	now := time.Now().UTC()
+
+	schedule.Transitions = append(schedule.Transitions, transition)
+	if len(schedule.Transitions) > maxKeyScheduleTransitions {
+		schedule.Transitions = schedule.Transitions[len(schedule.Transitions)-maxKeyScheduleTransitions:]
+	}
+
+	// @INFO: Matching the updated_at and schedule status leaves keys changed since they were found
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: key.UpdatedAt}, {Key: "schedules", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "_id", Value: previous.ID}, {Key: "status", Value: previous.Status}}}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "is_active", Value: isActive}, {Key: "schedules.$", Value: schedule}}}}
+
+	result, err := keyCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	// Record audit event
+	recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: actorUserID, Action: action, TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"is_active": key.IsActive, "schedule_id": previous.ID, "status": previous.Status}, After: bson.M{"is_active": isActive, "schedule_id": schedule.ID, "status": schedule.Status, "transition": transition.Action}})
+
+	key.Schedules[index] = schedule
+	key.IsActive = isActive
+	key.UpdatedAt = now
+	return true, nil
+}
+
+/**************************************************************************
+* Apply Key Schedules Operation
+* This applies every schedule whose window has begun or ended: disabling
+* the key as its window begins, and re-enabling it as its window ends.
+* Windows which ended before they could be applied are skipped.
+*
+* This is run periodically by the job scheduler (see jobs/scheduler.go).
+**************************************************************************/
+func ApplyKeySchedulesOperation(ctx context.Context) error {
+	var keys []models.Key
+
+	now := time.Now().UTC()
+
+	dueSchedule := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "status", Value: "Scheduled"}, {Key: "disable_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+		bson.D{{Key: "status", Value: "Disabled"}, {Key: "enable_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+	}}}
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "schedules", Value: bson.D{{Key: "$elemMatch", Value: dueSchedule}}}}
+
+	cursor, err := keyCollection.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to find scheduled keys: %w", err)
+	}
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return fmt.Errorf("unable to find scheduled keys: %w", err)
+	}
+
+	// @INFO: Keys which fail are retried on the next run
+	failed := 0
+	for _, key := range keys {
+		loc, _, err := findKeyQuotaSchedule(ctx, key)
+		if err != nil {
+			log.Printf("Unable to apply schedules of key %s: %v", key.ID.Hex(), err)
+			failed++
+			continue
+		}
+
+		for i, schedule := range key.Schedules {
+			transition := models.KeyScheduleTransition{AppliedAt: now}
+			isActive := key.IsActive
+
+			if schedule.Status == "Scheduled" && !schedule.EnableAt.After(now) {
+				// The whole window passed (e.g. while no replica was running)
+				transition.Action = "Skip"
+				transition.ScheduledFor = schedule.DisableAt
+				transition.Message = "The window ended before it could be applied"
+				advanceKeySchedule(&schedule, loc, now)
+			} else if schedule.Status == "Scheduled" && !schedule.DisableAt.After(now) {
+				transition.Action = "Disable"
+				transition.ScheduledFor = schedule.DisableAt
+				// @INFO: Keys disabled by another window or a suspension are re-enabled should they have been active before it
+				schedule.KeyWasActive = key.IsActive || keyDisabledTemporarily(key)
+				schedule.Status = "Disabled"
+				isActive = false
+			} else if schedule.Status == "Disabled" && !schedule.EnableAt.After(now) {
+				transition.Action = "Enable"
+				transition.ScheduledFor = schedule.EnableAt
+				isActive = key.IsActive || keyScheduleReenables(key, schedule)
+				if !isActive {
+					transition.Message = "The key was left disabled, as it was already disabled or is disabled by another window"
+				}
+				advanceKeySchedule(&schedule, loc, now)
+			} else {
+				continue
+			}
+
+			_, err = updateKeySchedule(ctx, nil, "ApplyKeySchedule", schedule.CreatedBy, &key, i, schedule, isActive, transition)
+			if err != nil {
+				log.Printf("Unable to apply schedule %s of key %s: %v", schedule.ID.Hex(), key.ID.Hex(), err)
+				failed++
+				break
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to apply schedules of %d keys", failed)
+	}
+	return nil
+}
+
+/**************************************************************************
+* Parse Key Schedule
+* This parses and validates the window (disable_at, enable_at) and
+* optional recurrence (recurrence, until) of a new schedule.
+**************************************************************************/
+func parseKeySchedule(c *gin.Context, now time.Time) (models.KeySchedule, error) {
+	var schedule models.KeySchedule
+	var err error
+
+	disableAtStr, exists := c.GetQuery("disable_at")
+	if !exists {
+		return schedule, errors.New("Request must include the 'disable_at' field")
+	}
+	schedule.DisableAt, err = time.Parse(configs.DateLayout, disableAtStr)
+	if err != nil {
+		return schedule, errors.New("Invalid disable_at: " + err.Error())
+	}
+	if !schedule.DisableAt.After(now) {
+		return schedule, errors.New("Invalid disable_at: Must be in the future")
+	}
+
+	enableAtStr, exists := c.GetQuery("enable_at")
+	if !exists {
+		return schedule, errors.New("Request must include the 'enable_at' field")
+	}
+	schedule.EnableAt, err = time.Parse(configs.DateLayout, enableAtStr)
+	if err != nil {
+		return schedule, errors.New("Invalid enable_at: " + err.Error())
+	}
+	if !schedule.EnableAt.After(schedule.DisableAt) {
+		return schedule, errors.New("Invalid enable_at: Must be after disable_at")
+	}
+
+	schedule.Recurrence = c.Query("recurrence")
+	if schedule.Recurrence != "" {
+		days, valid := keyScheduleRecurrenceDays[schedule.Recurrence]
+		if !valid {
+			return schedule, errors.New("Invalid recurrence. Must be 'Daily' or 'Weekly'")
+		}
+		if schedule.EnableAt.Sub(schedule.DisableAt) >= time.Duration(days)*24*time.Hour {
+			return schedule, errors.New("Invalid enable_at: A " + schedule.Recurrence + " window must end before the next begins")
+		}
+	}
+
+	untilStr, exists := c.GetQuery("until")
+	if exists {
+		if schedule.Recurrence == "" {
+			return schedule, errors.New("Invalid until: Only recurring schedules can be given an until")
+		}
+		schedule.Until, err = time.Parse(configs.DateLayout, untilStr)
+		if err != nil {
+			return schedule, errors.New("Invalid until: " + err.Error())
+		}
+		if schedule.Until.Before(schedule.DisableAt) {
+			return schedule, errors.New("Invalid until: Must not be before disable_at")
+		}
+	}
+
+	schedule.DisableAt = schedule.DisableAt.UTC()
+	schedule.EnableAt = schedule.EnableAt.UTC()
+	schedule.Until = schedule.Until.UTC()
+	return schedule, nil
+}
+
+/**************************************************************************
+* Schedule Key Window
+* This enables Leads and Admins (user_id) to schedule a window during
+* which a key (key_id) is disabled, from disable_at until enable_at.
+*
+* The window can optionally recur (recurrence, 'Daily' or 'Weekly'),
+* until an optional time (until). A reason (reason) can optionally be
+* recorded with the schedule.
+**************************************************************************/
+func ScheduleKeyWindow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get schedule
+		now := time.Now().UTC()
+		schedule, err := parseKeySchedule(c, now)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+		schedule.Reason = c.Query("reason")
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		keyFilter := bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
+		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		// @INFO: Assumes key.ServiceID is valid
+		if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to schedule this key"})
+			return
+		}
+
+		// Limit pending schedules
+		pending := 0
+		for _, existing := range key.Schedules {
+			if existing.Status == "Scheduled" || existing.Status == "Disabled" {
+				pending++
+			}
+		}
+		if pending >= maxKeySchedules {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "A key can have at most " + strconv.Itoa(maxKeySchedules) + " pending schedules"})
+			return
+		}
+
+		// Add schedule
+		schedule.ID = primitive.NewObjectID()
+		schedule.Status = "Scheduled"
+		schedule.CreatedBy = userID
+		schedule.CreatedAt = now
+		key.UpdatedAt = now
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}}}, {Key: "$push", Value: bson.D{{Key: "schedules", Value: schedule}}}}
+		_, err = keyCollection.UpdateOne(ctx, keyFilter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "ScheduleKeyWindow", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, After: bson.M{"schedule_id": schedule.ID, "disable_at": schedule.DisableAt, "enable_at": schedule.EnableAt, "recurrence": schedule.Recurrence, "until": schedule.Until, "reason": schedule.Reason}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Schedule  models.KeySchedule `json:"schedule" bson:"schedule"`
+			UpdatedAt string             `json:"updated_at" bson:"updated_at"`
+		}{
+			Schedule:  schedule,
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusCreated, responses.KeyResponse{Status: http.StatusCreated, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Cancel Key Schedule
+* This enables Leads and Admins (user_id) to cancel a schedule
+* (schedule_id) of a key (key_id) before it completes.
+*
+* Should the key currently be disabled by the schedule, its window ends
+* immediately and the key is re-enabled, as it would have been.
+**************************************************************************/
+func CancelKeySchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get scheduleID
+		scheduleIDQuery, exists := c.GetQuery("schedule_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'schedule_id' field"})
+			return
+		}
+		scheduleID, err := primitive.ObjectIDFromHex(scheduleIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		// @INFO: Assumes key.ServiceID is valid
+		if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to schedule this key"})
+			return
+		}
+
+		// Get schedule
+		index := slices.IndexFunc(key.Schedules, func(schedule models.KeySchedule) bool { return schedule.ID == scheduleID })
+		if index == -1 {
+			c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid schedule_id: Schedule does not exist"})
+			return
+		}
+		schedule := key.Schedules[index]
+		if schedule.Status != "Scheduled" && schedule.Status != "Disabled" {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Schedule has already completed or been cancelled"})
+			return
+		}
+
+		// Cancel schedule, ending its current window
+		now := time.Now().UTC()
+		transition := models.KeyScheduleTransition{Action: "Cancel", ScheduledFor: now, AppliedAt: now}
+		isActive := key.IsActive
+		if schedule.Status == "Disabled" {
+			isActive = key.IsActive || keyScheduleReenables(key, schedule)
+		}
+		schedule.Status = "Cancelled"
+
+		updated, err := updateKeySchedule(ctx, c, "CancelKeySchedule", userID, &key, index, schedule, isActive, transition)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if !updated {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Schedule  models.KeySchedule `json:"schedule" bson:"schedule"`
+			IsActive  bool               `json:"is_active" bson:"is_active"`
+			UpdatedAt string             `json:"updated_at" bson:"updated_at"`
+		}{
+			Schedule:  key.Schedules[index],
+			IsActive:  key.IsActive,
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
@@ -0,0 +1,492 @@
+/**************************************************************************
+* Key suspension endpoint logic.
+*
+* Leads and Admins can suspend a key, rather than only disabling it, so
+* the key's owner knows why it stopped working. A suspension records a
+* reason category and a message for the owner, both of which Allowed
+* returns while the key is suspended, and can optionally be lifted
+* automatically at a given time (lift_at) by the LiftKeySuspensions job.
+*
+* A suspended key cannot be re-enabled until its suspension is lifted,
+* and keys which were already disabled as they were suspended, or were
+* disabled while suspended, are left disabled as it is lifted. Keys
+* suspended during a schedule window are re-enabled should they have
+* been active as the window began. Every suspension is kept on the key,
+* so the portal can show a key's suspension history.
+*
+* The usual permission rules apply, as in controllers/key.go:
+* Admins can suspend any key. Leads can only suspend advanced keys for
+* services they are leads for.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/notifiers"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @TODO: Enum (?)
+var keySuspensionCategories = []string{"Compromised", "Abuse", "PolicyViolation", "Billing", "Other"}
+
+const maxKeySuspensionMessageLength = 1024
+
+/**************************************************************************
+* Active Key Suspension
+* This returns the index of the key's active suspension, or -1 should
+* the key not be suspended.
+**************************************************************************/
+func activeKeySuspension(key models.Key) int {
+	return slices.IndexFunc(key.Suspensions, func(suspension models.KeySuspension) bool { return suspension.Status == "Active" })
+}
+
+/**************************************************************************
+* Keep Suspended Key Disabled
+* This returns the field to set, alongside disabling a suspended key,
+* which leaves the key disabled once its active suspension (at index) is
+* lifted. The update must match the key's updated_at, so the suspension
+* is still at index.
+**************************************************************************/
+func keepSuspendedKeyDisabled(index int) bson.E {
+	return bson.E{Key: "suspensions." + strconv.Itoa(index) + ".key_was_active", Value: false}
+}
+
+/**************************************************************************
+* Key Suspension Re-enables
+* This checks whether lifting the given suspension re-enables the key,
+* being the case should the key have been active as it was suspended and
+* no schedule window currently be disabling it.
+**************************************************************************/
+func keySuspensionReenables(key models.Key, suspension models.KeySuspension) bool {
+	if !suspension.KeyWasActive {
+		return false
+	}
+	for _, schedule := range key.Schedules {
+		if schedule.Status == "Disabled" {
+			return false
+		}
+	}
+	return true
+}
+
+/**************************************************************************
+* Lift Key Suspension
+* This lifts the suspension at the given index of the key on behalf of
+* the given user (no one, should it be lifted automatically), should
+* neither have changed since the key was found. The key is re-enabled
+* should it have been active as it was suspended, and its owner notified.
+*
+* Returns whether the key was updated.
+**************************************************************************/
+func liftKeySuspension(ctx context.Context, c *gin.Context, actorUserID primitive.ObjectID, key *models.Key, index int) (bool, error) {
+	previous := key.Suspensions[index]
+	now := time.Now().UTC()
+
+	suspension := previous
+	suspension.Status = "Lifted"
+	suspension.LiftedBy = actorUserID
+	suspension.LiftedAt = now
+	isActive := key.IsActive || keySuspensionReenables(*key, previous)
+
+	// @INFO: Matching the updated_at and suspension status leaves keys changed since they were found
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: key.UpdatedAt}, {Key: "suspensions", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "_id", Value: previous.ID}, {Key: "status", Value: "Active"}}}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: now}, {Key: "is_active", Value: isActive}, {Key: "suspensions.$", Value: suspension}}}}
+
+	result, err := keyCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	// Record audit event
+	recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: actorUserID, Action: "LiftKeySuspension", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"is_active": key.IsActive, "suspension_id": previous.ID, "status": previous.Status}, After: bson.M{"is_active": isActive, "suspension_id": suspension.ID, "status": suspension.Status}})
+
+	notifyUser(key.OwnerID, notifiers.Notification{
+		Event:   "KeySuspensionLifted",
+		Subject: fmt.Sprintf("The suspension of your key '%s' has been lifted", key.Name),
+		Message: fmt.Sprintf("The suspension of your key '%s' has been lifted.", key.Name),
+		Data:    map[string]interface{}{"key_id": key.ID.Hex(), "suspension_id": suspension.ID.Hex(), "is_active": isActive},
+	})
+
+	key.Suspensions[index] = suspension
+	key.IsActive = isActive
+	key.UpdatedAt = now
+	return true, nil
+}
+
+/**************************************************************************
+* Lift Key Suspensions Operation
+* This lifts every active suspension whose lift_at has passed.
+*
+* This is run periodically by the job scheduler (see jobs/scheduler.go).
+**************************************************************************/
+func LiftKeySuspensionsOperation(ctx context.Context) error {
+	var keys []models.Key
+
+	now := time.Now().UTC()
+
+	dueSuspension := bson.D{{Key: "status", Value: "Active"}, {Key: "lift_at", Value: bson.D{{Key: "$lte", Value: now}}}}
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "suspensions", Value: bson.D{{Key: "$elemMatch", Value: dueSuspension}}}}
+
+	cursor, err := keyCollection.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to find suspended keys: %w", err)
+	}
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return fmt.Errorf("unable to find suspended keys: %w", err)
+	}
+
+	// @INFO: Keys which fail are retried on the next run
+	failed := 0
+	for _, key := range keys {
+		index := activeKeySuspension(key)
+		if index == -1 || key.Suspensions[index].LiftAt.IsZero() || key.Suspensions[index].LiftAt.After(now) {
+			continue
+		}
+
+		_, err = liftKeySuspension(ctx, nil, primitive.NilObjectID, &key, index)
+		if err != nil {
+			log.Printf("Unable to lift suspension of key %s: %v", key.ID.Hex(), err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("unable to lift suspensions of %d keys", failed)
+	}
+	return nil
+}
+
+/**************************************************************************
+* Suspend Key
+* This enables Leads and Admins (user_id) to suspend a key (key_id),
+* disabling it with a reason category (category) and a message for its
+* owner (message).
+*
+* The suspension is optionally lifted automatically at a given time
+* (lift_at), otherwise it lasts until lifted with LiftKeySuspension.
+**************************************************************************/
+func SuspendKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+		var liftAt time.Time
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err := time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get category
+		category, exists := c.GetQuery("category")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'category' field"})
+			return
+		}
+		if !slices.Contains(keySuspensionCategories, category) {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid category. Must be 'Compromised', 'Abuse', 'PolicyViolation', 'Billing', or 'Other'"})
+			return
+		}
+
+		// Get message
+		message := c.Query("message")
+		if message == "" {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'message' field"})
+			return
+		}
+		if len(message) > maxKeySuspensionMessageLength {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid message: Must be at most " + strconv.Itoa(maxKeySuspensionMessageLength) + " characters"})
+			return
+		}
+
+		// Get liftAt (optional)
+		now := time.Now().UTC()
+		liftAtQuery, exists := c.GetQuery("lift_at")
+		if exists {
+			liftAt, err = time.Parse(configs.DateLayout, liftAtQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid lift_at: " + err.Error()})
+				return
+			}
+			if !liftAt.After(now) {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid lift_at: Must be in the future"})
+				return
+			}
+			liftAt = liftAt.UTC()
+		}
+
+		// Get key
+		keyFilter := bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}
+		err = keyCollection.FindOne(ctx, keyFilter).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify key is not suspended
+		if activeKeySuspension(key) != -1 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Key is already suspended"})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		// @INFO: Assumes key.ServiceID is valid
+		if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to suspend this key"})
+			return
+		}
+
+		// Suspend key
+		before := bson.M{"is_active": key.IsActive}
+		suspension := models.KeySuspension{
+			ID:           primitive.NewObjectID(),
+			Category:     category,
+			Message:      message,
+			LiftAt:       liftAt,
+			Status:       "Active",
+			KeyWasActive: key.IsActive || keyDisabledTemporarily(key),
+			SuspendedBy:  userID,
+			SuspendedAt:  now,
+		}
+		key.IsActive = false
+		key.UpdatedAt = now
+
+		// @INFO: Matching the updated_at leaves keys changed since they were found
+		filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: updatedAt}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "is_active", Value: key.IsActive}}}, {Key: "$push", Value: bson.D{{Key: "suspensions", Value: suspension}}}}
+		result, err := keyCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SuspendKey", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: bson.M{"is_active": key.IsActive, "suspension_id": suspension.ID, "category": suspension.Category, "message": suspension.Message, "lift_at": suspension.LiftAt}})
+
+		notifyData := map[string]interface{}{"key_id": key.ID.Hex(), "suspension_id": suspension.ID.Hex(), "category": suspension.Category}
+		liftNote := " It remains suspended until a lead of its service lifts the suspension."
+		if !suspension.LiftAt.IsZero() {
+			notifyData["lift_at"] = suspension.LiftAt.Format(configs.DateLayout)
+			liftNote = " The suspension will be lifted at " + suspension.LiftAt.Format(configs.DateLayout) + "."
+		}
+		notifyUser(key.OwnerID, notifiers.Notification{
+			Event:   "KeySuspended",
+			Subject: fmt.Sprintf("Your key '%s' has been suspended", key.Name),
+			Message: fmt.Sprintf("Your key '%s' has been suspended (%s): %s%s", key.Name, suspension.Category, suspension.Message, liftNote),
+			Data:    notifyData,
+		})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Suspension models.KeySuspension `json:"suspension" bson:"suspension"`
+			UpdatedAt  string               `json:"updated_at" bson:"updated_at"`
+		}{
+			Suspension: suspension,
+			UpdatedAt:  key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Lift Key Suspension
+* This enables Leads and Admins (user_id) to lift the suspension of a key
+* (key_id) before its lift_at, should it have one.
+*
+* The key is re-enabled should it have been active as it was suspended.
+**************************************************************************/
+func LiftKeySuspension() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err := time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify key is suspended
+		index := activeKeySuspension(key)
+		if index == -1 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Key is not suspended"})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		// @INFO: Assumes key.ServiceID is valid
+		if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to lift the suspension of this key"})
+			return
+		}
+
+		// Lift suspension
+		updated, err := liftKeySuspension(ctx, c, userID, &key, index)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if !updated {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			Suspension models.KeySuspension `json:"suspension" bson:"suspension"`
+			IsActive   bool                 `json:"is_active" bson:"is_active"`
+			UpdatedAt  string               `json:"updated_at" bson:"updated_at"`
+		}{
+			Suspension: key.Suspensions[index],
+			IsActive:   key.IsActive,
+			UpdatedAt:  key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Suspend the key, as SuspendKey does
+func suspendKey(key *models.Key) {
+	key.Suspensions = append(key.Suspensions, models.KeySuspension{ID: primitive.NewObjectID(), Status: "Active", KeyWasActive: key.IsActive || keyDisabledTemporarily(*key)})
+	key.IsActive = false
+}
+
+// Begin the window of the schedule at index, as ApplyKeySchedulesOperation does
+func beginKeySchedule(key *models.Key, index int) {
+	key.Schedules[index].KeyWasActive = key.IsActive || keyDisabledTemporarily(*key)
+	key.Schedules[index].Status = "Disabled"
+	key.IsActive = false
+}
+
+func TestKeySuspendedDuringWindowReenablesOnceLifted(t *testing.T) {
+	key := models.Key{IsActive: true, Schedules: []models.KeySchedule{{ID: primitive.NewObjectID(), Status: "Scheduled"}}}
+	beginKeySchedule(&key, 0)
+	suspendKey(&key)
+
+	// The window ends first, leaving the key suspended
+	if keyScheduleReenables(key, key.Schedules[0]) {
+		t.Fatalf("Expected the end of the window not to re-enable a suspended key")
+	}
+	key.Schedules[0].Status = "Completed"
+
+	if !keySuspensionReenables(key, key.Suspensions[0]) {
+		t.Errorf("Expected lifting the suspension to re-enable a key which was active as the window began")
+	}
+}
+
+func TestKeySuspendedDuringWindowReenablesOnceWindowEnds(t *testing.T) {
+	key := models.Key{IsActive: true, Schedules: []models.KeySchedule{{ID: primitive.NewObjectID(), Status: "Scheduled"}}}
+	beginKeySchedule(&key, 0)
+	suspendKey(&key)
+
+	// The suspension is lifted first, leaving the window disabling the key
+	if keySuspensionReenables(key, key.Suspensions[0]) {
+		t.Fatalf("Expected lifting the suspension not to re-enable a key during a window")
+	}
+	key.Suspensions[0].Status = "Lifted"
+
+	if !keyScheduleReenables(key, key.Schedules[0]) {
+		t.Errorf("Expected the end of the window to re-enable a key which was active as it began")
+	}
+}
+
+func TestKeyDisabledDuringWindowStaysDisabled(t *testing.T) {
+	key := models.Key{IsActive: true, Schedules: []models.KeySchedule{{ID: primitive.NewObjectID(), Status: "Scheduled"}}}
+	beginKeySchedule(&key, 0)
+	suspendKey(&key)
+
+	// As DisableKey does
+	key.Suspensions[0].KeyWasActive = false
+	key.Schedules[0].KeyWasActive = false
+	if keyDisabledTemporarily(key) {
+		t.Fatalf("Expected a disabled key not to be disabled only temporarily")
+	}
+
+	key.Suspensions[0].Status = "Lifted"
+	if keyScheduleReenables(key, key.Schedules[0]) {
+		t.Errorf("Expected the end of the window not to re-enable a disabled key")
+	}
+}
@@ -0,0 +1,353 @@
+/**************************************************************************
+* Key listing endpoint logic.
+*
+* This lists the keys visible to a user, filtered, sorted, and split into
+* pages. Each page returns a cursor (next_cursor) which is passed back to
+* get the following page, so pages stay stable as keys are created.
+*
+* Results are scoped by the user's role:
+* Admins can list any key.
+* Leads can list their own keys and the advanced keys of services they
+* are leads for.
+* Developers can only list their own keys.
+*
+* Deleted keys are never listed, and the key itself is always hidden.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default and maximum number of keys returned per page by GetKeys
+const defaultKeyPageLimit = 50
+const maxKeyPageLimit = 200
+
+// Fields keys can be sorted by
+var keySortFields []string = []string{"created_at", "updated_at", "last_used", "name"}
+
+/**************************************************************************
+* Encode Key Cursor
+* This encodes the sort value and id of the last key of a page as an
+* opaque cursor, from which the next page continues.
+**************************************************************************/
+func encodeKeyCursor(sortValue interface{}, keyID primitive.ObjectID) (string, error) {
+	cursorBytes, err := bson.Marshal(bson.D{{Key: "value", Value: sortValue}, {Key: "_id", Value: keyID}})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursorBytes), nil
+}
+
+/**************************************************************************
+* Key Cursor Filter
+* This decodes a cursor into a filter matching the keys which follow it
+* in the given sort order. Keys sharing a sort value follow by id.
+**************************************************************************/
+func keyCursorFilter(cursor string, sortField string, sortOrder int) (bson.D, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	raw := bson.Raw(cursorBytes)
+	err = raw.Validate()
+	if err != nil {
+		return nil, err
+	}
+	keyID, ok := raw.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return nil, errors.New("Invalid cursor")
+	}
+	sortValue := raw.Lookup("value")
+
+	comparison := "$gt"
+	if sortOrder < 0 {
+		comparison = "$lt"
+	}
+
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: sortField, Value: bson.D{{Key: comparison, Value: sortValue}}}},
+		bson.D{{Key: sortField, Value: sortValue}, {Key: "_id", Value: bson.D{{Key: comparison, Value: keyID}}}},
+	}}}, nil
+}
+
+/**************************************************************************
+* Get Keys
+* This returns a page of the keys visible to the given user (user_id).
+*
+* Keys can optionally be filtered by:
+*  - owner_id, service_id, key_type ('Basic' or 'Advanced'), environment
+*    ('Live' or 'Test'), is_active
+*  - last_used_from and last_used_to, created_from and created_to
+*    (inclusive, formatted as configs.DateLayout)
+*  - name, a case-insensitive search of the key's name
+*  - label, given as name=value to match a label's value, or name to
+*    match any value. Can be given more than once to match every label.
+*
+* Keys are sorted by sort ('created_at', 'updated_at', 'last_used', or
+* 'name', Default: created_at) in order ('asc' or 'desc', Default: desc).
+*
+* At most limit (Default: 50, Max: 200) keys are returned. Should more
+* keys follow, next_cursor is returned, which is passed as cursor along
+* with the same filters and sort to get the next page.
+**************************************************************************/
+func GetKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var keys []models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Build key filter
+		// @INFO: Each condition is ANDed so the scope and cursor can each use $or
+		conditions := bson.A{bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}}}
+
+		// Get ownerID (optional)
+		ownerIDQuery, exists := c.GetQuery("owner_id")
+		if exists {
+			ownerID, err := primitive.ObjectIDFromHex(ownerIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			conditions = append(conditions, bson.D{{Key: "owner_id", Value: ownerID}})
+		}
+
+		// Get serviceID (optional)
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if exists {
+			serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+			conditions = append(conditions, bson.D{{Key: "service_id", Value: serviceID}})
+		}
+
+		// Get keyType (optional)
+		keyType, exists := c.GetQuery("key_type")
+		if exists {
+			if keyType != "Basic" && keyType != "Advanced" {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid key_type. Must be 'Basic' or 'Advanced'"})
+				return
+			}
+			conditions = append(conditions, bson.D{{Key: "key_type", Value: keyType}})
+		}
+
+		// Get environment (optional)
+		// @INFO: Keys without an environment are Live
+		environment, exists := c.GetQuery("environment")
+		if exists {
+			switch environment {
+			case "Live":
+				conditions = append(conditions, bson.D{{Key: "environment", Value: bson.D{{Key: "$in", Value: bson.A{"Live", nil}}}}})
+			case "Test":
+				conditions = append(conditions, bson.D{{Key: "environment", Value: "Test"}})
+			default:
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid environment. Must be 'Live' or 'Test'"})
+				return
+			}
+		}
+
+		// Get isActive (optional)
+		isActiveStr, exists := c.GetQuery("is_active")
+		if exists {
+			isActive, err := strconv.ParseBool(isActiveStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid is_active: Must be 'true' or 'false'"})
+				return
+			}
+			conditions = append(conditions, bson.D{{Key: "is_active", Value: isActive}})
+		}
+
+		// Get last used and created ranges (optional)
+		for _, dateRange := range []struct{ field, from, to string }{{"last_used", "last_used_from", "last_used_to"}, {"created_at", "created_from", "created_to"}} {
+			for _, bound := range []struct{ param, operator string }{{dateRange.from, "$gte"}, {dateRange.to, "$lte"}} {
+				boundStr, exists := c.GetQuery(bound.param)
+				if !exists {
+					continue
+				}
+				boundTime, err := time.Parse(configs.DateLayout, boundStr)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid " + bound.param + ": " + err.Error()})
+					return
+				}
+				conditions = append(conditions, bson.D{{Key: dateRange.field, Value: bson.D{{Key: bound.operator, Value: boundTime}}}})
+			}
+		}
+
+		// Get name search (optional)
+		name, exists := c.GetQuery("name")
+		if exists && name != "" {
+			conditions = append(conditions, bson.D{{Key: "name", Value: primitive.Regex{Pattern: regexp.QuoteMeta(name), Options: "i"}}})
+		}
+
+		// Get label filters (optional)
+		labelConditions, err := parseKeyLabelFilters(c.QueryArray("label"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+		for _, labelCondition := range labelConditions {
+			conditions = append(conditions, labelCondition)
+		}
+
+		// Get sort (optional)
+		sortField := c.DefaultQuery("sort", "created_at")
+		if !slices.Contains(keySortFields, sortField) {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid sort. Must be 'created_at', 'updated_at', 'last_used', or 'name'"})
+			return
+		}
+
+		// Get order (optional)
+		sortOrder := -1
+		order := c.DefaultQuery("order", "desc")
+		if order == "asc" {
+			sortOrder = 1
+		} else if order != "desc" {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid order. Must be 'asc' or 'desc'"})
+			return
+		}
+
+		// Get limit (optional)
+		limit := defaultKeyPageLimit
+		limitStr, exists := c.GetQuery("limit")
+		if exists {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > maxKeyPageLimit {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid limit: Must be between 1 and " + strconv.Itoa(maxKeyPageLimit)})
+				return
+			}
+		}
+
+		// Get cursor (optional)
+		cursorStr, exists := c.GetQuery("cursor")
+		if exists && cursorStr != "" {
+			cursorFilter, err := keyCursorFilter(cursorStr, sortField, sortOrder)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid cursor"})
+				return
+			}
+			conditions = append(conditions, cursorFilter)
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Scope keys by the user's role
+		if user.Type == "Lead" {
+			services := user.Services
+			if services == nil {
+				services = []primitive.ObjectID{}
+			}
+			conditions = append(conditions, bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "owner_id", Value: user.ID}},
+				bson.D{{Key: "key_type", Value: "Advanced"}, {Key: "service_id", Value: bson.D{{Key: "$in", Value: services}}}},
+			}}})
+		} else if user.Type != "Admin" {
+			conditions = append(conditions, bson.D{{Key: "owner_id", Value: user.ID}})
+		}
+
+		// Get keys, along with one more to tell whether another page follows
+		// @INFO: The key itself is never returned
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: sortField, Value: sortOrder}, {Key: "_id", Value: sortOrder}}).
+			SetLimit(int64(limit + 1)).
+			SetProjection(bson.D{{Key: "key", Value: 0}})
+		cursor, err := keyCollection.Find(ctx, bson.D{{Key: "$and", Value: conditions}}, findOptions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		keys = []models.Key{}
+		err = cursor.All(ctx, &keys)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Build the cursor of the next page
+		nextCursor := ""
+		if len(keys) > limit {
+			keys = keys[:limit]
+			lastKey := keys[limit-1]
+
+			var sortValue interface{}
+			switch sortField {
+			case "created_at":
+				sortValue = lastKey.CreatedAt
+			case "updated_at":
+				sortValue = lastKey.UpdatedAt
+			case "last_used":
+				sortValue = lastKey.LastUsed
+			case "name":
+				sortValue = lastKey.Name
+			}
+
+			nextCursor, err = encodeKeyCursor(sortValue, lastKey.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		// Hide the actual keys
+		keysData := []responses.KeyData{}
+		for _, key := range keys {
+			keysData = append(keysData, responses.NewKeyData(key, true))
+		}
+
+		res := struct {
+			Keys       []responses.KeyData `json:"keys"`
+			NextCursor string              `json:"next_cursor,omitempty"`
+		}{
+			Keys:       keysData,
+			NextCursor: nextCursor,
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
@@ -0,0 +1,134 @@
+/**************************************************************************
+* Notification logic.
+*
+* Notifications are delivered through the notifier configured by the
+* environment (see notifiers/notifier.go). Delivery happens in the
+* background so that notifying users never delays a response.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/notifiers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/exp/slices"
+)
+
+var notifier notifiers.Notifier = notifiers.NewFromEnv()
+
+// Percentages of a key's quota consumed at which the owner is notified
+var quotaNotifyThresholds []int = configs.GetEnvQuotaNotifyThresholds()
+
+/**************************************************************************
+* Notify User
+* This looks up the given user and delivers the notification to them
+* in the background. Errors are logged.
+**************************************************************************/
+func notifyUser(userID primitive.ObjectID, notification notifiers.Notification) {
+	go func() {
+		var user models.User
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			log.Printf("Unable to notify user %s of '%s': %v", userID.Hex(), notification.Event, err)
+			return
+		}
+
+		notification.Recipient = user
+		err = notifier.Notify(ctx, notification)
+		if err != nil {
+			log.Printf("Unable to notify user %s of '%s': %v", userID.Hex(), notification.Event, err)
+		}
+	}()
+}
+
+/**************************************************************************
+* Notify Quota Thresholds
+* This notifies the key's owner of each quota threshold the key has
+* reached, given the key's usage remaining after consumption.
+* Usage carried over from the previous period counts towards the quota.
+*
+* Each threshold is only notified once per quota period, as the
+* notified thresholds are cleared whenever the key's quota is refreshed.
+**************************************************************************/
+func notifyQuotaThresholds(ctx context.Context, key models.Key) {
+	allowance := key.Quota + key.RolloverCarried
+	if allowance <= 0 {
+		return
+	}
+
+	consumed := allowance - key.UsageRemaining
+
+	for _, threshold := range quotaNotifyThresholds {
+		// Threshold not reached, or already notified
+		if consumed*100 < threshold*allowance || slices.Contains(key.NotifiedThresholds, threshold) {
+			continue
+		}
+
+		// Claim the threshold so concurrent requests only notify once
+		filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "notified_thresholds", Value: bson.D{{Key: "$ne", Value: threshold}}}}
+		update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "notified_thresholds", Value: threshold}}}}
+		result, err := keyCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Printf("Unable to record quota threshold %d%% for key %s: %v", threshold, key.ID.Hex(), err)
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		notifyUser(key.OwnerID, notifiers.Notification{
+			Event:   "QuotaThreshold",
+			Subject: fmt.Sprintf("Your key '%s' has used %d%% of its quota", key.Name, threshold),
+			Message: fmt.Sprintf("Your key '%s' has used %d of its %d requests this period. Its quota resets at %s.", key.Name, consumed, allowance, key.QuotaTimestamp.Format(configs.DateLayout)),
+			Data:    map[string]interface{}{"key_id": key.ID.Hex(), "threshold": threshold, "quota": key.Quota, "rollover_carried": key.RolloverCarried, "usage_remaining": key.UsageRemaining, "quota_timestamp": key.QuotaTimestamp.Format(configs.DateLayout)},
+		})
+	}
+}
+
+/**************************************************************************
+* Notify Credit Alert Balances
+* This notifies the owner of a 'Credit' quota key of each of the key's
+* credit alert balances its credit balance has fallen to or below.
+*
+* Each alert balance is only notified once, as the notified balances
+* are only cleared once the key is topped up above them.
+**************************************************************************/
+func notifyCreditAlertBalances(ctx context.Context, key models.Key) {
+	for _, alertBalance := range key.CreditAlertBalances {
+		// Alert balance not reached, or already notified
+		if key.CreditBalance > alertBalance || slices.Contains(key.NotifiedCreditAlerts, alertBalance) {
+			continue
+		}
+
+		// Claim the alert balance so concurrent requests only notify once
+		filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "notified_credit_alerts", Value: bson.D{{Key: "$ne", Value: alertBalance}}}}
+		update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "notified_credit_alerts", Value: alertBalance}}}}
+		result, err := keyCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Printf("Unable to record credit alert balance %d for key %s: %v", alertBalance, key.ID.Hex(), err)
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		notifyUser(key.OwnerID, notifiers.Notification{
+			Event:   "CreditLowBalance",
+			Subject: fmt.Sprintf("Your key '%s' has %d credits remaining", key.Name, key.CreditBalance),
+			Message: fmt.Sprintf("Your key '%s' has %d prepaid credits remaining. Requests will be denied once its credits run out until it is topped up.", key.Name, key.CreditBalance),
+			Data:    map[string]interface{}{"key_id": key.ID.Hex(), "alert_balance": alertBalance, "credit_balance": key.CreditBalance},
+		})
+	}
+}
@@ -0,0 +1,761 @@
+/**************************************************************************
+* Plan endpoint logic.
+*
+* Plans are named quota tiers (e.g. Free, Student Project, Partner,
+* Internal) stored in the 'plans' collection. Each plan defines a quota
+* per quota period, calendar-aligned quota windows, a rate limit per
+* minute, and an overage of requests allowed beyond the quota.
+*
+* Keys subscribe to a plan, taking on its quota, windows, rate limit
+* (as a 'Minute' quota window), and overage. Editing a plan updates every
+* subscribed key, except those whose quota has been overridden through
+* SetKeyQuota.
+*
+* New keys subscribe to the default plan of their key type, should one
+* be configured (see configs/env.go).
+*
+* Only Admins can create and edit plans.
+*
+* Reponses are built using responses/plan_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var planCollection *mongo.Collection = configs.GetCollection(configs.DB, "plans")
+
+// Returned from a transaction to abort it should a plan have been updated since it was found
+var errPlanOutOfDate = errors.New("Out of date request: Plan has been updated")
+
+/**************************************************************************
+* Parse Plan Quota Windows
+* This parses a plan's quota windows given as comma separated unit:quota
+* pairs (e.g. "Hour:1000,Month:20000"), along with its rate limit, which
+* becomes the plan's 'Minute' window.
+**************************************************************************/
+func parsePlanQuotaWindows(quotaWindowsStr string, rateLimitPerMinute int) ([]models.PlanQuotaWindow, error) {
+	quotaWindows, err := parseQuotaWindows(quotaWindowsStr, time.Now(), time.UTC)
+	if err != nil {
+		return nil, err
+	}
+
+	planQuotaWindows := []models.PlanQuotaWindow{}
+	for _, quotaWindow := range quotaWindows {
+		if quotaWindow.Unit == "Minute" {
+			return nil, errors.New("Invalid quota_windows: Use 'rate_limit_per_minute' to limit requests per minute")
+		}
+		planQuotaWindows = append(planQuotaWindows, models.PlanQuotaWindow{Unit: quotaWindow.Unit, Quota: quotaWindow.Quota})
+	}
+
+	if rateLimitPerMinute > 0 {
+		planQuotaWindows = append(planQuotaWindows, models.PlanQuotaWindow{Unit: "Minute", Quota: rateLimitPerMinute})
+	}
+
+	return planQuotaWindows, nil
+}
+
+/**************************************************************************
+* Subscribe Key To Plan
+* This sets the key's plan, and its quota, quota windows, and overage
+* to those of the plan, restoring its usage remaining. The key's quota
+* windows reset at the start of the next calendar window in loc.
+**************************************************************************/
+func subscribeKeyToPlan(key *models.Key, plan models.Plan, now time.Time, loc *time.Location) {
+	key.PlanID = plan.ID
+	key.QuotaOverride = false
+	key.Quota = plan.Quota
+	key.QuotaNumDays = plan.QuotaNumDays
+	key.Overage = plan.Overage
+	key.UsageRemaining = key.Quota
+
+	key.QuotaWindows = []models.QuotaWindow{}
+	for _, planQuotaWindow := range plan.QuotaWindows {
+		key.QuotaWindows = append(key.QuotaWindows, models.QuotaWindow{Unit: planQuotaWindow.Unit, Quota: planQuotaWindow.Quota, UsageRemaining: planQuotaWindow.Quota, ResetAt: configs.NextQuotaWindowReset(planQuotaWindow.Unit, now, loc)})
+	}
+}
+
+/**************************************************************************
+* Keep Consumed Quota Windows Expression
+* This returns the aggregation expression of a subscribed key's quota
+* windows once its plan's quota windows change.
+*
+* Windows the key already has keep the usage they consumed and their
+* reset, while their quota changes. Windows new to the plan start with
+* their full quota and reset at now, so are reset at the key's own time
+* zone when next consumed.
+**************************************************************************/
+func keepConsumedQuotaWindowsExpression(planQuotaWindows []models.PlanQuotaWindow, now time.Time) bson.A {
+	keyQuotaWindows := bson.A{}
+	for _, planQuotaWindow := range planQuotaWindows {
+		matchingWindows := bson.D{{Key: "$filter", Value: bson.D{{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$quota_windows", bson.A{}}}}}, {Key: "as", Value: "window"}, {Key: "cond", Value: bson.D{{Key: "$eq", Value: bson.A{"$$window.unit", planQuotaWindow.Unit}}}}}}}
+
+		keptWindow := bson.D{{Key: "unit", Value: planQuotaWindow.Unit}, {Key: "quota", Value: planQuotaWindow.Quota}, {Key: "usage_remaining", Value: bson.D{{Key: "$add", Value: bson.A{"$$existing.usage_remaining", bson.D{{Key: "$subtract", Value: bson.A{planQuotaWindow.Quota, "$$existing.quota"}}}}}}}, {Key: "reset_at", Value: "$$existing.reset_at"}}
+		newWindow := bson.D{{Key: "unit", Value: planQuotaWindow.Unit}, {Key: "quota", Value: planQuotaWindow.Quota}, {Key: "usage_remaining", Value: planQuotaWindow.Quota}, {Key: "reset_at", Value: now}}
+
+		keyQuotaWindows = append(keyQuotaWindows, bson.D{{Key: "$let", Value: bson.D{
+			{Key: "vars", Value: bson.D{{Key: "existing", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{matchingWindows, 0}}}}}},
+			{Key: "in", Value: bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$type", Value: "$$existing"}}, "missing"}}}, newWindow, keptWindow}}}},
+		}}})
+	}
+	return keyQuotaWindows
+}
+
+/**************************************************************************
+* Reset Subscribed Keys Quota Timestamps
+* This restarts the quota period of every key subscribed to the plan
+* (filter), so it next resets quota_num_days after the most recent reset
+* in the key's own time zone and at its reset hour.
+**************************************************************************/
+func resetSubscribedKeysQuotaTimestamps(ctx context.Context, keysFilter bson.D, plan models.Plan, now time.Time) error {
+	var keys []models.Key
+
+	cursor, err := keyCollection.Find(ctx, keysFilter)
+	if err != nil {
+		return err
+	}
+	err = cursor.All(ctx, &keys)
+	if err != nil {
+		return err
+	}
+
+	writeModels := []mongo.WriteModel{}
+	for _, key := range keys {
+		loc, resetHour, err := findKeyQuotaSchedule(ctx, key)
+		if err != nil {
+			return err
+		}
+		quotaTimestamp := configs.NextQuotaTimestamp(now, plan.QuotaNumDays, loc, resetHour)
+		writeModels = append(writeModels, mongo.NewUpdateOneModel().SetFilter(bson.D{{Key: "_id", Value: key.ID}}).SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "quota_timestamp", Value: quotaTimestamp}}}}))
+	}
+	if len(writeModels) == 0 {
+		return nil
+	}
+
+	_, err = keyCollection.BulkWrite(ctx, writeModels)
+	return err
+}
+
+/**************************************************************************
+* Find Default Plan
+* This returns the plan new keys of the given key type subscribe to,
+* and whether one is configured.
+**************************************************************************/
+func findDefaultPlan(ctx context.Context, keyType string) (models.Plan, bool, error) {
+	var plan models.Plan
+
+	planName := configs.GetEnvDefaultPlan(keyType)
+	if planName == "" {
+		return plan, false, nil
+	}
+
+	err := planCollection.FindOne(ctx, bson.M{"plan_name": planName}).Decode(&plan)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return plan, false, errors.New("The default " + keyType + " plan '" + planName + "' does not exist")
+		}
+		return plan, false, err
+	}
+
+	return plan, true, nil
+}
+
+/**************************************************************************
+* Create Plan
+* This enables Admins (user_id) to create a plan (plan_name) with the
+* given quota per quota period (quota).
+*
+* The plan's quota_num_days (Default: 1), quota_windows, given as
+* unit:quota pairs (e.g. "Hour:1000,Month:20000"), rate_limit_per_minute,
+* and overage can optionally be given.
+**************************************************************************/
+func CreatePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var plan models.Plan
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get planName
+		plan.Name, exists = c.GetQuery("plan_name")
+		if !exists || plan.Name == "" {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'plan_name' field"})
+			return
+		}
+
+		// Get quota
+		quotaStr, exists := c.GetQuery("quota")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'quota' field"})
+			return
+		}
+		plan.Quota, err = strconv.Atoi(quotaStr)
+		if err != nil || plan.Quota < 0 {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota: Must not be negative"})
+			return
+		}
+
+		// Get quotaNumDays (optional)
+		plan.QuotaNumDays = configs.DefaultQuotaNumDays
+		quotaNumDaysStr, exists := c.GetQuery("quota_num_days")
+		if exists {
+			plan.QuotaNumDays, err = strconv.Atoi(quotaNumDaysStr)
+			if err != nil || plan.QuotaNumDays <= 0 {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota_num_days: Must be positive"})
+				return
+			}
+		}
+
+		// Get rateLimitPerMinute (optional)
+		rateLimitPerMinuteStr, exists := c.GetQuery("rate_limit_per_minute")
+		if exists {
+			plan.RateLimitPerMinute, err = strconv.Atoi(rateLimitPerMinuteStr)
+			if err != nil || plan.RateLimitPerMinute < 0 {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rate_limit_per_minute: Must not be negative"})
+				return
+			}
+		}
+
+		// Get overage (optional)
+		overageStr, exists := c.GetQuery("overage")
+		if exists {
+			plan.Overage, err = strconv.Atoi(overageStr)
+			if err != nil || plan.Overage < 0 {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid overage: Must not be negative"})
+				return
+			}
+		}
+
+		// Get quotaWindows (optional)
+		plan.QuotaWindows, err = parsePlanQuotaWindows(c.Query("quota_windows"), plan.RateLimitPerMinute)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PlanResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Verify plan name is unique
+		count, err := planCollection.CountDocuments(ctx, bson.M{"plan_name": plan.Name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid plan_name: A plan with the given name already exists"})
+			return
+		}
+
+		// Create plan
+		plan.ID = primitive.NewObjectID()
+		plan.CreatedAt = time.Now().UTC()
+		plan.UpdatedAt = plan.CreatedAt
+
+		_, err = planCollection.InsertOne(ctx, plan)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid plan_name: A plan with the given name already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "CreatePlan", After: bson.M{"plan_id": plan.ID, "plan_name": plan.Name, "quota": plan.Quota, "quota_num_days": plan.QuotaNumDays, "quota_windows": plan.QuotaWindows, "rate_limit_per_minute": plan.RateLimitPerMinute, "overage": plan.Overage}})
+
+		// Respond
+		c.JSON(http.StatusCreated, responses.PlanResponse{Status: http.StatusCreated, Message: "success", Data: plan})
+	}
+}
+
+/**************************************************************************
+* Update Plan
+* This enables Admins (user_id) to edit a plan (plan_id).
+*
+* The plan's plan_name, quota, quota_num_days, quota_windows,
+* rate_limit_per_minute, and overage can optionally be changed.
+* Should quota_windows or rate_limit_per_minute be changed, both are
+* replaced by the given values.
+*
+* Every key subscribed to the plan, whose quota has not been overridden,
+* is updated to match the plan. Keys keep the usage they consumed this
+* quota period and in each of their quota windows, whose resets are
+* unchanged. Quota windows new to the plan start with their full quota.
+* Should quota_num_days be changed, each key's quota period restarts at
+* its most recent reset, so it ends after the new number of days.
+**************************************************************************/
+func UpdatePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var plan models.Plan
+
+		var updatedAt time.Time
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err = time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get planID
+		planIDQuery, exists := c.GetQuery("plan_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'plan_id' field"})
+			return
+		}
+		planID, err := primitive.ObjectIDFromHex(planIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PlanResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get plan
+		err = planCollection.FindOne(ctx, bson.M{"_id": planID}).Decode(&plan)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PlanResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid plan_id: Plan does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify matching updated_at
+		if !plan.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Plan has been updated"})
+			return
+		}
+
+		before := bson.M{"plan_id": plan.ID, "plan_name": plan.Name, "quota": plan.Quota, "quota_num_days": plan.QuotaNumDays, "quota_windows": plan.QuotaWindows, "rate_limit_per_minute": plan.RateLimitPerMinute, "overage": plan.Overage}
+		previousQuotaNumDays := plan.QuotaNumDays
+
+		// Get planName (optional)
+		planName, exists := c.GetQuery("plan_name")
+		if exists && planName != plan.Name {
+			if planName == "" {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid plan_name: Must not be empty"})
+				return
+			}
+			count, err := planCollection.CountDocuments(ctx, bson.M{"plan_name": planName})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid plan_name: A plan with the given name already exists"})
+				return
+			}
+			plan.Name = planName
+		}
+
+		// Get quota (optional)
+		quotaStr, exists := c.GetQuery("quota")
+		if exists {
+			plan.Quota, err = strconv.Atoi(quotaStr)
+			if err != nil || plan.Quota < 0 {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota: Must not be negative"})
+				return
+			}
+		}
+
+		// Get quotaNumDays (optional)
+		quotaNumDaysStr, exists := c.GetQuery("quota_num_days")
+		if exists {
+			plan.QuotaNumDays, err = strconv.Atoi(quotaNumDaysStr)
+			if err != nil || plan.QuotaNumDays <= 0 {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota_num_days: Must be positive"})
+				return
+			}
+		}
+
+		// Get overage (optional)
+		overageStr, exists := c.GetQuery("overage")
+		if exists {
+			plan.Overage, err = strconv.Atoi(overageStr)
+			if err != nil || plan.Overage < 0 {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid overage: Must not be negative"})
+				return
+			}
+		}
+
+		// Get quotaWindows and rateLimitPerMinute (optional)
+		quotaWindowsStr, quotaWindowsExist := c.GetQuery("quota_windows")
+		rateLimitPerMinuteStr, rateLimitPerMinuteExists := c.GetQuery("rate_limit_per_minute")
+		if quotaWindowsExist || rateLimitPerMinuteExists {
+			plan.RateLimitPerMinute = 0
+			if rateLimitPerMinuteExists {
+				plan.RateLimitPerMinute, err = strconv.Atoi(rateLimitPerMinuteStr)
+				if err != nil || plan.RateLimitPerMinute < 0 {
+					c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rate_limit_per_minute: Must not be negative"})
+					return
+				}
+			}
+			plan.QuotaWindows, err = parsePlanQuotaWindows(quotaWindowsStr, plan.RateLimitPerMinute)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+		plan.UpdatedAt = now
+
+		// Update plan and its subscribed keys
+		// @INFO: Matching the previous updated_at skips concurrent updates
+		updatePlan := bson.D{{Key: "$set", Value: bson.D{{Key: "plan_name", Value: plan.Name}, {Key: "quota", Value: plan.Quota}, {Key: "quota_num_days", Value: plan.QuotaNumDays}, {Key: "quota_windows", Value: plan.QuotaWindows}, {Key: "rate_limit_per_minute", Value: plan.RateLimitPerMinute}, {Key: "overage", Value: plan.Overage}, {Key: "updated_at", Value: plan.UpdatedAt}}}}
+
+		keyQuotaWindows := keepConsumedQuotaWindowsExpression(plan.QuotaWindows, now)
+		keepConsumedUsage := bson.D{{Key: "$add", Value: bson.A{"$usage_remaining", bson.D{{Key: "$subtract", Value: bson.A{plan.Quota, "$quota"}}}}}}
+		updateKeys := bson.D{{Key: "$set", Value: bson.D{{Key: "quota", Value: plan.Quota}, {Key: "quota_num_days", Value: plan.QuotaNumDays}, {Key: "overage", Value: plan.Overage}, {Key: "usage_remaining", Value: keepConsumedUsage}, {Key: "quota_windows", Value: keyQuotaWindows}, {Key: "updated_at", Value: now}}}}
+		keysFilter := bson.D{{Key: "plan_id", Value: plan.ID}, {Key: "quota_override", Value: bson.D{{Key: "$ne", Value: true}}}}
+
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			result, err := planCollection.UpdateOne(sessCtx, bson.D{{Key: "_id", Value: plan.ID}, {Key: "updated_at", Value: updatedAt}}, updatePlan)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errPlanOutOfDate
+			}
+
+			_, err = keyCollection.UpdateMany(sessCtx, keysFilter, bson.A{updateKeys})
+			if err != nil {
+				return err
+			}
+
+			// Restart the quota period of subscribed keys, should its length change
+			if plan.QuotaNumDays != previousQuotaNumDays {
+				return resetSubscribedKeysQuotaTimestamps(sessCtx, keysFilter, plan, now)
+			}
+			return nil
+		})
+		if err == errPlanOutOfDate {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
+			return
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, responses.PlanResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid plan_name: A plan with the given name already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "UpdatePlan", Before: before, After: bson.M{"plan_id": plan.ID, "plan_name": plan.Name, "quota": plan.Quota, "quota_num_days": plan.QuotaNumDays, "quota_windows": plan.QuotaWindows, "rate_limit_per_minute": plan.RateLimitPerMinute, "overage": plan.Overage}})
+
+		// Respond
+		c.JSON(http.StatusOK, responses.PlanResponse{Status: http.StatusOK, Message: "success", Data: plan})
+	}
+}
+
+/**************************************************************************
+* Get Plans
+* This returns every plan to the given user (user_id).
+**************************************************************************/
+func GetPlans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var plans []models.Plan
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PlanResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify userID is valid (user exists)
+		count, err := userCollection.CountDocuments(ctx, bson.M{"_id": userID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, responses.PlanResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+			return
+		}
+
+		// Find plans
+		cursor, err := planCollection.Find(ctx, bson.D{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		plans = []models.Plan{}
+		err = cursor.All(ctx, &plans)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PlanResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.PlanResponse{Status: http.StatusOK, Message: "success", Data: plans})
+	}
+}
+
+/**************************************************************************
+* Set Key Plan
+* This enables Leads and Admins (user_id) to subscribe a key (key_id)
+* to a plan (plan_id), replacing any quota override of the key.
+*
+* Admins can set the plan of any key.
+* Leads can only set the plan of advanced keys
+* for services they are leads for.
+**************************************************************************/
+func SetKeyPlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+		var plan models.Plan
+
+		var updatedAt time.Time
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err = time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get planID
+		planIDQuery, exists := c.GetQuery("plan_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'plan_id' field"})
+			return
+		}
+		planID, err := primitive.ObjectIDFromHex(planIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify keyID is valid (key exists)
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify matching updated_at
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Verify planID is valid (plan exists)
+		err = planCollection.FindOne(ctx, bson.M{"_id": planID}).Decode(&plan)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid plan_id: Plan does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify userID is valid (user exists and has permissions)
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		if user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to set the plan for this key"})
+			return
+		}
+
+		// Get the time zone and reset hour of the key's quota
+		loc, resetHour, err := findKeyQuotaSchedule(ctx, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		now := time.Now().UTC()
+
+		before := bson.M{"plan_id": key.PlanID, "quota_override": key.QuotaOverride, "quota": key.Quota, "quota_num_days": key.QuotaNumDays, "quota_windows": key.QuotaWindows, "overage": key.Overage, "usage_remaining": key.UsageRemaining}
+
+		// Subscribe key to plan
+		subscribeKeyToPlan(&key, plan, now, loc)
+		key.UpdatedAt = now
+		key.QuotaTimestamp = configs.NextQuotaTimestamp(now, key.QuotaNumDays, loc, resetHour)
+		key.UsageBuckets = []models.UsageBucket{}
+		key.RolloverCarried = 0
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}, {Key: "plan_id", Value: key.PlanID}, {Key: "quota_override", Value: key.QuotaOverride}, {Key: "quota", Value: key.Quota}, {Key: "quota_num_days", Value: key.QuotaNumDays}, {Key: "quota_windows", Value: key.QuotaWindows}, {Key: "overage", Value: key.Overage}, {Key: "usage_buckets", Value: key.UsageBuckets}, {Key: "rollover_carried", Value: key.RolloverCarried}, {Key: "quota_timestamp", Value: key.QuotaTimestamp}, {Key: "usage_remaining", Value: key.UsageRemaining}, {Key: "notified_thresholds", Value: bson.A{}}}}}
+		result, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: updatedAt}}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetKeyPlan", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: bson.M{"plan_id": key.PlanID, "quota_override": key.QuotaOverride, "quota": key.Quota, "quota_num_days": key.QuotaNumDays, "quota_windows": key.QuotaWindows, "overage": key.Overage, "usage_remaining": key.UsageRemaining}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			PlanID         primitive.ObjectID   `json:"plan_id" bson:"plan_id"`
+			Quota          int                  `json:"quota" bson:"quota"`
+			QuotaNumDays   int                  `json:"quota_num_days" bson:"quota_num_days"`
+			QuotaWindows   []models.QuotaWindow `json:"quota_windows" bson:"quota_windows"`
+			Overage        int                  `json:"overage" bson:"overage"`
+			UsageRemaining int                  `json:"usage_remaining" bson:"usage_remaining"`
+			QuotaTimestamp string               `json:"quota_timestamp"`
+			UpdatedAt      string               `json:"updated_at" bson:"updated_at"`
+		}{
+			PlanID:         key.PlanID,
+			Quota:          key.Quota,
+			QuotaNumDays:   key.QuotaNumDays,
+			QuotaWindows:   key.QuotaWindows,
+			Overage:        key.Overage,
+			UsageRemaining: key.UsageRemaining,
+			QuotaTimestamp: key.QuotaTimestamp.Format(configs.DateLayout),
+			UpdatedAt:      key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
@@ -0,0 +1,613 @@
+/**************************************************************************
+* Pool endpoint logic.
+*
+* Pools are quotas shared by a group of a service's advanced keys, such
+* as a project team's keys for each of its deployments. A pool has its
+* own quota and quota period, which is reset lazily as it is consumed
+* in the service's time zone and at its reset hour.
+*
+* Requests with a key in a pool draw from the pool as well as the key's
+* own quota, or only from the pool should the key be 'pool_only'.
+*
+* Admins can manage pools of any service.
+* Leads can only manage pools of services they are leads for.
+*
+* Reponses are built using responses/pool_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var poolCollection *mongo.Collection = configs.GetCollection(configs.DB, "pools")
+
+/**************************************************************************
+* Consume Pool Quota
+* This atomically consumes one unit of the pool's (poolID) quota.
+* Should the pool's quota period have ended, it is first reset to the
+* pool's quota and its quota_timestamp advanced to the next reset in
+* the service's time zone and at its reset hour.
+*
+* Keys of pools which no longer exist are not granted any usage, rather
+* than drawing from no pool at all.
+*
+* Returns the pool and whether the unit was consumed.
+**************************************************************************/
+func consumePoolQuota(ctx context.Context, poolID primitive.ObjectID, service models.Service, now time.Time) (models.Pool, bool, error) {
+	var pool models.Pool
+	var updatedPool models.Pool
+
+	err := poolCollection.FindOne(ctx, bson.M{"_id": poolID}).Decode(&pool)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return models.Pool{}, false, fmt.Errorf("pool %s of the key does not exist", poolID.Hex())
+		}
+		return pool, false, err
+	}
+
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	if !now.Before(pool.QuotaTimestamp) {
+		// Reset the pool's quota period and consume
+		// @INFO: Matching the previous quota_timestamp only resets the period once across concurrent requests
+		loc, resetHour := configs.ResolveQuotaSchedule("", nil, service.Timezone, service.ResetHour)
+		quotaTimestamp := configs.NextQuotaTimestamp(now, pool.QuotaNumDays, loc, resetHour)
+
+		filter := bson.D{{Key: "_id", Value: pool.ID}, {Key: "quota_timestamp", Value: pool.QuotaTimestamp}, {Key: "quota", Value: bson.D{{Key: "$gt", Value: 0}}}}
+		resetQuota := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_remaining", Value: bson.D{{Key: "$subtract", Value: bson.A{"$quota", 1}}}}, {Key: "quota_timestamp", Value: quotaTimestamp}}}}
+
+		err = poolCollection.FindOneAndUpdate(ctx, filter, bson.A{resetQuota}, findOptions).Decode(&updatedPool)
+		if err == nil {
+			return updatedPool, true, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return pool, false, err
+		}
+		// The period was already reset, so consume as usual
+	}
+
+	filter := bson.D{{Key: "_id", Value: pool.ID}, {Key: "usage_remaining", Value: bson.D{{Key: "$gt", Value: 0}}}}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "usage_remaining", Value: -1}}}}
+
+	err = poolCollection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&updatedPool)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// No usage remaining
+			return pool, false, nil
+		}
+		return pool, false, err
+	}
+
+	return updatedPool, true, nil
+}
+
+/**************************************************************************
+* Refund Pool Quota
+* This returns the unit consumed from the pool's quota, should the
+* request then be denied by the key's own quota.
+**************************************************************************/
+func refundPoolQuota(ctx context.Context, pool models.Pool) error {
+	if pool.ID == primitive.NilObjectID {
+		return nil
+	}
+
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "usage_remaining", Value: 1}}}}
+	_, err := poolCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: pool.ID}}, update)
+	return err
+}
+
+/**************************************************************************
+* Create Pool
+* This enables Leads and Admins (user_id) to create a pool (pool_name)
+* for a service (service_id) with the given quota per quota period
+* (quota) of quota_num_days (Default: 1).
+**************************************************************************/
+func CreatePool() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var service models.Service
+		var pool models.Pool
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'service_id' field"})
+			return
+		}
+		serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get poolName
+		pool.Name, exists = c.GetQuery("pool_name")
+		if !exists || pool.Name == "" {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'pool_name' field"})
+			return
+		}
+
+		// Get quota
+		quotaStr, exists := c.GetQuery("quota")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'quota' field"})
+			return
+		}
+		pool.Quota, err = strconv.Atoi(quotaStr)
+		if err != nil || pool.Quota < 0 {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota: Must not be negative"})
+			return
+		}
+
+		// Get quotaNumDays (optional)
+		pool.QuotaNumDays = configs.DefaultQuotaNumDays
+		quotaNumDaysStr, exists := c.GetQuery("quota_num_days")
+		if exists {
+			pool.QuotaNumDays, err = strconv.Atoi(quotaNumDaysStr)
+			if err != nil || pool.QuotaNumDays <= 0 {
+				c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid quota_num_days: Must be positive"})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify serviceID is valid (service exists)
+		err = serviceCollection.FindOne(ctx, bson.M{"_id": serviceID}).Decode(&service)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid service_id: Service does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the service
+		if user.Type != "Admin" && (user.Type != "Lead" || !slices.Contains(user.Services, serviceID)) {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to create pools for the given service"})
+			return
+		}
+
+		// Build pool
+		loc, resetHour := configs.ResolveQuotaSchedule("", nil, service.Timezone, service.ResetHour)
+		pool.ID = primitive.NewObjectID()
+		pool.ServiceID = serviceID
+		pool.CreatorID = userID
+		pool.UsageRemaining = pool.Quota
+		pool.CreatedAt = time.Now().UTC()
+		pool.UpdatedAt = pool.CreatedAt
+		pool.QuotaTimestamp = configs.NextQuotaTimestamp(pool.CreatedAt, pool.QuotaNumDays, loc, resetHour)
+
+		// Create pool
+		_, err = poolCollection.InsertOne(ctx, pool)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "CreatePool", TargetServiceID: serviceID, After: bson.M{"pool_id": pool.ID, "pool_name": pool.Name, "quota": pool.Quota, "quota_num_days": pool.QuotaNumDays}})
+
+		// Respond
+		c.JSON(http.StatusCreated, responses.PoolResponse{Status: http.StatusCreated, Message: "success", Data: pool})
+	}
+}
+
+/**************************************************************************
+* Add Key To Pool
+* This enables Leads and Admins (user_id) to add an advanced key
+* (key_id) last updated at updated_at to a pool (pool_id) of the key's
+* service, moving it out of any pool it was in.
+*
+* Should pool_only be 'true', the key's requests only draw from the
+* pool rather than from the pool as well as the key's own quota.
+**************************************************************************/
+func AddKeyToPool() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+		var pool models.Pool
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get poolID
+		poolIDQuery, exists := c.GetQuery("pool_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'pool_id' field"})
+			return
+		}
+		poolID, err := primitive.ObjectIDFromHex(poolIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err := time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get poolOnly (optional)
+		poolOnly := false
+		poolOnlyStr, exists := c.GetQuery("pool_only")
+		if exists {
+			poolOnly, err = strconv.ParseBool(poolOnlyStr)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid pool_only: Must be 'true' or 'false'"})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get pool
+		err = poolCollection.FindOne(ctx, bson.M{"_id": poolID}).Decode(&pool)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid pool_id: Pool does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Verify key is an advanced key of the pool's service
+		if key.Type != "Advanced" || key.ServiceID != pool.ServiceID {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "Only advanced keys of the pool's service can be added to the pool"})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the pool's service
+		if user.Type != "Admin" && (user.Type != "Lead" || !slices.Contains(user.Services, pool.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to manage pools for this service"})
+			return
+		}
+
+		before := bson.M{"pool_id": key.PoolID, "pool_only": key.PoolOnly}
+
+		// Add key to pool
+		key.PoolID = pool.ID
+		key.PoolOnly = poolOnly
+		key.UpdatedAt = time.Now().UTC()
+
+		// @INFO: Matching the updated_at and service leaves keys changed (e.g. moved to another service) since they were found
+		filter := bson.D{{Key: "_id", Value: keyID}, {Key: "updated_at", Value: updatedAt}, {Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "service_id", Value: pool.ServiceID}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "pool_id", Value: key.PoolID}, {Key: "pool_only", Value: key.PoolOnly}, {Key: "updated_at", Value: key.UpdatedAt}}}}
+		result, err := keyCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "AddKeyToPool", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: bson.M{"pool_id": key.PoolID, "pool_only": key.PoolOnly}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			PoolID    primitive.ObjectID `json:"pool_id" bson:"pool_id"`
+			PoolOnly  bool               `json:"pool_only" bson:"pool_only"`
+			UpdatedAt string             `json:"updated_at" bson:"updated_at"`
+		}{
+			PoolID:    key.PoolID,
+			PoolOnly:  key.PoolOnly,
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.PoolResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Remove Key From Pool
+* This enables Leads and Admins (user_id) to remove a key (key_id) last
+* updated at updated_at from its pool, so its requests only draw from its
+* own quota.
+**************************************************************************/
+func RemoveKeyFromPool() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get updatedAt
+		updatedAtQuery, exists := c.GetQuery("updated_at")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'updated_at' field"})
+			return
+		}
+		updatedAt, err := time.Parse(configs.DateLayout, updatedAtQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify matching updated_At
+		if !key.UpdatedAt.Equal(updatedAt) {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Verify key is in a pool
+		if key.PoolID == primitive.NilObjectID {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "The given key is not in a pool"})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the key's service
+		if user.Type != "Admin" && (user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to manage pools for this service"})
+			return
+		}
+
+		before := bson.M{"pool_id": key.PoolID, "pool_only": key.PoolOnly}
+
+		// Remove key from pool
+		key.UpdatedAt = time.Now().UTC()
+
+		// @INFO: Matching the updated_at and service leaves keys changed (e.g. moved to another service) since they were found
+		filter := bson.D{{Key: "_id", Value: keyID}, {Key: "updated_at", Value: updatedAt}, {Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "service_id", Value: key.ServiceID}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}}}, {Key: "$unset", Value: bson.D{{Key: "pool_id", Value: ""}, {Key: "pool_only", Value: ""}}}}
+		result, err := keyCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "Out of date request: Key has been updated"})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "RemoveKeyFromPool", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: before, After: bson.M{}})
+
+		// @TODO: Refactor to key_response type
+		res := struct {
+			UpdatedAt string `json:"updated_at" bson:"updated_at"`
+		}{
+			UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.PoolResponse{Status: http.StatusOK, Message: "success", Data: res})
+	}
+}
+
+/**************************************************************************
+* Get Service Pools
+* This returns the pools of a service (service_id) to the given user
+* (user_id), along with the ids of the keys in each pool.
+*
+* Admins can view pools of any service.
+* Leads can only view pools of services they are leads for.
+**************************************************************************/
+func GetServicePools() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var pools []map[string]interface{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'service_id' field"})
+			return
+		}
+		serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.PoolResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.PoolResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is an Admin, or a lead of the service
+		if user.Type != "Admin" && (user.Type != "Lead" || !slices.Contains(user.Services, serviceID)) {
+			c.JSON(http.StatusConflict, responses.PoolResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to view pools for the given service"})
+			return
+		}
+
+		// Aggregation pipeline stages
+		matchOnServiceID := bson.D{{Key: "$match", Value: bson.D{{Key: "service_id", Value: serviceID}}}}
+		lookupKeys := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "keys"}, {Key: "localField", Value: "_id"}, {Key: "foreignField", Value: "pool_id"}, {Key: "as", Value: "keys"}}}}
+		projectKeyIDs := bson.D{{Key: "$set", Value: bson.D{{Key: "key_ids", Value: "$keys._id"}}}}
+		unsetKeys := bson.D{{Key: "$unset", Value: "keys"}}
+
+		aggregationPipeline := bson.A{matchOnServiceID, lookupKeys, projectKeyIDs, unsetKeys}
+
+		// Preform aggregation
+		cursor, err := poolCollection.Aggregate(ctx, aggregationPipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		pools = []map[string]interface{}{}
+		err = cursor.All(ctx, &pools)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.PoolResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.PoolResponse{Status: http.StatusOK, Message: "success", Data: pools})
+	}
+}
@@ -0,0 +1,425 @@
+/**************************************************************************
+* Quota logic.
+*
+* Keys consume their quota in one of the following modes:
+*  - 'Fixed'   : The key's usage remaining is reset to its quota every
+*                quota_num_days. Keys whose quota_timestamp has passed are
+*                reset as they are consumed, and are otherwise reset in
+*                batches by configs.RefreshUsageRemainingOperation.
+*  - 'Sliding' : The key's consumption is counted over a rolling window
+*                of quota_window_hours, split into usage buckets. Buckets
+*                which fall out of the window free up their capacity.
+*  - 'Credit'  : The key deducts from its prepaid credit_balance, which
+*                never resets and is only topped up by Admins
+*                (see controllers/credit.go).
+*
+* Keys without a quota_mode use the 'Fixed' mode.
+*
+* Keys may additionally carry calendar-aligned quota windows
+* (e.g. 1,000 per Hour and 20,000 per Month), all of which must have
+* usage remaining for a request to be allowed. Windows are reset lazily
+* as they are consumed, once their reset_at has passed.
+*
+* Quota periods and windows follow the time zone and reset hour of the
+* key, or otherwise its service, defaulting to midnight UTC.
+*
+* Services may additionally limit the requests of all of their keys with
+* an aggregate quota per aggregate quota period, which is reset lazily
+* as it is consumed in the service's time zone and at its reset hour.
+*
+* Fixed quota keys may opt into rolling over a percent of their unused
+* usage into the next period, up to a cap, using the rollover policy of
+* the key, or otherwise its service.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+)
+
+/**************************************************************************
+* Find Key Quota Schedule
+* This returns the time zone and reset hour of the key's quota, looking
+* up the key's service should the key not set its own.
+*
+* Basic keys are not for a specific service, so only use their own.
+**************************************************************************/
+func findKeyQuotaSchedule(ctx context.Context, key models.Key) (*time.Location, int, error) {
+	var service models.Service
+
+	if key.Type == "Advanced" && (key.Timezone == "" || key.ResetHour == nil) {
+		err := serviceCollection.FindOne(ctx, bson.M{"_id": key.ServiceID}).Decode(&service)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, 0, err
+		}
+	}
+
+	loc, resetHour := configs.ResolveQuotaSchedule(key.Timezone, key.ResetHour, service.Timezone, service.ResetHour)
+	return loc, resetHour, nil
+}
+
+/**************************************************************************
+* Consume Fixed Quota
+* This atomically consumes one unit of a Fixed quota key's usage
+* remaining, provided it has usage remaining. Keys with an overage can
+* consume that many units beyond their quota, leaving their usage
+* remaining negative.
+*
+* Should the key's quota period have ended, its usage remaining is
+* first restored to its quota and its quota_timestamp advanced to the
+* next reset in loc at resetHour, so keys are never left exhausted
+* when the batch refresh has not yet run. The usage of the ended period
+* is recorded in the key's usage history. Unused usage is carried into
+* the new period following the rollover policy (rolloverPercent and
+* rolloverCap).
+*
+* Returns the updated key and whether the unit was consumed.
+**************************************************************************/
+func consumeFixedQuota(ctx context.Context, key models.Key, now time.Time, loc *time.Location, resetHour int, rolloverPercent int, rolloverCap *int) (models.Key, bool, error) {
+	var updatedKey models.Key
+
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	if !now.Before(key.QuotaTimestamp) {
+		// Reset the key's quota period and consume
+		// @INFO: Matching the previous quota_timestamp only resets the period once across concurrent requests
+		filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "quota_timestamp", Value: key.QuotaTimestamp}, {Key: "quota", Value: bson.D{{Key: "$gt", Value: 0}}}}
+		quotaTimestamp := configs.NextQuotaTimestamp(now, key.QuotaNumDays, loc, resetHour)
+		setRolloverCarried := bson.D{{Key: "$set", Value: bson.D{{Key: "rollover_carried", Value: configs.RolloverCarriedExpression(rolloverPercent, rolloverCap)}}}}
+		resetQuota := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_remaining", Value: bson.D{{Key: "$subtract", Value: bson.A{bson.D{{Key: "$add", Value: bson.A{"$quota", "$rollover_carried"}}}, 1}}}}, {Key: "notified_thresholds", Value: bson.A{}}, {Key: "quota_timestamp", Value: quotaTimestamp}, {Key: "last_used", Value: now}, {Key: "updated_at", Value: now}}}}
+
+		var previousKey models.Key
+		err := keyCollection.FindOneAndUpdate(ctx, filter, bson.A{setRolloverCarried, resetQuota}, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previousKey)
+		if err == nil {
+			// Record the usage of the ended period
+			recordUsageHistory(ctx, previousKey, loc, now)
+
+			// @INFO: Built from the key before the reset, as reading it again could fail once the unit was consumed
+			updatedKey = previousKey
+			updatedKey.RolloverCarried = configs.RolloverCarried(previousKey.Quota, previousKey.UsageRemaining, rolloverPercent, rolloverCap)
+			updatedKey.UsageRemaining = previousKey.Quota + updatedKey.RolloverCarried - 1
+			updatedKey.NotifiedThresholds = nil
+			updatedKey.QuotaTimestamp = quotaTimestamp
+			updatedKey.LastUsed = now
+			updatedKey.UpdatedAt = now
+			return updatedKey, true, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return key, false, err
+		}
+		// The period was already reset, so consume as usual
+	}
+
+	hasUsageRemaining := bson.D{{Key: "$gt", Value: bson.A{"$usage_remaining", bson.D{{Key: "$multiply", Value: bson.A{-1, bson.D{{Key: "$ifNull", Value: bson.A{"$overage", 0}}}}}}}}}
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "$expr", Value: hasUsageRemaining}}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "usage_remaining", Value: -1}}}, {Key: "$set", Value: bson.D{{Key: "last_used", Value: now}}}}
+
+	err := keyCollection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&updatedKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// No usage remaining
+			return key, false, nil
+		}
+		return key, false, err
+	}
+
+	return updatedKey, true, nil
+}
+
+// Number of usage buckets a sliding window is split into
+const slidingWindowBuckets = 24
+
+// Get the duration of each usage bucket of a Sliding quota key
+func slidingWindowBucketSize(key models.Key) time.Duration {
+	bucketSize := time.Duration(key.QuotaWindowHours) * time.Hour / slidingWindowBuckets
+	if bucketSize < time.Minute {
+		bucketSize = time.Minute
+	}
+	return bucketSize
+}
+
+/**************************************************************************
+* Consume Sliding Window Quota
+* This atomically consumes one unit of a Sliding quota key's window,
+* provided the usage within the window is below the key's quota.
+*
+* Buckets outside of the window are dropped, and usage_remaining is
+* recalculated from the remaining buckets.
+*
+* Returns the updated key and whether the unit was consumed.
+**************************************************************************/
+func consumeSlidingWindowQuota(ctx context.Context, key models.Key, now time.Time) (models.Key, bool, error) {
+	var updatedKey models.Key
+
+	window := time.Duration(key.QuotaWindowHours) * time.Hour
+	windowStart := now.Add(-window)
+	bucketStart := now.Truncate(slidingWindowBucketSize(key))
+
+	// Buckets within the window
+	bucketsInWindow := bson.D{{Key: "$filter", Value: bson.D{{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$usage_buckets", bson.A{}}}}}, {Key: "as", Value: "bucket"}, {Key: "cond", Value: bson.D{{Key: "$gt", Value: bson.A{"$$bucket.start", windowStart}}}}}}}
+	usageInWindow := bson.D{{Key: "$sum", Value: bson.D{{Key: "$map", Value: bson.D{{Key: "input", Value: bucketsInWindow}, {Key: "as", Value: "windowBucket"}, {Key: "in", Value: "$$windowBucket.count"}}}}}}
+
+	// Only match the key while it has capacity within the window
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{usageInWindow, "$quota"}}}}}
+
+	// Update pipeline stages
+	dropExpiredBuckets := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_buckets", Value: bucketsInWindow}}}}
+	incrementBucket := bson.D{{Key: "$map", Value: bson.D{{Key: "input", Value: "$usage_buckets"}, {Key: "as", Value: "bucket"}, {Key: "in", Value: bson.D{{Key: "$cond", Value: bson.D{{Key: "if", Value: bson.D{{Key: "$eq", Value: bson.A{"$$bucket.start", bucketStart}}}}, {Key: "then", Value: bson.D{{Key: "start", Value: "$$bucket.start"}, {Key: "count", Value: bson.D{{Key: "$add", Value: bson.A{"$$bucket.count", 1}}}}}}, {Key: "else", Value: "$$bucket"}}}}}}}}
+	appendBucket := bson.D{{Key: "$concatArrays", Value: bson.A{"$usage_buckets", bson.A{bson.D{{Key: "start", Value: bucketStart}, {Key: "count", Value: 1}}}}}}
+	consumeUsage := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_buckets", Value: bson.D{{Key: "$cond", Value: bson.D{{Key: "if", Value: bson.D{{Key: "$in", Value: bson.A{bucketStart, "$usage_buckets.start"}}}}, {Key: "then", Value: incrementBucket}, {Key: "else", Value: appendBucket}}}}}}}}
+	setUsageRemaining := bson.D{{Key: "$set", Value: bson.D{{Key: "usage_remaining", Value: bson.D{{Key: "$subtract", Value: bson.A{"$quota", bson.D{{Key: "$sum", Value: "$usage_buckets.count"}}}}}}, {Key: "last_used", Value: now}}}}
+
+	updatePipeline := bson.A{dropExpiredBuckets, consumeUsage, setUsageRemaining}
+
+	err := keyCollection.FindOneAndUpdate(ctx, filter, updatePipeline, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// No capacity within the window
+			return key, false, nil
+		}
+		return key, false, err
+	}
+
+	return updatedKey, true, nil
+}
+
+/**************************************************************************
+* Sliding Window Retry After
+* This returns how long until a Sliding quota key has capacity again,
+* which happens once enough of its oldest buckets leave the window.
+**************************************************************************/
+func slidingWindowRetryAfter(key models.Key, now time.Time) time.Duration {
+	window := time.Duration(key.QuotaWindowHours) * time.Hour
+	windowStart := now.Add(-window)
+
+	// Buckets within the window, oldest first
+	buckets := []models.UsageBucket{}
+	usage := 0
+	for _, bucket := range key.UsageBuckets {
+		if bucket.Start.After(windowStart) {
+			buckets = append(buckets, bucket)
+			usage += bucket.Count
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+
+	// Free the oldest buckets until there is capacity
+	for _, bucket := range buckets {
+		if usage < key.Quota {
+			break
+		}
+		usage -= bucket.Count
+		if usage < key.Quota {
+			return bucket.Start.Add(window).Sub(now)
+		}
+	}
+
+	return 0
+}
+
+/**************************************************************************
+* Parse Quota Windows
+* This parses quota windows given as comma separated unit:quota pairs
+* (e.g. "Hour:1000,Month:20000"). Each window starts with its full quota
+* and resets at the start of the next calendar window (in loc) after now.
+*
+* An empty string parses to no quota windows.
+**************************************************************************/
+func parseQuotaWindows(quotaWindowsStr string, now time.Time, loc *time.Location) ([]models.QuotaWindow, error) {
+	quotaWindows := []models.QuotaWindow{}
+	if quotaWindowsStr == "" {
+		return quotaWindows, nil
+	}
+
+	for _, quotaWindowStr := range strings.Split(quotaWindowsStr, ",") {
+		unit, quotaStr, found := strings.Cut(strings.TrimSpace(quotaWindowStr), ":")
+		if !found {
+			return nil, errors.New("Invalid quota_windows: Each window must be of the form 'unit:quota'")
+		}
+		if !slices.Contains(configs.QuotaWindowUnits, unit) {
+			return nil, errors.New("Invalid quota_windows: Unit must be 'Minute', 'Hour', 'Day', 'Week', or 'Month'")
+		}
+		for _, quotaWindow := range quotaWindows {
+			if quotaWindow.Unit == unit {
+				return nil, errors.New("Invalid quota_windows: Each unit can only be given once")
+			}
+		}
+		quota, err := strconv.Atoi(quotaStr)
+		if err != nil || quota <= 0 {
+			return nil, errors.New("Invalid quota_windows: Quota must be a positive integer")
+		}
+
+		quotaWindows = append(quotaWindows, models.QuotaWindow{Unit: unit, Quota: quota, UsageRemaining: quota, ResetAt: configs.NextQuotaWindowReset(unit, now, loc)})
+	}
+
+	return quotaWindows, nil
+}
+
+/**************************************************************************
+* Consume Quota Windows
+* This atomically consumes one unit of each of the key's quota windows,
+* provided every window has usage remaining. Windows whose reset_at
+* has passed are reset before being consumed, and next reset at the
+* start of the next calendar window in loc.
+*
+* Returns the updated key and whether the units were consumed.
+**************************************************************************/
+func consumeQuotaWindows(ctx context.Context, key models.Key, now time.Time, loc *time.Location) (models.Key, bool, error) {
+	var updatedKey models.Key
+
+	if len(key.QuotaWindows) == 0 {
+		return key, true, nil
+	}
+
+	// Next reset of each window unit, should the window be reset
+	nextResetBranches := bson.A{}
+	for _, unit := range configs.QuotaWindowUnits {
+		nextResetBranches = append(nextResetBranches, bson.D{{Key: "case", Value: bson.D{{Key: "$eq", Value: bson.A{"$$window.unit", unit}}}}, {Key: "then", Value: configs.NextQuotaWindowReset(unit, now, loc)}})
+	}
+	nextReset := bson.D{{Key: "$switch", Value: bson.D{{Key: "branches", Value: nextResetBranches}, {Key: "default", Value: "$$window.reset_at"}}}}
+
+	windowHasReset := bson.D{{Key: "$lte", Value: bson.A{"$$window.reset_at", now}}}
+	windowHasCapacity := bson.D{{Key: "$or", Value: bson.A{windowHasReset, bson.D{{Key: "$gt", Value: bson.A{"$$window.usage_remaining", 0}}}}}}
+
+	// Only match the key while every window has capacity
+	filter := bson.D{{Key: "_id", Value: key.ID}, {Key: "$expr", Value: bson.D{{Key: "$allElementsTrue", Value: bson.A{bson.D{{Key: "$map", Value: bson.D{{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$quota_windows", bson.A{}}}}}, {Key: "as", Value: "window"}, {Key: "in", Value: windowHasCapacity}}}}}}}}}
+
+	// Reset windows which have passed and consume a unit of each window
+	resetAndConsumeWindow := bson.D{{Key: "$cond", Value: bson.D{
+		{Key: "if", Value: windowHasReset},
+		{Key: "then", Value: bson.D{{Key: "unit", Value: "$$window.unit"}, {Key: "quota", Value: "$$window.quota"}, {Key: "usage_remaining", Value: bson.D{{Key: "$subtract", Value: bson.A{"$$window.quota", 1}}}}, {Key: "reset_at", Value: nextReset}}},
+		{Key: "else", Value: bson.D{{Key: "unit", Value: "$$window.unit"}, {Key: "quota", Value: "$$window.quota"}, {Key: "usage_remaining", Value: bson.D{{Key: "$subtract", Value: bson.A{"$$window.usage_remaining", 1}}}}, {Key: "reset_at", Value: "$$window.reset_at"}}},
+	}}}
+	consumeWindows := bson.D{{Key: "$set", Value: bson.D{{Key: "quota_windows", Value: bson.D{{Key: "$map", Value: bson.D{{Key: "input", Value: "$quota_windows"}, {Key: "as", Value: "window"}, {Key: "in", Value: resetAndConsumeWindow}}}}}}}}
+
+	updatePipeline := bson.A{consumeWindows}
+
+	err := keyCollection.FindOneAndUpdate(ctx, filter, updatePipeline, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updatedKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// A window has no usage remaining
+			return key, false, nil
+		}
+		return key, false, err
+	}
+
+	return updatedKey, true, nil
+}
+
+/**************************************************************************
+* Refund Quota Windows
+* This returns the unit consumed from each of the key's quota windows,
+* for when the request is denied after the windows were consumed.
+**************************************************************************/
+func refundQuotaWindows(ctx context.Context, key models.Key) error {
+	if len(key.QuotaWindows) == 0 {
+		return nil
+	}
+
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "quota_windows.$[].usage_remaining", Value: 1}}}}
+	_, err := keyCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key.ID}}, update)
+	return err
+}
+
+/**************************************************************************
+* Quota Windows Retry After
+* This returns how long until every exhausted quota window of the key
+* has reset.
+**************************************************************************/
+func quotaWindowsRetryAfter(key models.Key, now time.Time) time.Duration {
+	var retryAfter time.Duration
+
+	for _, quotaWindow := range key.QuotaWindows {
+		if quotaWindow.UsageRemaining <= 0 && quotaWindow.ResetAt.After(now) {
+			if untilReset := quotaWindow.ResetAt.Sub(now); untilReset > retryAfter {
+				retryAfter = untilReset
+			}
+		}
+	}
+
+	return retryAfter
+}
+
+/**************************************************************************
+* Consume Service Aggregate Quota
+* This atomically consumes one unit of the service's aggregate quota,
+* provided the service has one. Should the service's aggregate quota
+* period have ended, it is first reset to the service's aggregate quota
+* and its aggregate_quota_timestamp advanced to the next reset in the
+* service's time zone and at its reset hour.
+*
+* Returns the updated service and whether the unit was consumed.
+**************************************************************************/
+func consumeServiceAggregateQuota(ctx context.Context, service models.Service, now time.Time) (models.Service, bool, error) {
+	var updatedService models.Service
+
+	if service.AggregateQuota <= 0 {
+		return service, true, nil
+	}
+
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	if !now.Before(service.AggregateQuotaTimestamp) {
+		// Reset the service's aggregate quota period and consume
+		// @INFO: Matching the previous aggregate_quota_timestamp only resets the period once across concurrent requests
+		loc, resetHour := configs.ResolveQuotaSchedule("", nil, service.Timezone, service.ResetHour)
+		aggregateQuotaTimestamp := configs.NextQuotaTimestamp(now, service.AggregateQuotaNumDays, loc, resetHour)
+
+		filter := bson.D{{Key: "_id", Value: service.ID}, {Key: "aggregate_quota_timestamp", Value: service.AggregateQuotaTimestamp}}
+		if service.AggregateQuotaTimestamp.IsZero() {
+			filter = bson.D{{Key: "_id", Value: service.ID}, {Key: "aggregate_quota_timestamp", Value: bson.D{{Key: "$exists", Value: false}}}}
+		}
+		resetAggregateQuota := bson.D{{Key: "$set", Value: bson.D{{Key: "aggregate_usage_remaining", Value: bson.D{{Key: "$subtract", Value: bson.A{"$aggregate_quota", 1}}}}, {Key: "aggregate_quota_timestamp", Value: aggregateQuotaTimestamp}}}}
+
+		err := serviceCollection.FindOneAndUpdate(ctx, filter, bson.A{resetAggregateQuota}, findOptions).Decode(&updatedService)
+		if err == nil {
+			return updatedService, true, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return service, false, err
+		}
+		// The period was already reset, so consume as usual
+	}
+
+	filter := bson.D{{Key: "_id", Value: service.ID}, {Key: "aggregate_usage_remaining", Value: bson.D{{Key: "$gt", Value: 0}}}}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "aggregate_usage_remaining", Value: -1}}}}
+
+	err := serviceCollection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&updatedService)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// No aggregate usage remaining
+			return service, false, nil
+		}
+		return service, false, err
+	}
+
+	return updatedService, true, nil
+}
+
+/**************************************************************************
+* Refund Service Aggregate Quota
+* This returns the unit consumed from the service's aggregate quota,
+* should the request then be denied by the key's own quota.
+**************************************************************************/
+func refundServiceAggregateQuota(ctx context.Context, service models.Service) error {
+	if service.AggregateQuota <= 0 {
+		return nil
+	}
+
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "aggregate_usage_remaining", Value: 1}}}}
+	_, err := serviceCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: service.ID}}, update)
+	return err
+}
@@ -0,0 +1,653 @@
+/**************************************************************************
+* Quota request endpoint logic.
+*
+* Key owners file quota increase requests for their keys, giving the
+* requested quota and a justification. Requests for advanced keys are
+* reviewed by the leads of the key's service, and requests for basic
+* keys by Admins. Admins can review any request.
+*
+* Reviewers approve a request, optionally with a changed quota, or deny
+* it. Approving applies the new quota to the key. The requester and the
+* reviewer are notified of each decision.
+*
+* Reponses are built using responses/quota_request_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/notifiers"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var quotaRequestCollection *mongo.Collection = configs.GetCollection(configs.DB, "quota_requests")
+
+// Returned from a transaction to abort it should a quota request no longer be pending
+var errQuotaRequestReviewed = errors.New("The given quota request has already been reviewed")
+
+// Returned from a transaction to abort it should a quota request's key no longer exist
+var errQuotaRequestKeyMissing = errors.New("The key of the given quota request no longer exists")
+
+// Returned from a transaction to abort it should the reviewer no longer be able to review a quota request's key
+var errQuotaRequestNotReviewable = errors.New("The given user does not have the authority to review this quota request")
+
+/**************************************************************************
+* Can Review Quota Request
+* This returns whether the user can review the quota request.
+*
+* Admins can review any request. Leads can review requests for
+* advanced keys of services they are leads for, except their own.
+**************************************************************************/
+func canReviewQuotaRequest(user models.User, request models.QuotaRequest) bool {
+	if user.ID == request.RequesterID && user.Type != "Admin" {
+		return false
+	}
+	return user.Type == "Admin" || (request.KeyType == "Advanced" && user.Type == "Lead" && slices.Contains(user.Services, request.ServiceID))
+}
+
+/**************************************************************************
+* Notify Quota Request Reviewers
+* This notifies the users who can review the quota request of it:
+* the leads of the key's service, or Admins for basic keys.
+**************************************************************************/
+func notifyQuotaRequestReviewers(ctx context.Context, request models.QuotaRequest, key models.Key) {
+	var reviewers []models.User
+
+	reviewersFilter := bson.D{{Key: "user_type", Value: "Admin"}}
+	if request.KeyType == "Advanced" {
+		reviewersFilter = bson.D{{Key: "user_type", Value: "Lead"}, {Key: "services", Value: request.ServiceID}}
+	}
+
+	cursor, err := userCollection.Find(ctx, reviewersFilter)
+	if err == nil {
+		err = cursor.All(ctx, &reviewers)
+	}
+	if err != nil {
+		log.Printf("Unable to find reviewers of quota request %s: %v", request.ID.Hex(), err)
+		return
+	}
+
+	for _, reviewer := range reviewers {
+		if reviewer.ID == request.RequesterID {
+			continue
+		}
+		notifyUser(reviewer.ID, notifiers.Notification{
+			Event:   "QuotaRequestCreated",
+			Subject: fmt.Sprintf("Quota increase requested for key '%s'", key.Name),
+			Message: fmt.Sprintf("A quota increase from %d to %d has been requested for key '%s': %s", request.CurrentQuota, request.RequestedQuota, key.Name, request.Justification),
+			Data:    map[string]interface{}{"request_id": request.ID.Hex(), "key_id": key.ID.Hex(), "current_quota": request.CurrentQuota, "requested_quota": request.RequestedQuota},
+		})
+	}
+}
+
+/**************************************************************************
+* Notify Quota Request Reviewed
+* This notifies the requester and the reviewer of the quota request's
+* review decision.
+**************************************************************************/
+func notifyQuotaRequestReviewed(request models.QuotaRequest) {
+	message := fmt.Sprintf("The quota increase request for key %s has been denied.", request.KeyID.Hex())
+	if request.Status == "Approved" {
+		message = fmt.Sprintf("The quota increase request for key %s has been approved with a quota of %d.", request.KeyID.Hex(), request.ApprovedQuota)
+	}
+	if request.ReviewNote != "" {
+		message += " Note: " + request.ReviewNote
+	}
+
+	notification := notifiers.Notification{
+		Event:   "QuotaRequest" + request.Status,
+		Subject: "Quota increase request " + request.Status,
+		Message: message,
+		Data:    map[string]interface{}{"request_id": request.ID.Hex(), "key_id": request.KeyID.Hex(), "status": request.Status, "requested_quota": request.RequestedQuota, "approved_quota": request.ApprovedQuota},
+	}
+
+	notifyUser(request.RequesterID, notification)
+	if request.ReviewerID != request.RequesterID {
+		notifyUser(request.ReviewerID, notification)
+	}
+}
+
+/**************************************************************************
+* Create Quota Request
+* This enables key owners (user_id) to request a quota increase
+* (requested_quota) for their key (key_id), given a justification.
+*
+* A key can only have one pending request at a time.
+**************************************************************************/
+func CreateQuotaRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var key models.Key
+		var request models.QuotaRequest
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get justification
+		justification, exists := c.GetQuery("justification")
+		if !exists || justification == "" {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'justification' field"})
+			return
+		}
+
+		// Get requestedQuota
+		requestedQuotaStr, exists := c.GetQuery("requested_quota")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'requested_quota' field"})
+			return
+		}
+		requestedQuota, err := strconv.Atoi(requestedQuotaStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID, "deleted_at": bson.M{"$exists": false}}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Verify user owns the key
+		if key.OwnerID != userID {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not own the given key"})
+			return
+		}
+
+		// Verify requested quota is an increase
+		if requestedQuota <= key.Quota {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid requested_quota: Must be greater than the key's current quota of " + strconv.Itoa(key.Quota)})
+			return
+		}
+
+		// Verify key has no pending request
+		count, err := quotaRequestCollection.CountDocuments(ctx, bson.D{{Key: "key_id", Value: keyID}, {Key: "status", Value: "Pending"}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given key already has a pending quota request"})
+			return
+		}
+
+		// Build request
+		request.ID = primitive.NewObjectID()
+		request.KeyID = key.ID
+		request.KeyType = key.Type
+		request.ServiceID = key.ServiceID
+		request.RequesterID = userID
+		request.CurrentQuota = key.Quota
+		request.RequestedQuota = requestedQuota
+		request.Justification = justification
+		request.Status = "Pending"
+		request.CreatedAt = time.Now().UTC()
+
+		// Create request
+		// @INFO: The unique index of pending requests rejects requests filed concurrently (see configs/setup.go)
+		_, err = quotaRequestCollection.InsertOne(ctx, request)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given key already has a pending quota request"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "CreateQuotaRequest", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, After: bson.M{"request_id": request.ID, "current_quota": request.CurrentQuota, "requested_quota": request.RequestedQuota, "justification": request.Justification}})
+
+		// Notify reviewers
+		notifyQuotaRequestReviewers(ctx, request, key)
+
+		// Respond
+		c.JSON(http.StatusCreated, responses.QuotaRequestResponse{Status: http.StatusCreated, Message: "success", Data: request})
+	}
+}
+
+/**************************************************************************
+* Approve Quota Request
+* This enables reviewers (user_id) to approve a pending quota request
+* (request_id), applying the requested quota, or the given quota
+* (approved_quota) should it be changed, to the key.
+*
+* The key keeps the usage it consumed this quota period. Should the key
+* subscribe to a plan, the approved quota overrides the plan's. The
+* request is only approved should the quota be applied to the key.
+*
+* A review note (note) can optionally be given.
+**************************************************************************/
+func ApproveQuotaRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var request models.QuotaRequest
+		var key models.Key
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get requestID
+		requestIDQuery, exists := c.GetQuery("request_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'request_id' field"})
+			return
+		}
+		requestID, err := primitive.ObjectIDFromHex(requestIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get request
+		err = quotaRequestCollection.FindOne(ctx, bson.M{"_id": requestID}).Decode(&request)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid request_id: Quota request does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if !canReviewQuotaRequest(user, request) {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to review this quota request"})
+			return
+		}
+
+		// Get approvedQuota (optional)
+		approvedQuota := request.RequestedQuota
+		approvedQuotaStr, exists := c.GetQuery("approved_quota")
+		if exists {
+			approvedQuota, err = strconv.Atoi(approvedQuotaStr)
+			if err != nil || approvedQuota <= 0 {
+				c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid approved_quota: Must be positive"})
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+
+		// Approve request and apply the approved quota
+		requestFilter := bson.D{{Key: "_id", Value: request.ID}, {Key: "status", Value: "Pending"}}
+		approveRequest := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "Approved"}, {Key: "approved_quota", Value: approvedQuota}, {Key: "reviewer_id", Value: userID}, {Key: "review_note", Value: c.Query("note")}, {Key: "reviewed_at", Value: now}}}}
+		keepConsumedUsage := bson.D{{Key: "$add", Value: bson.A{"$usage_remaining", bson.D{{Key: "$subtract", Value: bson.A{approvedQuota, "$quota"}}}}}}
+		overridePlan := bson.D{{Key: "$ne", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$plan_id", nil}}}, nil}}}
+		applyQuota := bson.D{{Key: "$set", Value: bson.D{{Key: "quota", Value: approvedQuota}, {Key: "usage_remaining", Value: keepConsumedUsage}, {Key: "quota_override", Value: overridePlan}, {Key: "updated_at", Value: now}}}}
+		keyFilter := bson.D{{Key: "_id", Value: request.KeyID}, {Key: "deleted_at", Value: bson.M{"$exists": false}}}
+
+		err = runTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// @INFO: Matching the pending status only lets one reviewer decide the request
+			err := quotaRequestCollection.FindOneAndUpdate(sessCtx, requestFilter, approveRequest, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&request)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return errQuotaRequestReviewed
+				}
+				return err
+			}
+
+			// Verify the user can still review the key, should it have moved service since the request was filed
+			err = keyCollection.FindOne(sessCtx, keyFilter).Decode(&key)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return errQuotaRequestKeyMissing
+				}
+				return err
+			}
+			keyRequest := request
+			keyRequest.KeyType = key.Type
+			keyRequest.ServiceID = key.ServiceID
+			if !canReviewQuotaRequest(user, keyRequest) {
+				return errQuotaRequestNotReviewable
+			}
+
+			// Keep the usage consumed this period
+			// @INFO: Matching the updated_at applies the quota to the key as it was reviewed
+			reviewedKeyFilter := bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: key.UpdatedAt}, {Key: "deleted_at", Value: bson.M{"$exists": false}}}
+			err = keyCollection.FindOneAndUpdate(sessCtx, reviewedKeyFilter, bson.A{applyQuota}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return errKeyOutOfDate
+				}
+				return err
+			}
+			return nil
+		})
+		if err == errQuotaRequestReviewed || err == errQuotaRequestNotReviewable || err == errKeyOutOfDate {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: err.Error()})
+			return
+		}
+		if err == errQuotaRequestKeyMissing {
+			c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "ApproveQuotaRequest", TargetKeyID: key.ID, TargetUserID: key.OwnerID, TargetServiceID: key.ServiceID, Before: bson.M{"quota": request.CurrentQuota}, After: bson.M{"request_id": request.ID, "quota": key.Quota, "quota_override": key.QuotaOverride, "usage_remaining": key.UsageRemaining}})
+
+		// Notify requester and reviewer
+		notifyQuotaRequestReviewed(request)
+
+		// Respond
+		c.JSON(http.StatusOK, responses.QuotaRequestResponse{Status: http.StatusOK, Message: "success", Data: request})
+	}
+}
+
+/**************************************************************************
+* Deny Quota Request
+* This enables reviewers (user_id) to deny a pending quota request
+* (request_id). A review note (note) can optionally be given.
+**************************************************************************/
+func DenyQuotaRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var request models.QuotaRequest
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get requestID
+		requestIDQuery, exists := c.GetQuery("request_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'request_id' field"})
+			return
+		}
+		requestID, err := primitive.ObjectIDFromHex(requestIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get request
+		err = quotaRequestCollection.FindOne(ctx, bson.M{"_id": requestID}).Decode(&request)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid request_id: Quota request does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if !canReviewQuotaRequest(user, request) {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to review this quota request"})
+			return
+		}
+
+		// Deny request, provided it is still pending
+		requestFilter := bson.D{{Key: "_id", Value: request.ID}, {Key: "status", Value: "Pending"}}
+		denyRequest := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: "Denied"}, {Key: "reviewer_id", Value: userID}, {Key: "review_note", Value: c.Query("note")}, {Key: "reviewed_at", Value: time.Now().UTC()}}}}
+		err = quotaRequestCollection.FindOneAndUpdate(ctx, requestFilter, denyRequest, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&request)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given quota request has already been reviewed"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "DenyQuotaRequest", TargetKeyID: request.KeyID, TargetUserID: request.RequesterID, TargetServiceID: request.ServiceID, After: bson.M{"request_id": request.ID, "requested_quota": request.RequestedQuota, "review_note": request.ReviewNote}})
+
+		// Notify requester and reviewer
+		notifyQuotaRequestReviewed(request)
+
+		// Respond
+		c.JSON(http.StatusOK, responses.QuotaRequestResponse{Status: http.StatusOK, Message: "success", Data: request})
+	}
+}
+
+/**************************************************************************
+* Get User Quota Requests
+* This returns the quota requests filed by the given user (user_id),
+* most recent first, optionally filtered by status.
+**************************************************************************/
+func GetUserQuotaRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var requests []models.QuotaRequest
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		filter := bson.D{{Key: "requester_id", Value: userID}}
+
+		// Get status (optional)
+		status, exists := c.GetQuery("status")
+		if exists {
+			filter = append(filter, bson.E{Key: "status", Value: status})
+		}
+
+		// Find requests, most recent first
+		cursor, err := quotaRequestCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		requests = []models.QuotaRequest{}
+		err = cursor.All(ctx, &requests)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.QuotaRequestResponse{Status: http.StatusOK, Message: "success", Data: requests})
+	}
+}
+
+/**************************************************************************
+* Get Service Quota Requests
+* This returns the quota requests the user (user_id) can review,
+* most recent first.
+*
+* Admins can view all requests, including those for basic keys.
+* Leads can only view requests for services they are leads for.
+*
+* Results can optionally be filtered by service_id and status.
+**************************************************************************/
+func GetServiceQuotaRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var requests []models.QuotaRequest
+
+		filter := bson.D{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID (optional)
+		var serviceID primitive.ObjectID
+		serviceIDQuery, filterByService := c.GetQuery("service_id")
+		if filterByService {
+			serviceID, err = primitive.ObjectIDFromHex(serviceIDQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.QuotaRequestResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		// Get status (optional)
+		status, exists := c.GetQuery("status")
+		if exists {
+			filter = append(filter, bson.E{Key: "status", Value: status})
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.QuotaRequestResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Scope requests by user type
+		if user.Type == "Admin" {
+			if filterByService {
+				filter = append(filter, bson.E{Key: "service_id", Value: serviceID})
+			}
+		} else if user.Type == "Lead" {
+			if filterByService {
+				if !slices.Contains(user.Services, serviceID) {
+					c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to view quota requests for the given service"})
+					return
+				}
+				filter = append(filter, bson.E{Key: "service_id", Value: serviceID})
+			} else {
+				if len(user.Services) == 0 { // Short circuit
+					c.JSON(http.StatusOK, responses.QuotaRequestResponse{Status: http.StatusOK, Message: "success", Data: []models.QuotaRequest{}})
+					return
+				}
+				filter = append(filter, bson.E{Key: "service_id", Value: bson.D{{Key: "$in", Value: user.Services}}})
+			}
+		} else {
+			c.JSON(http.StatusConflict, responses.QuotaRequestResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not a Lead or Admin"})
+			return
+		}
+
+		// Find requests, most recent first
+		cursor, err := quotaRequestCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		requests = []models.QuotaRequest{}
+		err = cursor.All(ctx, &requests)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.QuotaRequestResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.QuotaRequestResponse{Status: http.StatusOK, Message: "success", Data: requests})
+	}
+}
@@ -2,9 +2,10 @@
 * Service endpoint logic.
 *
 * This enables the creation of services in the Nebula Labs
-* kms/developer portal backend.
+* kms/developer portal backend, and Admins to set the quota schedule,
+* rollover policy, aggregate quota and inactivity policy of services.
 *
-* Currently this should not be live in the kms deployment,
+* Currently service creation should not be live in the kms deployment,
 * and strictly serves as a tool for creating one-off services
 * when running locally to ease the kms Admin experience.
 *
@@ -12,6 +13,9 @@
 * supports the creation of kms services, this can then be leveraged,
 * and be a part of the live kms deployment for Nebula Labs.
 *
+* The settings of existing services are live, as only Admins can set
+* them (see routes/service.go).
+*
 * Reponses are built using responses/service_response.go.
 *
 * Written by Adam Brunn (amb150230) at The University of Texas at Dallas
@@ -24,11 +28,13 @@ import (
 	"context"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/UTDNebula/kms/configs"
 	"github.com/UTDNebula/kms/models"
 	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
@@ -61,6 +67,38 @@ func CreateService() gin.HandlerFunc {
 			return
 		}
 
+		// Verify valid quota time zone and reset hour
+		_, err := configs.LoadQuotaLocation(newService.Timezone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid timezone: Must be an IANA time zone (e.g. America/Chicago)"})
+			return
+		}
+		if newService.ResetHour != nil && (*newService.ResetHour < 0 || *newService.ResetHour > 23) {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid reset_hour: Must be between 0 and 23"})
+			return
+		}
+
+		// Verify valid quota rollover policy
+		if newService.RolloverPercent != nil && (*newService.RolloverPercent < 0 || *newService.RolloverPercent > 100) {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_percent: Must be between 0 and 100"})
+			return
+		}
+		if newService.RolloverCap != nil && *newService.RolloverCap < 0 {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_cap: Must not be negative"})
+			return
+		}
+
+		// Verify valid aggregate quota
+		if newService.AggregateQuota < 0 || newService.AggregateQuotaNumDays < 0 {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid aggregate quota: aggregate_quota and aggregate_quota_num_days must not be negative"})
+			return
+		}
+		if newService.AggregateQuota > 0 && newService.AggregateQuotaNumDays == 0 {
+			newService.AggregateQuotaNumDays = configs.DefaultQuotaNumDays
+		}
+		newService.AggregateUsageRemaining = newService.AggregateQuota
+		newService.AggregateQuotaTimestamp = time.Time{}
+
 		// Generate Service Name
 		if newService.Name == "" {
 			rand.Seed(time.Now().Unix())
@@ -82,7 +120,7 @@ func CreateService() gin.HandlerFunc {
 		newService.UpdatedAt = newService.CreatedAt
 
 		// Insert newService into the database
-		_, err := serviceCollection.InsertOne(ctx, newService)
+		_, err = serviceCollection.InsertOne(ctx, newService)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
 			return
@@ -92,3 +130,534 @@ func CreateService() gin.HandlerFunc {
 		c.JSON(http.StatusCreated, responses.ServiceResponse{Status: http.StatusCreated, Message: "success", Data: newService})
 	}
 }
+
+/**************************************************************************
+* Set Service Aggregate Quota
+* This enables Admins (user_id) to set the aggregate quota of a service
+* (service_id), limiting the requests of all of the service's keys to
+* aggregate_quota every aggregate_quota_num_days (Default: 1).
+*
+* An aggregate_quota of 0 removes the service's aggregate quota.
+* The service's aggregate quota period restarts with its full quota.
+**************************************************************************/
+func SetServiceAggregateQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var service models.Service
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'service_id' field"})
+			return
+		}
+		serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get aggregateQuota
+		aggregateQuotaStr, exists := c.GetQuery("aggregate_quota")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'aggregate_quota' field"})
+			return
+		}
+		aggregateQuota, err := strconv.Atoi(aggregateQuotaStr)
+		if err != nil || aggregateQuota < 0 {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid aggregate_quota: Must not be negative"})
+			return
+		}
+
+		// Get aggregateQuotaNumDays (optional)
+		aggregateQuotaNumDays := configs.DefaultQuotaNumDays
+		aggregateQuotaNumDaysStr, exists := c.GetQuery("aggregate_quota_num_days")
+		if exists {
+			aggregateQuotaNumDays, err = strconv.Atoi(aggregateQuotaNumDaysStr)
+			if err != nil || aggregateQuotaNumDays <= 0 {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid aggregate_quota_num_days: Must be positive"})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.ServiceResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get service
+		err = serviceCollection.FindOne(ctx, bson.M{"_id": serviceID}).Decode(&service)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid service_id: Service does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		before := bson.M{"aggregate_quota": service.AggregateQuota, "aggregate_quota_num_days": service.AggregateQuotaNumDays}
+
+		// Set aggregate quota
+		loc, resetHour := configs.ResolveQuotaSchedule("", nil, service.Timezone, service.ResetHour)
+		service.AggregateQuota = aggregateQuota
+		service.AggregateQuotaNumDays = aggregateQuotaNumDays
+		service.AggregateUsageRemaining = aggregateQuota
+		service.UpdatedAt = time.Now().UTC()
+		service.AggregateQuotaTimestamp = configs.NextQuotaTimestamp(service.UpdatedAt, aggregateQuotaNumDays, loc, resetHour)
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "aggregate_quota", Value: service.AggregateQuota}, {Key: "aggregate_quota_num_days", Value: service.AggregateQuotaNumDays}, {Key: "aggregate_usage_remaining", Value: service.AggregateUsageRemaining}, {Key: "aggregate_quota_timestamp", Value: service.AggregateQuotaTimestamp}, {Key: "updated_at", Value: service.UpdatedAt}}}}
+		_, err = serviceCollection.UpdateOne(ctx, bson.M{"_id": serviceID}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetServiceAggregateQuota", TargetServiceID: service.ID, Before: before, After: bson.M{"aggregate_quota": service.AggregateQuota, "aggregate_quota_num_days": service.AggregateQuotaNumDays}})
+
+		// Respond
+		c.JSON(http.StatusOK, responses.ServiceResponse{Status: http.StatusOK, Message: "success", Data: service})
+	}
+}
+
+/**************************************************************************
+* Set Service Inactivity Policy
+* This enables Admins (user_id) to set the inactivity policy of a service
+* (service_id), disabling the service's keys once they have gone unused
+* for inactivity_days, and warning their owners inactivity_warning_days
+* (optional) beforehand.
+*
+* An inactivity_days of 0 never disables the service's keys. An empty
+* inactivity_days or inactivity_warning_days follows the global policy
+* (KEY_INACTIVITY_DAYS and KEY_INACTIVITY_WARNING_DAYS).
+**************************************************************************/
+func SetServiceInactivityPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var service models.Service
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'service_id' field"})
+			return
+		}
+		serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get inactivityDays
+		inactivityDaysStr, exists := c.GetQuery("inactivity_days")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'inactivity_days' field"})
+			return
+		}
+		var inactivityDays *int
+		if inactivityDaysStr != "" {
+			days, err := strconv.Atoi(inactivityDaysStr)
+			if err != nil || days < 0 {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid inactivity_days: Must not be negative"})
+				return
+			}
+			inactivityDays = &days
+		}
+
+		// Get inactivityWarningDays (optional)
+		var inactivityWarningDays *int
+		if inactivityWarningDaysStr := c.Query("inactivity_warning_days"); inactivityWarningDaysStr != "" {
+			warningDays, err := strconv.Atoi(inactivityWarningDaysStr)
+			if err != nil || warningDays < 0 {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid inactivity_warning_days: Must not be negative"})
+				return
+			}
+			inactivityWarningDays = &warningDays
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.ServiceResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get service
+		err = serviceCollection.FindOne(ctx, bson.M{"_id": serviceID}).Decode(&service)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid service_id: Service does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		before := bson.M{"inactivity_days": service.InactivityDays, "inactivity_warning_days": service.InactivityWarningDays}
+
+		// Set inactivity policy
+		service.InactivityDays = inactivityDays
+		service.InactivityWarningDays = inactivityWarningDays
+		service.UpdatedAt = time.Now().UTC()
+
+		set := bson.D{{Key: "updated_at", Value: service.UpdatedAt}}
+		unset := bson.D{}
+		if inactivityDays != nil {
+			set = append(set, bson.E{Key: "inactivity_days", Value: *inactivityDays})
+		} else {
+			unset = append(unset, bson.E{Key: "inactivity_days", Value: ""})
+		}
+		if inactivityWarningDays != nil {
+			set = append(set, bson.E{Key: "inactivity_warning_days", Value: *inactivityWarningDays})
+		} else {
+			unset = append(unset, bson.E{Key: "inactivity_warning_days", Value: ""})
+		}
+		update := bson.D{{Key: "$set", Value: set}}
+		if len(unset) > 0 {
+			update = append(update, bson.E{Key: "$unset", Value: unset})
+		}
+
+		_, err = serviceCollection.UpdateOne(ctx, bson.M{"_id": serviceID}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetServiceInactivityPolicy", TargetServiceID: service.ID, Before: before, After: bson.M{"inactivity_days": service.InactivityDays, "inactivity_warning_days": service.InactivityWarningDays}})
+
+		// Respond
+		c.JSON(http.StatusOK, responses.ServiceResponse{Status: http.StatusOK, Message: "success", Data: service})
+	}
+}
+
+/**************************************************************************
+* Set Service Quota Schedule
+* This enables Admins (user_id) to set the time zone (timezone) and local
+* reset hour (reset_hour) at which the quotas of a service's (service_id)
+* keys reset, unless the keys set their own.
+*
+* An empty timezone is UTC, and an empty reset_hour is midnight.
+* Keys follow the new schedule from their next quota reset.
+**************************************************************************/
+func SetServiceQuotaSchedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var service models.Service
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'service_id' field"})
+			return
+		}
+		serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get timezone and resetHour (at least one)
+		timezone, timezoneExists := c.GetQuery("timezone")
+		resetHourStr, resetHourExists := c.GetQuery("reset_hour")
+		if !timezoneExists && !resetHourExists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'timezone' or 'reset_hour' field"})
+			return
+		}
+		if timezoneExists {
+			_, err = configs.LoadQuotaLocation(timezone)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid timezone: Must be an IANA time zone (e.g. America/Chicago)"})
+				return
+			}
+		}
+		var resetHour *int
+		if resetHourExists && resetHourStr != "" {
+			hour, err := strconv.Atoi(resetHourStr)
+			if err != nil || hour < 0 || hour > 23 {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid reset_hour: Must be between 0 and 23"})
+				return
+			}
+			resetHour = &hour
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.ServiceResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get service
+		err = serviceCollection.FindOne(ctx, bson.M{"_id": serviceID}).Decode(&service)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid service_id: Service does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		before := bson.M{"timezone": service.Timezone, "reset_hour": service.ResetHour}
+
+		// Set quota schedule
+		if timezoneExists {
+			service.Timezone = timezone
+		}
+		if resetHourExists {
+			service.ResetHour = resetHour
+		}
+		service.UpdatedAt = time.Now().UTC()
+
+		set := bson.D{{Key: "updated_at", Value: service.UpdatedAt}}
+		unset := bson.D{}
+		if service.Timezone != "" {
+			set = append(set, bson.E{Key: "timezone", Value: service.Timezone})
+		} else {
+			unset = append(unset, bson.E{Key: "timezone", Value: ""})
+		}
+		if service.ResetHour != nil {
+			set = append(set, bson.E{Key: "reset_hour", Value: *service.ResetHour})
+		} else {
+			unset = append(unset, bson.E{Key: "reset_hour", Value: ""})
+		}
+		update := bson.D{{Key: "$set", Value: set}}
+		if len(unset) > 0 {
+			update = append(update, bson.E{Key: "$unset", Value: unset})
+		}
+
+		_, err = serviceCollection.UpdateOne(ctx, bson.M{"_id": serviceID}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetServiceQuotaSchedule", TargetServiceID: service.ID, Before: before, After: bson.M{"timezone": service.Timezone, "reset_hour": service.ResetHour}})
+
+		// Respond
+		c.JSON(http.StatusOK, responses.ServiceResponse{Status: http.StatusOK, Message: "success", Data: service})
+	}
+}
+
+/**************************************************************************
+* Set Service Rollover Policy
+* This enables Admins (user_id) to set the percent (rollover_percent) of
+* unused usage the keys of a service (service_id) carry into their next
+* quota period, up to a cap (rollover_cap, Default: the key's quota),
+* unless the keys set their own policy.
+*
+* An empty rollover_percent removes the service's rollover policy.
+* Keys follow the new policy from their next quota reset.
+**************************************************************************/
+func SetServiceRolloverPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var service models.Service
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get serviceID
+		serviceIDQuery, exists := c.GetQuery("service_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'service_id' field"})
+			return
+		}
+		serviceID, err := primitive.ObjectIDFromHex(serviceIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get rolloverPercent
+		rolloverPercentStr, exists := c.GetQuery("rollover_percent")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'rollover_percent' field"})
+			return
+		}
+		var rolloverPercent *int
+		if rolloverPercentStr != "" {
+			percent, err := strconv.Atoi(rolloverPercentStr)
+			if err != nil || percent < 0 || percent > 100 {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_percent: Must be between 0 and 100"})
+				return
+			}
+			rolloverPercent = &percent
+		}
+
+		// Get rolloverCap (optional)
+		var rolloverCap *int
+		if rolloverCapStr := c.Query("rollover_cap"); rolloverCapStr != "" {
+			if rolloverPercent == nil {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_cap: Only a rollover policy can be given a cap"})
+				return
+			}
+			carriedCap, err := strconv.Atoi(rolloverCapStr)
+			if err != nil || carriedCap < 0 {
+				c.JSON(http.StatusBadRequest, responses.ServiceResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid rollover_cap: Must not be negative"})
+				return
+			}
+			rolloverCap = &carriedCap
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check user permissions
+		if user.Type != "Admin" {
+			c.JSON(http.StatusConflict, responses.ServiceResponse{Status: http.StatusConflict, Message: "error", Data: "Invalid user_id: User is not an Admin"})
+			return
+		}
+
+		// Get service
+		err = serviceCollection.FindOne(ctx, bson.M{"_id": serviceID}).Decode(&service)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.ServiceResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid service_id: Service does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		before := bson.M{"rollover_percent": service.RolloverPercent, "rollover_cap": service.RolloverCap}
+
+		// Set rollover policy
+		service.RolloverPercent = rolloverPercent
+		service.RolloverCap = rolloverCap
+		service.UpdatedAt = time.Now().UTC()
+
+		set := bson.D{{Key: "updated_at", Value: service.UpdatedAt}}
+		unset := bson.D{}
+		if rolloverPercent != nil {
+			set = append(set, bson.E{Key: "rollover_percent", Value: *rolloverPercent})
+		} else {
+			unset = append(unset, bson.E{Key: "rollover_percent", Value: ""})
+		}
+		if rolloverCap != nil {
+			set = append(set, bson.E{Key: "rollover_cap", Value: *rolloverCap})
+		} else {
+			unset = append(unset, bson.E{Key: "rollover_cap", Value: ""})
+		}
+		update := bson.D{{Key: "$set", Value: set}}
+		if len(unset) > 0 {
+			update = append(update, bson.E{Key: "$unset", Value: unset})
+		}
+
+		_, err = serviceCollection.UpdateOne(ctx, bson.M{"_id": serviceID}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.ServiceResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetServiceRolloverPolicy", TargetServiceID: service.ID, Before: before, After: bson.M{"rollover_percent": service.RolloverPercent, "rollover_cap": service.RolloverCap}})
+
+		// Respond
+		c.JSON(http.StatusOK, responses.ServiceResponse{Status: http.StatusOK, Message: "success", Data: service})
+	}
+}
@@ -0,0 +1,137 @@
+/**************************************************************************
+* Transaction logic.
+*
+* Flows which write to more than one collection (e.g. a key and its
+* owner's keys) are run within a Mongo session as a single transaction,
+* so a failed write leaves none of the flow's writes applied.
+*
+* The writes of each flow are made through the collection writes below,
+* which abort the transaction should a write match no document.
+*
+* NOTE: Transactions require MongoDB to be deployed as a replica set
+*       or sharded cluster (see configs/env.go).
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Returned from a transaction to abort it should a key have been updated since it was found
+var errKeyOutOfDate = errors.New("Out of date request: Key has been updated")
+
+// Returned from a transaction to abort it should a user no longer exist
+var errUserOutOfDate = errors.New("Out of date request: User no longer exists")
+
+// Writes to a collection within a transaction, which *mongo.Collection satisfies
+type transactionCollection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+/**************************************************************************
+* Run Transaction
+* This runs the given writes (fn) within a transaction, committing them
+* should fn succeed and aborting them should it return an error.
+*
+* The transaction is retried should it fail with a transient error
+* (e.g. a write conflict or failover), so fn may be run more than once.
+* Writes within fn must use the given session context.
+**************************************************************************/
+func runTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	session, err := configs.DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	transactionOptions := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	}, transactionOptions)
+	return err
+}
+
+/**************************************************************************
+* Insert Key Writes
+* This inserts the key (key) and updates the user (userID) given it
+* (updateUser), such as its owner's basic or advanced keys.
+**************************************************************************/
+func insertKeyWrites(ctx context.Context, keys transactionCollection, users transactionCollection, key models.Key, userID primitive.ObjectID, updateUser bson.D) error {
+	_, err := keys.InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+	return updateUserWrite(ctx, users, userID, updateUser)
+}
+
+/**************************************************************************
+* Update Key Write
+* This updates the key matching the filter (keyFilter), returning
+* errKeyOutOfDate should no key be modified, as it has been updated
+* since it was found.
+**************************************************************************/
+func updateKeyWrite(ctx context.Context, keys transactionCollection, keyFilter bson.D, updateKey bson.D) error {
+	result, err := keys.UpdateOne(ctx, keyFilter, updateKey)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return errKeyOutOfDate
+	}
+	return nil
+}
+
+/**************************************************************************
+* Update User Write
+* This updates the user (userID), returning errUserOutOfDate should the
+* user no longer exist.
+**************************************************************************/
+func updateUserWrite(ctx context.Context, users transactionCollection, userID primitive.ObjectID, updateUser bson.D) error {
+	result, err := users.UpdateOne(ctx, bson.D{{Key: "_id", Value: userID}}, updateUser)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errUserOutOfDate
+	}
+	return nil
+}
+
+/**************************************************************************
+* Change Key Holder Writes
+* This sets the key's new owner (key), should the key be unchanged since
+* it was last updated at updatedAt, then moves it from the advanced keys
+* of its previous owner (previousOwnerID) to those of its recipient
+* (recipientID).
+**************************************************************************/
+func changeKeyHolderWrites(ctx context.Context, keys transactionCollection, users transactionCollection, key models.Key, updatedAt time.Time, previousOwnerID primitive.ObjectID, recipientID primitive.ObjectID) error {
+	keyFilter := bson.D{{Key: "_id", Value: key.ID}, {Key: "updated_at", Value: updatedAt}, {Key: "deleted_at", Value: bson.M{"$exists": false}}}
+	updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "owner_id", Value: key.OwnerID}, {Key: "updated_at", Value: key.UpdatedAt}}}}
+	err := updateKeyWrite(ctx, keys, keyFilter, updateKey)
+	if err != nil {
+		return err
+	}
+
+	updatePreviousOwner := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}}}, {Key: "$pull", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+	err = updateUserWrite(ctx, users, previousOwnerID, updatePreviousOwner)
+	if err != nil {
+		return err
+	}
+
+	updateRecipient := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: key.UpdatedAt}}}, {Key: "$push", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+	return updateUserWrite(ctx, users, recipientID, updateRecipient)
+}
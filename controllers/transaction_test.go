@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+)
+
+var errStandInWrite = errors.New("stand-in write failed")
+
+// Storage stand-in which buffers the writes of a transaction, discarding
+// them should the transaction fail
+type standInStore struct {
+	// Writes applied to each document, by collection
+	documents map[string]map[primitive.ObjectID][]interface{}
+
+	// Writes buffered by the running transaction
+	buffered []standInWrite
+
+	// Number of writes made, and the write to fail (0 for none)
+	writes int
+	failOn int
+}
+
+type standInWrite struct {
+	collection string
+	id         primitive.ObjectID
+	write      interface{}
+}
+
+func newStandInStore() *standInStore {
+	return &standInStore{documents: map[string]map[primitive.ObjectID][]interface{}{"keys": {}, "users": {}}}
+}
+
+func (store *standInStore) collection(name string) *standInCollection {
+	return &standInCollection{store: store, name: name}
+}
+
+// Run the writes (fn) as a transaction, applying the buffered writes only should fn succeed
+func (store *standInStore) runTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	store.buffered = nil
+	defer func() { store.buffered = nil }()
+
+	err := fn(ctx)
+	if err != nil {
+		return err
+	}
+	for _, write := range store.buffered {
+		store.documents[write.collection][write.id] = append(store.documents[write.collection][write.id], write.write)
+	}
+	return nil
+}
+
+type standInCollection struct {
+	store *standInStore
+	name  string
+}
+
+func (collection *standInCollection) write(id primitive.ObjectID, write interface{}) error {
+	collection.store.writes++
+	if collection.store.writes == collection.store.failOn {
+		return errStandInWrite
+	}
+	collection.store.buffered = append(collection.store.buffered, standInWrite{collection: collection.name, id: id, write: write})
+	return nil
+}
+
+// Whether the document exists, or is inserted by the running transaction
+func (collection *standInCollection) exists(id primitive.ObjectID) bool {
+	if _, exists := collection.store.documents[collection.name][id]; exists {
+		return true
+	}
+	return slices.ContainsFunc(collection.store.buffered, func(write standInWrite) bool {
+		return write.collection == collection.name && write.id == id
+	})
+}
+
+func (collection *standInCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	id := document.(models.Key).ID
+	if err := collection.write(id, document); err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (collection *standInCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	id := filter.(bson.D).Map()["_id"].(primitive.ObjectID)
+	if !collection.exists(id) {
+		return &mongo.UpdateResult{}, nil
+	}
+	if err := collection.write(id, update); err != nil {
+		return nil, err
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+// Add a user to the store, returning its id
+func (store *standInStore) addUser() primitive.ObjectID {
+	id := primitive.NewObjectID()
+	store.documents["users"][id] = []interface{}{models.User{ID: id}}
+	return id
+}
+
+func assertWrites(t *testing.T, store *standInStore, name string, id primitive.ObjectID, want int) {
+	t.Helper()
+	if got := len(store.documents[name][id]); got != want {
+		t.Errorf("Expected %d writes to %s document %s, got %d", want, name, id.Hex(), got)
+	}
+}
+
+func TestInsertKeyWritesRollBackWhenUserWriteFails(t *testing.T) {
+	store := newStandInStore()
+	userID := store.addUser()
+	key := models.Key{ID: primitive.NewObjectID(), OwnerID: userID}
+	store.failOn = 2
+
+	err := store.runTransaction(context.Background(), func(ctx context.Context) error {
+		updateUser := bson.D{{Key: "$push", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+		return insertKeyWrites(ctx, store.collection("keys"), store.collection("users"), key, userID, updateUser)
+	})
+	if err != errStandInWrite {
+		t.Fatalf("Expected the user write to fail, got %v", err)
+	}
+
+	if _, exists := store.documents["keys"][key.ID]; exists {
+		t.Errorf("Expected key %s not to be inserted", key.ID.Hex())
+	}
+	assertWrites(t, store, "users", userID, 1)
+}
+
+func TestInsertKeyWritesRollBackWhenUserIsMissing(t *testing.T) {
+	store := newStandInStore()
+	userID := primitive.NewObjectID()
+	key := models.Key{ID: primitive.NewObjectID(), OwnerID: userID}
+
+	err := store.runTransaction(context.Background(), func(ctx context.Context) error {
+		updateUser := bson.D{{Key: "$push", Value: bson.D{{Key: "advanced_keys", Value: key.ID}}}}
+		return insertKeyWrites(ctx, store.collection("keys"), store.collection("users"), key, userID, updateUser)
+	})
+	if err != errUserOutOfDate {
+		t.Fatalf("Expected errUserOutOfDate, got %v", err)
+	}
+
+	if _, exists := store.documents["keys"][key.ID]; exists {
+		t.Errorf("Expected key %s not to be inserted", key.ID.Hex())
+	}
+}
+
+func TestKeyAndUserWritesRollBackWhenUserWriteFails(t *testing.T) {
+	store := newStandInStore()
+	userID := store.addUser()
+	keyID := primitive.NewObjectID()
+	store.documents["keys"][keyID] = []interface{}{models.Key{ID: keyID, OwnerID: userID}}
+	store.failOn = 2
+
+	// As when deleting a key
+	err := store.runTransaction(context.Background(), func(ctx context.Context) error {
+		updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: "now"}}}}
+		err := updateKeyWrite(ctx, store.collection("keys"), bson.D{{Key: "_id", Value: keyID}}, updateKey)
+		if err != nil {
+			return err
+		}
+
+		updateOwnerUser := bson.D{{Key: "$pull", Value: bson.D{{Key: "advanced_keys", Value: keyID}}}}
+		return updateUserWrite(ctx, store.collection("users"), userID, updateOwnerUser)
+	})
+	if err != errStandInWrite {
+		t.Fatalf("Expected the user write to fail, got %v", err)
+	}
+
+	assertWrites(t, store, "keys", keyID, 1)
+	assertWrites(t, store, "users", userID, 1)
+}
+
+func TestKeyAndUserWritesApplyTogether(t *testing.T) {
+	store := newStandInStore()
+	userID := store.addUser()
+	keyID := primitive.NewObjectID()
+	store.documents["keys"][keyID] = []interface{}{models.Key{ID: keyID, OwnerID: userID}}
+
+	err := store.runTransaction(context.Background(), func(ctx context.Context) error {
+		updateKey := bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: "now"}}}}
+		err := updateKeyWrite(ctx, store.collection("keys"), bson.D{{Key: "_id", Value: keyID}}, updateKey)
+		if err != nil {
+			return err
+		}
+
+		updateOwnerUser := bson.D{{Key: "$pull", Value: bson.D{{Key: "advanced_keys", Value: keyID}}}}
+		return updateUserWrite(ctx, store.collection("users"), userID, updateOwnerUser)
+	})
+	if err != nil {
+		t.Fatalf("Expected the writes to succeed, got %v", err)
+	}
+
+	assertWrites(t, store, "keys", keyID, 2)
+	assertWrites(t, store, "users", userID, 2)
+}
+
+func TestChangeKeyHolderWritesRollBackWhenUserWriteFails(t *testing.T) {
+	for _, failOn := range []int{2, 3} {
+		store := newStandInStore()
+		previousOwnerID := store.addUser()
+		recipientID := store.addUser()
+		key := models.Key{ID: primitive.NewObjectID(), OwnerID: previousOwnerID, UpdatedAt: time.Now().UTC()}
+		store.documents["keys"][key.ID] = []interface{}{key}
+		store.failOn = failOn
+
+		err := store.runTransaction(context.Background(), func(ctx context.Context) error {
+			updatedKey := key
+			updatedKey.OwnerID = recipientID
+			updatedKey.UpdatedAt = time.Now().UTC()
+			return changeKeyHolderWrites(ctx, store.collection("keys"), store.collection("users"), updatedKey, key.UpdatedAt, previousOwnerID, recipientID)
+		})
+		if err != errStandInWrite {
+			t.Fatalf("Expected write %d to fail, got %v", failOn, err)
+		}
+
+		assertWrites(t, store, "keys", key.ID, 1)
+		assertWrites(t, store, "users", previousOwnerID, 1)
+		assertWrites(t, store, "users", recipientID, 1)
+	}
+}
+
+func TestChangeKeyHolderWritesApplyTogether(t *testing.T) {
+	store := newStandInStore()
+	previousOwnerID := store.addUser()
+	recipientID := store.addUser()
+	key := models.Key{ID: primitive.NewObjectID(), OwnerID: previousOwnerID, UpdatedAt: time.Now().UTC()}
+	store.documents["keys"][key.ID] = []interface{}{key}
+
+	err := store.runTransaction(context.Background(), func(ctx context.Context) error {
+		updatedKey := key
+		updatedKey.OwnerID = recipientID
+		updatedKey.UpdatedAt = time.Now().UTC()
+		return changeKeyHolderWrites(ctx, store.collection("keys"), store.collection("users"), updatedKey, key.UpdatedAt, previousOwnerID, recipientID)
+	})
+	if err != nil {
+		t.Fatalf("Expected the writes to succeed, got %v", err)
+	}
+
+	assertWrites(t, store, "keys", key.ID, 2)
+	assertWrites(t, store, "users", previousOwnerID, 2)
+	assertWrites(t, store, "users", recipientID, 2)
+}
@@ -0,0 +1,174 @@
+/**************************************************************************
+* Usage history endpoint logic.
+*
+* Before a key's Fixed quota period is reset, whether by the batch
+* refresh (configs.RefreshUsageRemainingOperation) or lazily when the
+* key is consumed, the key's consumption over the period is recorded in
+* the 'usage_history' collection.
+*
+* Key owners, Leads of the key's service, and Admins can view a key's
+* usage history.
+*
+* Reponses are built using responses/key_response.go.
+**************************************************************************/
+
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"github.com/UTDNebula/kms/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+var usageHistoryCollection *mongo.Collection = configs.GetCollection(configs.DB, "usage_history")
+
+// Number of days of usage history returned when no range is given
+const defaultUsageHistoryDays = 30
+
+/**************************************************************************
+* Record Usage History
+* This records the key's consumption over its quota period ending at
+* its quota_timestamp, given the key before the period was reset.
+*
+* Failing to record history does not undo the reset, so errors are
+* logged rather than returned.
+**************************************************************************/
+func recordUsageHistory(ctx context.Context, key models.Key, loc *time.Location, now time.Time) {
+	historyModel := configs.UsageHistoryWriteModel(key.ID, key.QuotaTimestamp, key.QuotaNumDays, loc, key.Quota, key.RolloverCarried, key.UsageRemaining, now)
+	_, err := usageHistoryCollection.BulkWrite(ctx, []mongo.WriteModel{historyModel})
+	if err != nil {
+		log.Printf("Unable to record usage history of key %s: %v", key.ID.Hex(), err)
+	}
+}
+
+/**************************************************************************
+* Get Key Usage History
+* This returns the consumption of the key (key_id) over each of its past
+* quota periods ending within the given range (from, to), oldest first,
+* to the given user (user_id).
+*
+* The range defaults to the last 30 days.
+*
+* Key owners can view the history of their own keys.
+* Admins can view the history of any key.
+* Leads can only view the history of advanced keys
+* for services they are leads for.
+**************************************************************************/
+func GetKeyUsageHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+		var key models.Key
+		var history []models.UsagePeriod
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get keyID
+		keyIDQuery, exists := c.GetQuery("key_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'key_id' field"})
+			return
+		}
+		keyID, err := primitive.ObjectIDFromHex(keyIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get to (optional)
+		to := time.Now().UTC()
+		toQuery, exists := c.GetQuery("to")
+		if exists {
+			to, err = time.Parse(configs.DateLayout, toQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		// Get from (optional)
+		from := to.AddDate(0, 0, -defaultUsageHistoryDays)
+		fromQuery, exists := c.GetQuery("from")
+		if exists {
+			from, err = time.Parse(configs.DateLayout, fromQuery)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+		if from.After(to) {
+			c.JSON(http.StatusBadRequest, responses.KeyResponse{Status: http.StatusBadRequest, Message: "error", Data: "Invalid range: 'from' must not be after 'to'"})
+			return
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get key
+		err = keyCollection.FindOne(ctx, bson.M{"_id": keyID}).Decode(&key)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.KeyResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid key_id: Key does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Check if user is the key's owner, an Admin, or a lead of the key's service
+		if key.OwnerID != userID && user.Type != "Admin" && (key.Type != "Advanced" || user.Type != "Lead" || !slices.Contains(user.Services, key.ServiceID)) {
+			c.JSON(http.StatusConflict, responses.KeyResponse{Status: http.StatusConflict, Message: "error", Data: "The given user does not have the authority to view the usage history of this key"})
+			return
+		}
+
+		// Find usage history, oldest first
+		filter := bson.D{{Key: "key_id", Value: keyID}, {Key: "period_end", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}}}
+		cursor, err := usageHistoryCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "period_end", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+		history = []models.UsagePeriod{}
+		err = cursor.All(ctx, &history)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.KeyResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Respond
+		c.JSON(http.StatusOK, responses.KeyResponse{Status: http.StatusOK, Message: "success", Data: history})
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/mail"
 	"time"
 
 	"github.com/UTDNebula/kms/configs"
@@ -37,15 +38,42 @@ import (
 
 var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users")
 
+// A service along with its keys, as aggregated by GetPrivilegedUserData
+type privilegedService struct {
+	Keys    []privilegedKey `bson:"keys"`
+	Service bson.M          `bson:",inline"`
+}
+
+// A key along with its owner, as aggregated by GetPrivilegedUserData
+type privilegedKey struct {
+	Key   models.Key         `bson:",inline"`
+	Owner privilegedKeyOwner `bson:"owner"`
+}
+
+// The owner of a key, as returned by GetPrivilegedUserData
+type privilegedKeyOwner struct {
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	PlatformUserID primitive.ObjectID `json:"platform_user_id" bson:"platform_user_id"`
+	Type           string             `json:"user_type" bson:"user_type"`
+}
+
 /**************************************************************************
 * Get User Keys
-* This returns the user's (user_id) basic and advanced keys.
+* This returns the user's (user_id) live and test basic keys and advanced
+* keys.
+*
+* The keys themselves are hidden, and can only be obtained through the
+* claim and reveal flows (see controllers/key_reveal.go).
 **************************************************************************/
 func GetUserKeys() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		var userID primitive.ObjectID
-		var userKeys map[string]interface{}
+		var userKeys struct {
+			BasicKey     *models.Key  `bson:"basic_key"`
+			TestBasicKey *models.Key  `bson:"test_basic_key"`
+			AdvancedKeys []models.Key `bson:"advanced_keys"`
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -67,9 +95,11 @@ func GetUserKeys() gin.HandlerFunc {
 		lookupAdvancedKeys := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "keys"}, {Key: "localField", Value: "advanced_keys"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "advanced_keys"}}}}
 		lookupBasicKey := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "keys"}, {Key: "localField", Value: "basic_key"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "basic_key"}}}}
 		unwindBasicKey := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$basic_key"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
-		projectKeys := bson.D{{Key: "$project", Value: bson.D{{Key: "basic_key", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$basic_key", primitive.Null{}}}}}, {Key: "advanced_keys", Value: 1}}}}
+		lookupTestBasicKey := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "keys"}, {Key: "localField", Value: "test_basic_key"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "test_basic_key"}}}}
+		unwindTestBasicKey := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$test_basic_key"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
+		projectKeys := bson.D{{Key: "$project", Value: bson.D{{Key: "basic_key", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$basic_key", primitive.Null{}}}}}, {Key: "test_basic_key", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$test_basic_key", primitive.Null{}}}}}, {Key: "advanced_keys", Value: 1}}}}
 
-		aggregationPipeline := bson.A{matchOnUserID, lookupAdvancedKeys, lookupBasicKey, unwindBasicKey, projectKeys}
+		aggregationPipeline := bson.A{matchOnUserID, lookupAdvancedKeys, lookupBasicKey, unwindBasicKey, lookupTestBasicKey, unwindTestBasicKey, projectKeys}
 
 		// Preform aggregation
 		cursor, err := userCollection.Aggregate(ctx, aggregationPipeline)
@@ -91,8 +121,28 @@ func GetUserKeys() gin.HandlerFunc {
 			return
 		}
 
+		// Hide the actual keys
+		res := struct {
+			BasicKey     *responses.KeyData  `json:"basic_key"`
+			TestBasicKey *responses.KeyData  `json:"test_basic_key"`
+			AdvancedKeys []responses.KeyData `json:"advanced_keys"`
+		}{
+			AdvancedKeys: []responses.KeyData{},
+		}
+		if userKeys.BasicKey != nil {
+			basicKey := responses.NewKeyData(*userKeys.BasicKey, true)
+			res.BasicKey = &basicKey
+		}
+		if userKeys.TestBasicKey != nil {
+			testBasicKey := responses.NewKeyData(*userKeys.TestBasicKey, true)
+			res.TestBasicKey = &testBasicKey
+		}
+		for _, key := range userKeys.AdvancedKeys {
+			res.AdvancedKeys = append(res.AdvancedKeys, responses.NewKeyData(key, true))
+		}
+
 		// Respond
-		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: userKeys})
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: res})
 	}
 }
 
@@ -159,8 +209,10 @@ func GetPrivilegedUserData() gin.HandlerFunc {
 		var cursor *mongo.Cursor
 		var err error
 
-		var res []map[string]interface{}
-		var out interface{}
+		var res []struct {
+			Services []privilegedService `bson:"services"`
+		}
+		var out []bson.M
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -184,7 +236,7 @@ func GetPrivilegedUserData() gin.HandlerFunc {
 		}
 
 		// Admin Aggregation Pipeline Only
-		projectServiceDetails := bson.D{{Key: "$project", Value: bson.D{{Key: "services._id", Value: "$_id"}, {Key: "services.service_name", Value: "$service_name"}, {Key: "services.service_type", Value: "$service_type"}, {Key: "services.created_at", Value: "$created_at"}, {Key: "services.updated_at", Value: "$updated_at"}, {Key: "services.source_identifiers", Value: "$source_identifiers"}}}}
+		projectServiceDetails := bson.D{{Key: "$project", Value: bson.D{{Key: "services._id", Value: "$_id"}, {Key: "services.service_name", Value: "$service_name"}, {Key: "services.service_type", Value: "$service_type"}, {Key: "services.created_at", Value: "$created_at"}, {Key: "services.updated_at", Value: "$updated_at"}, {Key: "services.source_identifiers", Value: "$source_identifiers"}, {Key: "services.timezone", Value: "$timezone"}, {Key: "services.reset_hour", Value: "$reset_hour"}, {Key: "services.rollover_percent", Value: "$rollover_percent"}, {Key: "services.rollover_cap", Value: "$rollover_cap"}, {Key: "services.inactivity_days", Value: "$inactivity_days"}, {Key: "services.inactivity_warning_days", Value: "$inactivity_warning_days"}, {Key: "services.aggregate_quota", Value: "$aggregate_quota"}, {Key: "services.aggregate_quota_num_days", Value: "$aggregate_quota_num_days"}, {Key: "services.aggregate_usage_remaining", Value: "$aggregate_usage_remaining"}, {Key: "services.aggregate_quota_timestamp", Value: "$aggregate_quota_timestamp"}}}}
 
 		// Lead Aggregation Pipeline Only
 		matchLead := bson.D{{Key: "$match", Value: bson.D{{Key: "_id", Value: userID}}}}
@@ -192,17 +244,17 @@ func GetPrivilegedUserData() gin.HandlerFunc {
 		projectServices := bson.D{{Key: "$project", Value: bson.D{{Key: "services", Value: 1}}}}
 		unwindServices := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$services"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
 		// -- LookupKeys
-		projectKeysLead := bson.D{{Key: "$project", Value: bson.D{{Key: "services._id", Value: 1}, {Key: "services.service_name", Value: 1}, {Key: "services.service_type", Value: 1}, {Key: "services.created_at", Value: 1}, {Key: "services.updated_at", Value: 1}, {Key: "services.source_identifiers", Value: 1}, {Key: "keys._id", Value: 1}, {Key: "keys.key", Value: "_HIDDEN_"}, {Key: "keys.key_type", Value: 1}, {Key: "keys.name", Value: 1}, {Key: "keys.owner_id", Value: 1}, {Key: "keys.service_id", Value: 1}, {Key: "keys.quota", Value: 1}, {Key: "keys.quota_type", Value: 1}, {Key: "keys.usage_remaining", Value: 1}, {Key: "keys.quota_timestamp", Value: 1}, {Key: "keys.created_at", Value: 1}, {Key: "keys.updated_at", Value: 1}, {Key: "keys.is_active", Value: 1}}}}
 
 		// Both Lead and Admin Aggregation Pipelines
-		lookupKeys := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "keys"}, {Key: "localField", Value: "services._id"}, {Key: "foreignField", Value: "service_id"}, {Key: "as", Value: "keys"}}}}
+		// @INFO: Deleted keys are excluded until they are restored
+		lookupKeys := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "keys"}, {Key: "localField", Value: "services._id"}, {Key: "foreignField", Value: "service_id"}, {Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}}}}}}, {Key: "as", Value: "keys"}}}}
 		unwindKeys := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$keys"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}}
 		lookupOwner := bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "users"}, {Key: "localField", Value: "keys.owner_id"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "owner"}}}}
 		projectOwner := bson.D{{Key: "$project", Value: bson.D{{Key: "services", Value: 1}, {Key: "keys", Value: 1}, {Key: "owner._id", Value: 1}, {Key: "owner.platform_user_id", Value: 1}, {Key: "owner.user_type", Value: 1}}}}
 		unwindOwner := bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$owner"}, {Key: "preserveNullAndEmptyArrays", Value: false}}}}
-		projectOwnerIntoKey := bson.D{{Key: "$project", Value: bson.D{{Key: "services._id", Value: 1}, {Key: "services.service_name", Value: 1}, {Key: "services.service_type", Value: 1}, {Key: "services.created_at", Value: 1}, {Key: "services.updated_at", Value: 1}, {Key: "services.source_identifiers", Value: 1}, {Key: "keys._id", Value: 1}, {Key: "keys.key", Value: 1}, {Key: "keys.key_type", Value: 1}, {Key: "keys.name", Value: 1}, {Key: "keys.owner_id", Value: 1}, {Key: "keys.service_id", Value: 1}, {Key: "keys.quota", Value: 1}, {Key: "keys.quota_type", Value: 1}, {Key: "keys.usage_remaining", Value: 1}, {Key: "keys.quota_timestamp", Value: 1}, {Key: "keys.created_at", Value: 1}, {Key: "keys.updated_at", Value: 1}, {Key: "keys.is_active", Value: 1}, {Key: "keys.owner", Value: "$owner"}}}}
+		setOwnerIntoKey := bson.D{{Key: "$set", Value: bson.D{{Key: "keys.owner", Value: "$owner"}}}}
 		groupKeys := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$services._id"}, {Key: "services", Value: bson.D{{Key: "$first", Value: "$services"}}}, {Key: "keys", Value: bson.D{{Key: "$push", Value: "$keys"}}}}}}
-		projectKeysIntoService := bson.D{{Key: "$project", Value: bson.D{{Key: "services._id", Value: 1}, {Key: "services.service_name", Value: 1}, {Key: "services.service_type", Value: 1}, {Key: "services.created_at", Value: 1}, {Key: "services.updated_at", Value: 1}, {Key: "services.source_identifiers", Value: 1}, {Key: "services.keys", Value: "$keys"}}}}
+		projectKeysIntoService := bson.D{{Key: "$project", Value: bson.D{{Key: "services._id", Value: 1}, {Key: "services.service_name", Value: 1}, {Key: "services.service_type", Value: 1}, {Key: "services.created_at", Value: 1}, {Key: "services.updated_at", Value: 1}, {Key: "services.source_identifiers", Value: 1}, {Key: "services.timezone", Value: 1}, {Key: "services.reset_hour", Value: 1}, {Key: "services.rollover_percent", Value: 1}, {Key: "services.rollover_cap", Value: 1}, {Key: "services.inactivity_days", Value: 1}, {Key: "services.inactivity_warning_days", Value: 1}, {Key: "services.aggregate_quota", Value: 1}, {Key: "services.aggregate_quota_num_days", Value: 1}, {Key: "services.aggregate_usage_remaining", Value: 1}, {Key: "services.aggregate_quota_timestamp", Value: 1}, {Key: "services.keys", Value: "$keys"}}}}
 		groupServices := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: primitive.Null{}}, {Key: "services", Value: bson.D{{Key: "$push", Value: "$services"}}}}}}
 
 		// The Difference between these two aggregation pipelines is that:
@@ -210,13 +262,13 @@ func GetPrivilegedUserData() gin.HandlerFunc {
 		// - Is performed on the services collection
 		// - Displays all services -> keys -> users
 		// - Includes keys
-		pipelineAdminAggregation := bson.A{projectServiceDetails, lookupKeys, unwindKeys, lookupOwner, projectOwner, unwindOwner, projectOwnerIntoKey, groupKeys, projectKeysIntoService, groupServices}
+		pipelineAdminAggregation := bson.A{projectServiceDetails, lookupKeys, unwindKeys, lookupOwner, projectOwner, unwindOwner, setOwnerIntoKey, groupKeys, projectKeysIntoService, groupServices}
 
 		// The Lead aggregation:
 		// - Is performed on the user collection
 		// - Displays led services -> keys -> users
 		// - Hides keys
-		pipelineLeadAggregation := bson.A{matchLead, lookupServices, projectServices, unwindServices, lookupKeys, projectKeysLead, unwindKeys, lookupOwner, projectOwner, unwindOwner, projectOwnerIntoKey, groupKeys, projectKeysIntoService, groupServices}
+		pipelineLeadAggregation := bson.A{matchLead, lookupServices, projectServices, unwindServices, lookupKeys, unwindKeys, lookupOwner, projectOwner, unwindOwner, setOwnerIntoKey, groupKeys, projectKeysIntoService, groupServices}
 
 		// Determine course of action by checking user type
 		if user.Type == "Admin" {
@@ -253,8 +305,21 @@ func GetPrivilegedUserData() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: "Error: No data returned from aggregation"})
 			return
 		} else {
-			// Grab aggregation result
-			out = res[0]["services"]
+			// Grab aggregation result, hiding the actual keys from Leads
+			for _, service := range res[0].Services {
+				keys := []interface{}{}
+				for _, key := range service.Keys {
+					keys = append(keys, struct {
+						responses.KeyData
+						Owner privilegedKeyOwner `json:"owner"`
+					}{
+						KeyData: responses.NewKeyData(key.Key, user.Type != "Admin"),
+						Owner:   key.Owner,
+					})
+				}
+				service.Service["keys"] = keys
+				out = append(out, service.Service)
+			}
 		}
 
 		// Respond
@@ -269,6 +334,9 @@ func GetPrivilegedUserData() gin.HandlerFunc {
 *
 * Generally this should be called by the developer portal backend
 * once the user wishes to access it's features.
+*
+* An email address (email) can optionally be given, to which
+* notifications are sent when using the smtp notifier.
 **************************************************************************/
 func CreateUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -290,12 +358,21 @@ func CreateUser() gin.HandlerFunc {
 			return
 		}
 
+		// Verify valid email (optional)
+		if newUser.Email != "" {
+			if err := validateEmail(newUser.Email); err != nil {
+				c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
 		// Build newUser
 		newUser.ID = primitive.NewObjectID()
 		newUser.Type = "Developer"
 		newUser.CreatedAt = time.Now()
 		newUser.UpdatedAt = newUser.CreatedAt
 		newUser.BasicKey = primitive.NilObjectID
+		newUser.TestBasicKey = primitive.NilObjectID
 		newUser.AdvancedKeys = []primitive.ObjectID{}
 		newUser.Services = nil
 
@@ -317,7 +394,96 @@ func CreateUser() gin.HandlerFunc {
 			return
 		}
 
+		// Record audit event
+		// @INFO: Users create their own kms profile, so the new user is the actor
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: newUser.ID, Action: "CreateUser", TargetUserID: newUser.ID, After: bson.M{"platform_user_id": newUser.PlatformUserID, "user_type": newUser.Type, "email": newUser.Email}})
+
 		// Return newUser
 		c.JSON(http.StatusCreated, responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: newUser})
 	}
 }
+
+/**************************************************************************
+* Validate Email
+* This verifies the given email is a bare email address
+* (e.g. dev@utdnebula.com), to which notifications can be sent.
+**************************************************************************/
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("Invalid email: Must be an email address (e.g. dev@utdnebula.com)")
+	}
+	return nil
+}
+
+/**************************************************************************
+* Set User Email
+* This sets the email address (email) of the user (user_id), to which
+* notifications are sent when using the smtp notifier.
+*
+* An empty email removes the user's email address.
+**************************************************************************/
+func SetUserEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		var user models.User
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Get userID
+		userIDQuery, exists := c.GetQuery("user_id")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'user_id' field"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(userIDQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Get email
+		email, exists := c.GetQuery("email")
+		if !exists {
+			c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: "Request must include the 'email' field"})
+			return
+		}
+		if email != "" {
+			if err := validateEmail(email); err != nil {
+				c.JSON(http.StatusBadRequest, responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: err.Error()})
+				return
+			}
+		}
+
+		// Get user
+		err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: "Invalid user_id: User does not exist"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Set email
+		// @INFO: An empty email is removed, so the user is no longer emailed
+		user.UpdatedAt = time.Now().UTC()
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: user.UpdatedAt}, {Key: "email", Value: email}}}}
+		if email == "" {
+			update = bson.D{{Key: "$set", Value: bson.D{{Key: "updated_at", Value: user.UpdatedAt}}}, {Key: "$unset", Value: bson.D{{Key: "email", Value: ""}}}}
+		}
+		_, err = userCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: err.Error()})
+			return
+		}
+
+		// Record audit event
+		recordAuditEvent(ctx, c, models.AuditEvent{ActorUserID: userID, Action: "SetUserEmail", TargetUserID: userID, Before: bson.M{"email": user.Email}, After: bson.M{"email": email}})
+
+		// Respond with formated user.UpdatedAt time
+		c.JSON(http.StatusOK, responses.UserResponse{Status: http.StatusOK, Message: "success", Data: user.UpdatedAt.Format(configs.DateLayout)})
+	}
+}
@@ -0,0 +1,272 @@
+/**************************************************************************
+* Background job scheduler.
+*
+* Jobs are periodic operations (such as refreshing key quotas) which
+* must only run once per scheduled time, even when several kms replicas
+* are running. Before running a job, a replica acquires the job's lease
+* in the 'job_leases' collection. The lease records the scheduled time
+* it was acquired for, so other replicas skip that scheduled time.
+*
+* Failed runs are retried with exponential backoff, and every run is
+* recorded in the 'job_runs' collection with its outcome and duration.
+*
+* Jobs are registered with Register before calling Start, and can be
+* run on demand with Trigger.
+**************************************************************************/
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var leaseCollection *mongo.Collection = configs.GetCollection(configs.DB, "job_leases")
+var runCollection *mongo.Collection = configs.GetCollection(configs.DB, "job_runs")
+
+// Identifies this replica as the holder of job leases
+var instanceID string = newInstanceID()
+
+var ErrUnknownJob = errors.New("unknown job")
+
+// Retry and lease configuration
+const maxAttempts = 5
+const initialBackoff = time.Second
+const maxBackoff = time.Minute
+const defaultTimeout = time.Minute
+
+// Job represents a periodic operation
+type Job struct {
+	Name       string
+	Next       func(time.Time) time.Time // Returns the next scheduled time after the given time
+	Run        func(ctx context.Context) error
+	Timeout    time.Duration // Timeout of each attempt (Default: 1 minute)
+	RunOnStart bool          // Run once when the scheduler starts, to catch up on missed runs
+
+	triggers chan primitive.ObjectID
+}
+
+var registeredJobs = map[string]*Job{}
+
+// Register a job to be run once the scheduler starts
+func Register(job Job) {
+	if job.Timeout == 0 {
+		job.Timeout = defaultTimeout
+	}
+	job.triggers = make(chan primitive.ObjectID, 1)
+	registeredJobs[job.Name] = &job
+}
+
+// Start running all registered jobs in the background
+func Start() {
+	for _, job := range registeredJobs {
+		go schedule(job)
+	}
+}
+
+/**************************************************************************
+* Trigger
+* This requests a manual run of the job by the given Admin user.
+* The run happens in the background; its outcome can be retrieved
+* with LatestRun once it finishes.
+**************************************************************************/
+func Trigger(name string, userID primitive.ObjectID) error {
+	job, exists := registeredJobs[name]
+	if !exists {
+		return ErrUnknownJob
+	}
+
+	select {
+	case job.triggers <- userID:
+		return nil
+	default:
+		return fmt.Errorf("a manual run of '%s' is already pending", name)
+	}
+}
+
+/**************************************************************************
+* Latest Run
+* This returns the most recent run of the job across all replicas.
+**************************************************************************/
+func LatestRun(ctx context.Context, name string) (models.JobRun, error) {
+	var run models.JobRun
+
+	if _, exists := registeredJobs[name]; !exists {
+		return run, ErrUnknownJob
+	}
+
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "started_at", Value: -1}})
+	err := runCollection.FindOne(ctx, bson.M{"job_name": name}, findOptions).Decode(&run)
+	return run, err
+}
+
+// Run the job at each scheduled time, and whenever triggered
+func schedule(job *Job) {
+	if job.RunOnStart {
+		run(job, "Startup", time.Time{}, primitive.NilObjectID)
+	}
+
+	for {
+		next := job.Next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-timer.C:
+			run(job, "Scheduled", next, primitive.NilObjectID)
+		case userID := <-job.triggers:
+			timer.Stop()
+			run(job, "Manual", time.Time{}, userID)
+		}
+	}
+}
+
+/**************************************************************************
+* Run
+* This runs the job provided its lease can be acquired, retrying with
+* exponential backoff on failure, and records the run.
+*
+* Scheduled runs pass the time they were scheduled for, which is only
+* run once across all replicas.
+**************************************************************************/
+func run(job *Job, trigger string, scheduledFor time.Time, triggeredBy primitive.ObjectID) {
+	jobRun := models.JobRun{
+		ID:          primitive.NewObjectID(),
+		JobName:     job.Name,
+		Trigger:     trigger,
+		TriggeredBy: triggeredBy,
+		Holder:      instanceID,
+		StartedAt:   time.Now().UTC(),
+	}
+
+	// The lease covers every attempt and the backoff between them
+	leaseDuration := maxAttempts*job.Timeout + maxAttempts*maxBackoff
+
+	acquired, err := acquireLease(job.Name, scheduledFor, leaseDuration)
+	if err != nil {
+		log.Printf("Unable to acquire lease for job '%s': %v", job.Name, err)
+		return
+	}
+	if !acquired {
+		// Scheduled runs are expected to be skipped by all but one replica
+		if trigger == "Manual" {
+			jobRun.Outcome = "Skipped"
+			jobRun.Error = "The job is already running on another replica"
+			recordRun(jobRun)
+		}
+		return
+	}
+	defer releaseLease(job.Name)
+
+	// Run with retries
+	backoff := initialBackoff
+	for jobRun.Attempts = 1; ; jobRun.Attempts++ {
+		ctx, cancel := context.WithTimeout(context.Background(), job.Timeout)
+		err = job.Run(ctx)
+		cancel()
+
+		if err == nil || jobRun.Attempts == maxAttempts {
+			break
+		}
+
+		log.Printf("Job '%s' failed on attempt %d, retrying in %s: %v", job.Name, jobRun.Attempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	jobRun.Outcome = "Succeeded"
+	if err != nil {
+		jobRun.Outcome = "Failed"
+		jobRun.Error = err.Error()
+		log.Printf("Job '%s' failed after %d attempts: %v", job.Name, jobRun.Attempts, err)
+	}
+	recordRun(jobRun)
+}
+
+// Record a finished run of a job
+func recordRun(jobRun models.JobRun) {
+	jobRun.FinishedAt = time.Now().UTC()
+	jobRun.DurationMS = jobRun.FinishedAt.Sub(jobRun.StartedAt).Milliseconds()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := runCollection.InsertOne(ctx, jobRun)
+	if err != nil {
+		log.Printf("Unable to record run of job '%s': %v", jobRun.JobName, err)
+	}
+}
+
+/**************************************************************************
+* Acquire Lease
+* This acquires the job's lease for this replica, provided no other
+* replica holds an unexpired lease and, for scheduled runs, the
+* scheduled time has not already been run.
+**************************************************************************/
+func acquireLease(name string, scheduledFor time.Time, leaseDuration time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "$or", Value: bson.A{bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lte", Value: now}}}}, bson.D{{Key: "holder", Value: instanceID}}}}}
+	set := bson.D{{Key: "holder", Value: instanceID}, {Key: "expires_at", Value: now.Add(leaseDuration)}}
+	if !scheduledFor.IsZero() {
+		filter = append(filter, bson.E{Key: "last_scheduled_for", Value: bson.D{{Key: "$ne", Value: scheduledFor}}})
+		set = append(set, bson.E{Key: "last_scheduled_for", Value: scheduledFor})
+	}
+
+	// @INFO: Should the filter not match an existing lease, the upsert fails on the duplicate _id
+	_, err := leaseCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}}, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Release the job's lease, should this replica hold it
+func releaseLease(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "holder", Value: instanceID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: time.Now().UTC()}}}}
+	_, err := leaseCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Printf("Unable to release lease for job '%s': %v", name, err)
+	}
+}
+
+// Build a unique identifier for this replica
+// @INFO: The ObjectID keeps replicas with the same hostname and pid (e.g. containers) apart
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// Schedule a job every interval, aligned to multiples of the interval since midnight UTC
+func Every(interval time.Duration) func(time.Time) time.Time {
+	return func(t time.Time) time.Time {
+		return t.UTC().Truncate(interval).Add(interval)
+	}
+}
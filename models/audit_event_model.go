@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent represents a single management action performed in the KMS.
+// Audit events are append-only and are never updated or deleted.
+type AuditEvent struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	ActorUserID primitive.ObjectID `json:"actor_user_id" bson:"actor_user_id"`
+	Action      string             `json:"action" bson:"action"` // Name of the controller performing the action (e.g. DisableKey)
+
+	// Targets of the action, only those relevant to the action are set
+	TargetKeyID     primitive.ObjectID `json:"target_key_id,omitempty" bson:"target_key_id,omitempty"`
+	TargetUserID    primitive.ObjectID `json:"target_user_id,omitempty" bson:"target_user_id,omitempty"`
+	TargetServiceID primitive.ObjectID `json:"target_service_id,omitempty" bson:"target_service_id,omitempty"`
+
+	// Service the target key was moved from, should the action move it to TargetServiceID
+	PreviousTargetServiceID primitive.ObjectID `json:"previous_target_service_id,omitempty" bson:"previous_target_service_id,omitempty"`
+
+	// Values of the changed fields before and after the action
+	Before map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+
+	RequestMetadata AuditRequestMetadata `json:"request_metadata" bson:"request_metadata"`
+	CreatedAt       time.Time            `json:"created_at" bson:"created_at"`
+}
+
+// AuditRequestMetadata represents data about the request which caused an AuditEvent
+type AuditRequestMetadata struct {
+	Method    string `json:"method" bson:"method"`
+	Path      string `json:"path" bson:"path"`
+	ClientIP  string `json:"client_ip" bson:"client_ip"`
+	UserAgent string `json:"user_agent" bson:"user_agent"`
+}
+
+func (e AuditEvent) MarshalJSON() ([]byte, error) {
+	type Alias AuditEvent
+	return json.Marshal(&struct {
+		CreatedAt string `json:"created_at"`
+		Alias
+	}{
+		// use the desired date layout
+		CreatedAt: e.CreatedAt.Format(configs.DateLayout),
+		Alias:     Alias(e),
+	})
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConsistencyReport represents the violations found by a consistency check
+// of the users, keys, and services collections, and any repairs applied
+type ConsistencyReport struct {
+	ID          primitive.ObjectID     `json:"_id" bson:"_id"`
+	ActorUserID primitive.ObjectID     `json:"actor_user_id" bson:"actor_user_id"`
+	Fix         bool                   `json:"fix" bson:"fix"`       // Whether safe repairs were applied
+	Counts      map[string]int         `json:"counts" bson:"counts"` // Number of violations of each category
+	Repaired    int                    `json:"repaired" bson:"repaired"`
+	Violations  []ConsistencyViolation `json:"violations" bson:"violations"`
+	CreatedAt   time.Time              `json:"created_at" bson:"created_at"`
+}
+
+func (r ConsistencyReport) MarshalJSON() ([]byte, error) {
+	type Alias ConsistencyReport
+	return json.Marshal(&struct {
+		CreatedAt string `json:"created_at"`
+		Alias
+	}{
+		// use the desired date layout
+		CreatedAt: r.CreatedAt.Format(configs.DateLayout),
+		Alias:     Alias(r),
+	})
+}
+
+// ConsistencyViolation represents a single broken invariant between documents.
+// Only violations with a safe repair describe one, which is applied should the
+// check fix violations.
+type ConsistencyViolation struct {
+	Category    string             `json:"category" bson:"category"` // @TODO: Enum (?) (KeyOwnerMissing, KeyOwnerReferenceMissing, UserKeyReferenceInvalid, KeyServiceMissing, LeadServiceMissing, BasicKeyHasService)
+	Description string             `json:"description" bson:"description"`
+	KeyID       primitive.ObjectID `json:"key_id,omitempty" bson:"key_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	ServiceID   primitive.ObjectID `json:"service_id,omitempty" bson:"service_id,omitempty"`
+	Repair      string             `json:"repair,omitempty" bson:"repair,omitempty"`
+	Repaired    bool               `json:"repaired" bson:"repaired"`
+	RepairError string             `json:"repair_error,omitempty" bson:"repair_error,omitempty"`
+}
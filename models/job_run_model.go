@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobRun represents a single run of a background job
+type JobRun struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	JobName     string             `json:"job_name" bson:"job_name"`
+	Trigger     string             `json:"trigger" bson:"trigger"`                               // @TODO: Enum (?) (Scheduled, Startup, Manual)
+	TriggeredBy primitive.ObjectID `json:"triggered_by,omitempty" bson:"triggered_by,omitempty"` // Admin who triggered a Manual run
+	Holder      string             `json:"holder" bson:"holder"`                                 // Instance which ran the job
+	Outcome     string             `json:"outcome" bson:"outcome"`                               // @TODO: Enum (?) (Succeeded, Failed, Skipped)
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt   time.Time          `json:"started_at" bson:"started_at"`
+	FinishedAt  time.Time          `json:"finished_at" bson:"finished_at"`
+	DurationMS  int64              `json:"duration_ms" bson:"duration_ms"`
+}
+
+func (r JobRun) MarshalJSON() ([]byte, error) {
+	type Alias JobRun
+	return json.Marshal(&struct {
+		StartedAt  string `json:"started_at"`
+		FinishedAt string `json:"finished_at"`
+		Alias
+	}{
+		// use the desired date layout
+		StartedAt:  r.StartedAt.Format(configs.DateLayout),
+		FinishedAt: r.FinishedAt.Format(configs.DateLayout),
+		Alias:      Alias(r),
+	})
+}
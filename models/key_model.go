@@ -16,33 +16,272 @@ type Key struct {
 	Name    string             `json:"name" bson:"name"`
 	OwnerID primitive.ObjectID `json:"owner_id" bson:"owner_id"`
 
+	// Test keys only reach Staging services, and Live keys only reach production services
+	Environment string `json:"environment,omitempty" bson:"environment,omitempty"` // @TODO: Enum (?) (Test, Live), Live if empty
+
+	// Free-form details recording what the key is for and who to contact about it,
+	// and labels (e.g. app=portal, ticket=OPS-12) by which keys can be filtered
+	Description string            `json:"description,omitempty" bson:"description,omitempty"`
+	Contact     string            `json:"contact,omitempty" bson:"contact,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`
+
 	// @TODO: Determine if we want to use different models for Basic and Advanced keys (basic keys not containing a serviceID)
 	ServiceID primitive.ObjectID `json:"service_id,omitempty" bson:"service_id,omitempty"`
 
+	// Plan the key subscribes to, whose quota, windows, rate limit, and overage
+	// the key follows unless QuotaOverride is set by SetKeyQuota
+	PlanID        primitive.ObjectID `json:"plan_id,omitempty" bson:"plan_id,omitempty"`
+	QuotaOverride bool               `json:"quota_override,omitempty" bson:"quota_override,omitempty"`
+
+	// Pool the key shares a quota with. Requests draw from the pool as well as
+	// the key's own quota, or instead of it should PoolOnly be set.
+	PoolID   primitive.ObjectID `json:"pool_id,omitempty" bson:"pool_id,omitempty"`
+	PoolOnly bool               `json:"pool_only,omitempty" bson:"pool_only,omitempty"`
+
+	// Requests the key can make beyond its quota each quota period
+	Overage int `json:"overage,omitempty" bson:"overage,omitempty"`
+
+	// Fixed quotas reset every QuotaNumDays at QuotaTimestamp.
+	// Sliding quotas count consumption over the last QuotaWindowHours using UsageBuckets.
+	// Credit quotas deduct from CreditBalance, which never resets.
+	QuotaMode        string        `json:"quota_mode,omitempty" bson:"quota_mode,omitempty"` // @TODO: Enum (?) (Fixed, Sliding, Credit), Fixed if empty
+	QuotaWindowHours int           `json:"quota_window_hours,omitempty" bson:"quota_window_hours,omitempty"`
+	UsageBuckets     []UsageBucket `json:"usage_buckets,omitempty" bson:"usage_buckets,omitempty"`
+
+	// Prepaid requests of a Credit quota key, topped up by Admins, and the
+	// balances at or below which the owner is notified
+	CreditBalance       int   `json:"credit_balance,omitempty" bson:"credit_balance,omitempty"`
+	CreditAlertBalances []int `json:"credit_alert_balances,omitempty" bson:"credit_alert_balances,omitempty"`
+
+	// Additional calendar-aligned quotas, all of which must have usage remaining
+	QuotaWindows []QuotaWindow `json:"quota_windows,omitempty" bson:"quota_windows,omitempty"`
+
 	Quota          int       `json:"quota" bson:"quota"`
 	QuotaNumDays   int       `json:"quota_num_days" bson:"quota_num_days"`
 	UsageRemaining int       `json:"usage_remaining" bson:"usage_remaining"`
 	QuotaTimestamp time.Time `json:"quota_timestamp" bson:"quota_timestamp"`
-	LastUsed       time.Time `json:"last_used" bson:"last_used"`
-	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
-	IsActive       bool      `json:"is_active" bson:"is_active"`
+
+	// IANA time zone and local hour quotas reset at, overriding the key's service
+	Timezone  string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	ResetHour *int   `json:"reset_hour,omitempty" bson:"reset_hour,omitempty"`
+
+	// Percent of unused usage carried into the next quota period, up to RolloverCap, overriding the key's service
+	RolloverPercent *int `json:"rollover_percent,omitempty" bson:"rollover_percent,omitempty"`
+	RolloverCap     *int `json:"rollover_cap,omitempty" bson:"rollover_cap,omitempty"`
+
+	// Usage carried into the current quota period, included in UsageRemaining
+	RolloverCarried int `json:"rollover_carried" bson:"rollover_carried"`
+
+	// Quota thresholds (percent consumed) the owner has been notified of this period
+	NotifiedThresholds []int `json:"notified_thresholds,omitempty" bson:"notified_thresholds,omitempty"`
+
+	// Credit alert balances the owner has been notified of since the last top up
+	NotifiedCreditAlerts []int `json:"notified_credit_alerts,omitempty" bson:"notified_credit_alerts,omitempty"`
+
+	LastUsed  time.Time `json:"last_used" bson:"last_used"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	IsActive  bool      `json:"is_active" bson:"is_active"`
+
+	// Windows during which the key is disabled, applied by the ApplyKeySchedules job
+	Schedules []KeySchedule `json:"schedules,omitempty" bson:"schedules,omitempty"`
+
+	// Suspensions of the key, the most recent last. At most one is Active at a time.
+	Suspensions []KeySuspension `json:"suspensions,omitempty" bson:"suspensions,omitempty"`
+
+	// Keys exempt from the inactivity policy are never disabled for going unused.
+	// Otherwise, the times the owner was last warned and the key was last disabled
+	// for inactivity, each of which applies until the key is next used.
+	InactivityExempt     bool      `json:"inactivity_exempt,omitempty" bson:"inactivity_exempt,omitempty"`
+	InactivityWarnedAt   time.Time `json:"inactivity_warned_at,omitempty" bson:"inactivity_warned_at,omitempty"`
+	InactivityDisabledAt time.Time `json:"inactivity_disabled_at,omitempty" bson:"inactivity_disabled_at,omitempty"`
+
+	// Tombstone of a deleted key, which can be restored until it is purged
+	DeletedAt time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 func (k Key) MarshalJSON() ([]byte, error) {
 	type Alias Key
+	inactivityWarnedAt := ""
+	if !k.InactivityWarnedAt.IsZero() {
+		inactivityWarnedAt = k.InactivityWarnedAt.Format(configs.DateLayout)
+	}
+	inactivityDisabledAt := ""
+	if !k.InactivityDisabledAt.IsZero() {
+		inactivityDisabledAt = k.InactivityDisabledAt.Format(configs.DateLayout)
+	}
+	deletedAt := ""
+	if !k.DeletedAt.IsZero() {
+		deletedAt = k.DeletedAt.Format(configs.DateLayout)
+	}
+	return json.Marshal(&struct {
+		QuotaTimestamp       string `json:"quota_timestamp"`
+		LastUsed             string `json:"last_used"`
+		CreatedAt            string `json:"created_at"`
+		UpdatedAt            string `json:"updated_at"`
+		InactivityWarnedAt   string `json:"inactivity_warned_at,omitempty"`
+		InactivityDisabledAt string `json:"inactivity_disabled_at,omitempty"`
+		DeletedAt            string `json:"deleted_at,omitempty"`
+		Alias
+	}{
+		// use the desired date layout
+		QuotaTimestamp:       k.QuotaTimestamp.Format(configs.DateLayout),
+		LastUsed:             k.LastUsed.Format(configs.DateLayout),
+		CreatedAt:            k.CreatedAt.Format(configs.DateLayout),
+		UpdatedAt:            k.UpdatedAt.Format(configs.DateLayout),
+		InactivityWarnedAt:   inactivityWarnedAt,
+		InactivityDisabledAt: inactivityDisabledAt,
+		DeletedAt:            deletedAt,
+		Alias:                Alias(k),
+	})
+}
+
+// UsageBucket represents the usage of a Sliding quota key starting at a given time
+type UsageBucket struct {
+	Start time.Time `json:"start" bson:"start"`
+	Count int       `json:"count" bson:"count"`
+}
+
+func (b UsageBucket) MarshalJSON() ([]byte, error) {
+	type Alias UsageBucket
+	return json.Marshal(&struct {
+		Start string `json:"start"`
+		Alias
+	}{
+		// use the desired date layout
+		Start: b.Start.Format(configs.DateLayout),
+		Alias: Alias(b),
+	})
+}
+
+// QuotaWindow represents a calendar-aligned quota of a key (e.g. 1,000 per Hour).
+// Once ResetAt has passed, the window's full quota is available again.
+type QuotaWindow struct {
+	Unit           string    `json:"unit" bson:"unit"` // @TODO: Enum (?) (Minute, Hour, Day, Week, Month)
+	Quota          int       `json:"quota" bson:"quota"`
+	UsageRemaining int       `json:"usage_remaining" bson:"usage_remaining"`
+	ResetAt        time.Time `json:"reset_at" bson:"reset_at"`
+}
+
+func (w QuotaWindow) MarshalJSON() ([]byte, error) {
+	type Alias QuotaWindow
+	return json.Marshal(&struct {
+		ResetAt string `json:"reset_at"`
+		Alias
+	}{
+		// use the desired date layout
+		ResetAt: w.ResetAt.Format(configs.DateLayout),
+		Alias:   Alias(w),
+	})
+}
+
+// KeySchedule represents a window during which a key is disabled, from DisableAt
+// until EnableAt. Recurring schedules then move on to the next window, until Until.
+type KeySchedule struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Recurrence string             `json:"recurrence,omitempty" bson:"recurrence,omitempty"` // @TODO: Enum (?) (Daily, Weekly), one-off if empty
+	DisableAt  time.Time          `json:"disable_at" bson:"disable_at"`
+	EnableAt   time.Time          `json:"enable_at" bson:"enable_at"`
+	Until      time.Time          `json:"until,omitempty" bson:"until,omitempty"`
+	Reason     string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status     string             `json:"status" bson:"status"` // @TODO: Enum (?) (Scheduled, Disabled, Completed, Cancelled)
+
+	// Whether the key was active as the current window began. Keys which were
+	// already disabled are left disabled as the window ends.
+	KeyWasActive bool `json:"key_was_active,omitempty" bson:"key_was_active,omitempty"`
+
+	// Most recent transitions applied by the schedule
+	Transitions []KeyScheduleTransition `json:"transitions,omitempty" bson:"transitions,omitempty"`
+
+	CreatedBy primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+func (s KeySchedule) MarshalJSON() ([]byte, error) {
+	type Alias KeySchedule
+	until := ""
+	if !s.Until.IsZero() {
+		until = s.Until.Format(configs.DateLayout)
+	}
+	return json.Marshal(&struct {
+		DisableAt string `json:"disable_at"`
+		EnableAt  string `json:"enable_at"`
+		Until     string `json:"until,omitempty"`
+		CreatedAt string `json:"created_at"`
+		Alias
+	}{
+		// use the desired date layout
+		DisableAt: s.DisableAt.Format(configs.DateLayout),
+		EnableAt:  s.EnableAt.Format(configs.DateLayout),
+		Until:     until,
+		CreatedAt: s.CreatedAt.Format(configs.DateLayout),
+		Alias:     Alias(s),
+	})
+}
+
+// KeyScheduleTransition represents a transition applied by a KeySchedule
+type KeyScheduleTransition struct {
+	Action       string    `json:"action" bson:"action"` // @TODO: Enum (?) (Disable, Enable, Skip, Cancel)
+	ScheduledFor time.Time `json:"scheduled_for" bson:"scheduled_for"`
+	AppliedAt    time.Time `json:"applied_at" bson:"applied_at"`
+	Message      string    `json:"message,omitempty" bson:"message,omitempty"`
+}
+
+func (t KeyScheduleTransition) MarshalJSON() ([]byte, error) {
+	type Alias KeyScheduleTransition
+	return json.Marshal(&struct {
+		ScheduledFor string `json:"scheduled_for"`
+		AppliedAt    string `json:"applied_at"`
+		Alias
+	}{
+		// use the desired date layout
+		ScheduledFor: t.ScheduledFor.Format(configs.DateLayout),
+		AppliedAt:    t.AppliedAt.Format(configs.DateLayout),
+		Alias:        Alias(t),
+	})
+}
+
+// KeySuspension represents a suspension of a key by a Lead or Admin, with a reason
+// shown to the key's owner. Suspensions are optionally lifted automatically at LiftAt.
+type KeySuspension struct {
+	ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Category string             `json:"category" bson:"category"` // @TODO: Enum (?) (Compromised, Abuse, PolicyViolation, Billing, Other)
+	Message  string             `json:"message" bson:"message"`
+	LiftAt   time.Time          `json:"lift_at,omitempty" bson:"lift_at,omitempty"`
+	Status   string             `json:"status" bson:"status"` // @TODO: Enum (?) (Active, Lifted)
+
+	// Whether the key was active as it was suspended. Keys which were already
+	// disabled are left disabled as the suspension is lifted.
+	KeyWasActive bool `json:"key_was_active,omitempty" bson:"key_was_active,omitempty"`
+
+	SuspendedBy primitive.ObjectID `json:"suspended_by" bson:"suspended_by"`
+	SuspendedAt time.Time          `json:"suspended_at" bson:"suspended_at"`
+
+	// Lifted by no one when lifted automatically at LiftAt
+	LiftedBy primitive.ObjectID `json:"lifted_by,omitempty" bson:"lifted_by,omitempty"`
+	LiftedAt time.Time          `json:"lifted_at,omitempty" bson:"lifted_at,omitempty"`
+}
+
+func (s KeySuspension) MarshalJSON() ([]byte, error) {
+	type Alias KeySuspension
+	liftAt := ""
+	if !s.LiftAt.IsZero() {
+		liftAt = s.LiftAt.Format(configs.DateLayout)
+	}
+	liftedAt := ""
+	if !s.LiftedAt.IsZero() {
+		liftedAt = s.LiftedAt.Format(configs.DateLayout)
+	}
 	return json.Marshal(&struct {
-		QuotaTimestamp string `json:"quota_timestamp"`
-		LastUsed       string `json:"last_used"`
-		CreatedAt      string `json:"created_at"`
-		UpdatedAt      string `json:"updated_at"`
+		LiftAt      string `json:"lift_at,omitempty"`
+		SuspendedAt string `json:"suspended_at"`
+		LiftedAt    string `json:"lifted_at,omitempty"`
 		Alias
 	}{
 		// use the desired date layout
-		QuotaTimestamp: k.QuotaTimestamp.Format(configs.DateLayout),
-		LastUsed:       k.LastUsed.Format(configs.DateLayout),
-		CreatedAt:      k.CreatedAt.Format(configs.DateLayout),
-		UpdatedAt:      k.UpdatedAt.Format(configs.DateLayout),
-		Alias:          Alias(k),
+		LiftAt:      liftAt,
+		SuspendedAt: s.SuspendedAt.Format(configs.DateLayout),
+		LiftedAt:    liftedAt,
+		Alias:       Alias(s),
 	})
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// KeyClaim represents a single-use token with which the owner of a newly
+// created advanced key claims the key. Only a hash of the token is stored.
+type KeyClaim struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	KeyID     primitive.ObjectID `json:"key_id" bson:"key_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	ClaimedAt time.Time          `json:"claimed_at,omitempty" bson:"claimed_at,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// KeyRevealChallenge represents a one-time code delivered to a user, with
+// which they re-authenticate to reveal a key. Only a hash of the code is stored.
+type KeyRevealChallenge struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	KeyID     primitive.ObjectID `json:"key_id" bson:"key_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	CodeHash  string             `json:"-" bson:"code_hash"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt    time.Time          `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// KeyRevealAttempt represents a single attempt to claim or reveal a key,
+// used to rate limit attempts
+type KeyRevealAttempt struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	KeyID     primitive.ObjectID `json:"key_id" bson:"key_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Flow      string             `json:"flow" bson:"flow"` // @TODO: Enum (?) (Claim, Challenge, Reveal)
+	Succeeded bool               `json:"succeeded" bson:"succeeded"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	ClientIP  string             `json:"client_ip" bson:"client_ip"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
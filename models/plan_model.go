@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Plan represents a named quota tier keys subscribe to
+type Plan struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	Name         string             `json:"plan_name" bson:"plan_name"` // e.g. Free, Student Project, Partner, Internal
+	Quota        int                `json:"quota" bson:"quota"`
+	QuotaNumDays int                `json:"quota_num_days" bson:"quota_num_days"`
+
+	// Calendar-aligned quota windows of subscribed keys
+	QuotaWindows []PlanQuotaWindow `json:"quota_windows" bson:"quota_windows"`
+
+	// Requests per minute subscribed keys are limited to (0 for no limit)
+	RateLimitPerMinute int `json:"rate_limit_per_minute" bson:"rate_limit_per_minute"`
+
+	// Requests subscribed keys can make beyond their quota each quota period
+	Overage int `json:"overage" bson:"overage"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// PlanQuotaWindow represents a calendar-aligned quota window of a plan
+type PlanQuotaWindow struct {
+	Unit  string `json:"unit" bson:"unit"` // @TODO: Enum (?) (Minute, Hour, Day, Week, Month)
+	Quota int    `json:"quota" bson:"quota"`
+}
+
+func (p Plan) MarshalJSON() ([]byte, error) {
+	type Alias Plan
+	return json.Marshal(&struct {
+		CreatedAt string `json:"created_at"`
+		UpdatedAt string `json:"updated_at"`
+		Alias
+	}{
+		// use the desired date layout
+		CreatedAt: p.CreatedAt.Format(configs.DateLayout),
+		UpdatedAt: p.UpdatedAt.Format(configs.DateLayout),
+		Alias:     Alias(p),
+	})
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Pool represents a quota shared by a group of a service's advanced keys
+type Pool struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	Name      string             `json:"pool_name" bson:"pool_name"`
+	ServiceID primitive.ObjectID `json:"service_id" bson:"service_id"`
+	CreatorID primitive.ObjectID `json:"creator_id" bson:"creator_id"`
+
+	Quota          int       `json:"quota" bson:"quota"`
+	QuotaNumDays   int       `json:"quota_num_days" bson:"quota_num_days"`
+	UsageRemaining int       `json:"usage_remaining" bson:"usage_remaining"`
+	QuotaTimestamp time.Time `json:"quota_timestamp" bson:"quota_timestamp"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+func (p Pool) MarshalJSON() ([]byte, error) {
+	type Alias Pool
+	return json.Marshal(&struct {
+		QuotaTimestamp string `json:"quota_timestamp"`
+		CreatedAt      string `json:"created_at"`
+		UpdatedAt      string `json:"updated_at"`
+		Alias
+	}{
+		// use the desired date layout
+		QuotaTimestamp: p.QuotaTimestamp.Format(configs.DateLayout),
+		CreatedAt:      p.CreatedAt.Format(configs.DateLayout),
+		UpdatedAt:      p.UpdatedAt.Format(configs.DateLayout),
+		Alias:          Alias(p),
+	})
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QuotaRequest represents a key owner's request to increase a key's quota
+type QuotaRequest struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	KeyID       primitive.ObjectID `json:"key_id" bson:"key_id"`
+	KeyType     string             `json:"key_type" bson:"key_type"` // @TODO: Enum (?) (Basic, Advanced)
+	ServiceID   primitive.ObjectID `json:"service_id,omitempty" bson:"service_id,omitempty"`
+	RequesterID primitive.ObjectID `json:"requester_id" bson:"requester_id"`
+
+	CurrentQuota   int    `json:"current_quota" bson:"current_quota"`
+	RequestedQuota int    `json:"requested_quota" bson:"requested_quota"`
+	Justification  string `json:"justification" bson:"justification"`
+
+	Status        string             `json:"status" bson:"status"` // @TODO: Enum (?) (Pending, Approved, Denied)
+	ApprovedQuota int                `json:"approved_quota,omitempty" bson:"approved_quota,omitempty"`
+	ReviewerID    primitive.ObjectID `json:"reviewer_id,omitempty" bson:"reviewer_id,omitempty"`
+	ReviewNote    string             `json:"review_note,omitempty" bson:"review_note,omitempty"`
+
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	ReviewedAt time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+}
+
+func (r QuotaRequest) MarshalJSON() ([]byte, error) {
+	type Alias QuotaRequest
+	reviewedAt := ""
+	if !r.ReviewedAt.IsZero() {
+		reviewedAt = r.ReviewedAt.Format(configs.DateLayout)
+	}
+	return json.Marshal(&struct {
+		CreatedAt  string `json:"created_at"`
+		ReviewedAt string `json:"reviewed_at,omitempty"`
+		Alias
+	}{
+		// use the desired date layout
+		CreatedAt:  r.CreatedAt.Format(configs.DateLayout),
+		ReviewedAt: reviewedAt,
+		Alias:      Alias(r),
+	})
+}
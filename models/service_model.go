@@ -16,18 +16,44 @@ type Service struct {
 	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt         time.Time          `json:"updated_at" bson:"updated_at"`
 	SourceIdentifiers []string           `json:"source_identifiers" bson:"source_identifiers"`
+
+	// IANA time zone and local hour quotas of the service's keys reset at (Default: UTC midnight)
+	Timezone  string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	ResetHour *int   `json:"reset_hour,omitempty" bson:"reset_hour,omitempty"`
+
+	// Percent of unused usage the service's keys carry into their next quota period, up to RolloverCap (Default: none)
+	RolloverPercent *int `json:"rollover_percent,omitempty" bson:"rollover_percent,omitempty"`
+	RolloverCap     *int `json:"rollover_cap,omitempty" bson:"rollover_cap,omitempty"`
+
+	// Days the service's keys can go unused before they are disabled (0 never disables them),
+	// and days beforehand their owners are warned, overriding KEY_INACTIVITY_DAYS
+	InactivityDays        *int `json:"inactivity_days,omitempty" bson:"inactivity_days,omitempty"`
+	InactivityWarningDays *int `json:"inactivity_warning_days,omitempty" bson:"inactivity_warning_days,omitempty"`
+
+	// Optional limit on the requests of all the service's keys each aggregate quota period.
+	// For Basic services, this limits the requests of all basic keys to the service.
+	AggregateQuota          int       `json:"aggregate_quota,omitempty" bson:"aggregate_quota,omitempty"`
+	AggregateQuotaNumDays   int       `json:"aggregate_quota_num_days,omitempty" bson:"aggregate_quota_num_days,omitempty"`
+	AggregateUsageRemaining int       `json:"aggregate_usage_remaining,omitempty" bson:"aggregate_usage_remaining,omitempty"`
+	AggregateQuotaTimestamp time.Time `json:"aggregate_quota_timestamp,omitempty" bson:"aggregate_quota_timestamp,omitempty"`
 }
 
 func (s Service) MarshalJSON() ([]byte, error) {
 	type Alias Service
+	aggregateQuotaTimestamp := ""
+	if !s.AggregateQuotaTimestamp.IsZero() {
+		aggregateQuotaTimestamp = s.AggregateQuotaTimestamp.Format(configs.DateLayout)
+	}
 	return json.Marshal(&struct {
-		CreatedAt string `json:"created_at"`
-		UpdatedAt string `json:"updated_at"`
+		CreatedAt               string `json:"created_at"`
+		UpdatedAt               string `json:"updated_at"`
+		AggregateQuotaTimestamp string `json:"aggregate_quota_timestamp,omitempty"`
 		Alias
 	}{
 		// use the desired date layout
-		CreatedAt: s.CreatedAt.Format(configs.DateLayout),
-		UpdatedAt: s.UpdatedAt.Format(configs.DateLayout),
-		Alias:     Alias(s),
+		CreatedAt:               s.CreatedAt.Format(configs.DateLayout),
+		UpdatedAt:               s.UpdatedAt.Format(configs.DateLayout),
+		AggregateQuotaTimestamp: aggregateQuotaTimestamp,
+		Alias:                   Alias(s),
 	})
 }
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UsagePeriod represents a key's consumption over a past quota period
+type UsagePeriod struct {
+	ID              primitive.ObjectID `json:"_id" bson:"_id"`
+	KeyID           primitive.ObjectID `json:"key_id" bson:"key_id"`
+	PeriodStart     time.Time          `json:"period_start" bson:"period_start"`
+	PeriodEnd       time.Time          `json:"period_end" bson:"period_end"`
+	Quota           int                `json:"quota" bson:"quota"`
+	RolloverCarried int                `json:"rollover_carried" bson:"rollover_carried"`
+	Used            int                `json:"used" bson:"used"`
+	RecordedAt      time.Time          `json:"recorded_at" bson:"recorded_at"`
+}
+
+func (p UsagePeriod) MarshalJSON() ([]byte, error) {
+	type Alias UsagePeriod
+	return json.Marshal(&struct {
+		PeriodStart string `json:"period_start"`
+		PeriodEnd   string `json:"period_end"`
+		RecordedAt  string `json:"recorded_at"`
+		Alias
+	}{
+		// use the desired date layout
+		PeriodStart: p.PeriodStart.Format(configs.DateLayout),
+		PeriodEnd:   p.PeriodEnd.Format(configs.DateLayout),
+		RecordedAt:  p.RecordedAt.Format(configs.DateLayout),
+		Alias:       Alias(p),
+	})
+}
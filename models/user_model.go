@@ -12,10 +12,12 @@ import (
 type User struct {
 	ID             primitive.ObjectID   `json:"_id" bson:"_id"`
 	PlatformUserID primitive.ObjectID   `json:"platform_user_id" bson:"platform_user_id"`
-	Type           string               `json:"user_type" bson:"user_type"` // @TODO: Enum (?) (Developer, Lead, Admin)
+	Email          string               `json:"email,omitempty" bson:"email,omitempty"` // Used for notifications
+	Type           string               `json:"user_type" bson:"user_type"`             // @TODO: Enum (?) (Developer, Lead, Admin)
 	CreatedAt      time.Time            `json:"created_at" bson:"created_at"`
 	UpdatedAt      time.Time            `json:"updated_at" bson:"updated_at"`
 	BasicKey       primitive.ObjectID   `json:"basic_key" bson:"basic_key"`
+	TestBasicKey   primitive.ObjectID   `json:"test_basic_key,omitempty" bson:"test_basic_key,omitempty"`
 	AdvancedKeys   []primitive.ObjectID `json:"advanced_keys" bson:"advanced_keys"`
 	Services       []primitive.ObjectID `json:"services,omitempty" bson:"services,omitempty"` // @TODO: Verify best solution. Services should only appear if you are a Lead.
 }
@@ -0,0 +1,34 @@
+package notifiers
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// Written in place of a notification's secrets
+const redactedSecret = "[REDACTED]"
+
+// LogNotifier writes notifications to the process log.
+// This is useful for local development and as a fallback.
+// The log is not the recipient's, so secrets are redacted.
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	log.Printf("Notification '%s' for user %s: %s - %s", notification.Event, notification.Recipient.ID.Hex(), redactSecrets(notification.Subject, notification.Secrets), redactSecrets(notification.Message, notification.Secrets))
+	return nil
+}
+
+// Replace each of the secrets within the text
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, redactedSecret)
+		}
+	}
+	return text
+}
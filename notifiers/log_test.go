@@ -0,0 +1,40 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLogNotifierRedactsSecrets(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	notification := Notification{
+		Event:     "KeyClaim",
+		Recipient: models.User{ID: primitive.NewObjectID()},
+		Subject:   "Claim your new key 'key_TEST'",
+		Message:   "Claim it with the claim token 'TOKEN-123' before 2026-10-22. The token can only be used once.",
+		Data:      map[string]interface{}{"claim_token": "TOKEN-123"},
+		Secrets:   []string{"TOKEN-123"},
+	}
+
+	err := NewLogNotifier().Notify(context.Background(), notification)
+	if err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	if strings.Contains(output.String(), "TOKEN-123") {
+		t.Errorf("Expected the claim token to be redacted, got %q", output.String())
+	}
+	if !strings.Contains(output.String(), "claim token '"+redactedSecret+"'") {
+		t.Errorf("Expected the message with the claim token redacted, got %q", output.String())
+	}
+}
@@ -0,0 +1,57 @@
+/**************************************************************************
+* Notifiers.
+*
+* Notifiers deliver notifications about kms events (such as a key
+* consuming a large portion of its quota) to the affected users.
+*
+* The notifier used is selected by the 'NOTIFIER' environment variable:
+*  - 'log'     : Write notifications to the process log (Default), with
+*                their secrets redacted
+*  - 'smtp'    : Email notifications to the recipient's email address
+*  - 'webhook' : POST notifications as JSON to a configured url
+*
+* See configs/env.go for the environment variables each notifier uses.
+**************************************************************************/
+
+package notifiers
+
+import (
+	"context"
+	"log"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+)
+
+// Notification represents a message to deliver to a kms user
+type Notification struct {
+	Event     string                 `json:"event"` // e.g. QuotaThreshold
+	Recipient models.User            `json:"recipient"`
+	Subject   string                 `json:"subject"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+
+	// Values within the notification only the recipient may see (e.g. a claim
+	// token), which notifiers not delivering to the recipient must redact
+	Secrets []string `json:"-"`
+}
+
+// Notifier delivers notifications to users
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// Build the notifier configured by the environment
+func NewFromEnv() Notifier {
+	switch configs.GetEnvNotifier() {
+	case "smtp":
+		return NewSMTPNotifier(configs.GetEnvSMTPAddress(), configs.GetEnvSMTPUsername(), configs.GetEnvSMTPPassword(), configs.GetEnvSMTPFrom())
+	case "webhook":
+		return NewWebhookNotifier(configs.GetEnvNotifierWebhookURL())
+	case "log":
+		return NewLogNotifier()
+	default:
+		log.Fatalf("Invalid 'NOTIFIER': Must be 'log', 'smtp', or 'webhook'")
+		return nil
+	}
+}
@@ -0,0 +1,50 @@
+package notifiers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+var ErrNoRecipientAddress = errors.New("notification recipient has no email address")
+
+// SMTPNotifier emails notifications to the recipient's email address.
+// Authentication is only used when a username is given, which allows
+// a local SMTP server to stand in for the real one.
+type SMTPNotifier struct {
+	Address  string // host:port
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPNotifier(address string, username string, password string, from string) *SMTPNotifier {
+	return &SMTPNotifier{Address: address, Username: username, Password: password, From: from}
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, notification Notification) error {
+	if notification.Recipient.Email == "" {
+		return ErrNoRecipientAddress
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host := strings.Split(n.Address, ":")[0]
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	// @INFO: Subjects include user given values (e.g. key names), so must not add headers of their own
+	subject := mime.QEncoding.Encode("utf-8", headerValue(notification.Subject))
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", headerValue(n.From), headerValue(notification.Recipient.Email), subject, notification.Message)
+
+	// @INFO: net/smtp does not support contexts, so delivery is bound by the server's timeouts
+	return smtp.SendMail(n.Address, auth, n.From, []string{notification.Recipient.Email}, []byte(msg))
+}
+
+// Replace line breaks within a header value, which would otherwise end the header
+func headerValue(value string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,177 @@
+package notifiers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mail received by the stand-in SMTP server
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// Start a stand-in SMTP server which accepts a single mail without
+// authentication, sending it on the returned channel once delivered
+func startSMTPStandIn(t *testing.T) (string, <-chan receivedMail) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to start stand-in SMTP server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan receivedMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		var mail receivedMail
+
+		text.PrintfLine("220 localhost ESMTP stand-in")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL":
+				mail.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				lines, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				mail.data = strings.Join(lines, "\n")
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				received <- mail
+				return
+			default:
+				text.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPNotifierDeliversToRecipient(t *testing.T) {
+	address, received := startSMTPStandIn(t)
+	notifier := NewSMTPNotifier(address, "", "", "kms@utdnebula.com")
+
+	notification := Notification{
+		Event:     "QuotaThreshold",
+		Recipient: models.User{ID: primitive.NewObjectID(), Email: "dev@utdnebula.com"},
+		Subject:   "Your key 'key_TEST' has used 80% of its quota",
+		Message:   "Your key 'key_TEST' has used 80 of its 100 requests.",
+	}
+
+	err := notifier.Notify(context.Background(), notification)
+	if err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	mail := <-received
+	if mail.from != "kms@utdnebula.com" {
+		t.Errorf("Expected mail from kms@utdnebula.com, got %q", mail.from)
+	}
+	if len(mail.to) != 1 || mail.to[0] != "dev@utdnebula.com" {
+		t.Errorf("Expected mail to dev@utdnebula.com, got %v", mail.to)
+	}
+
+	headers, err := textproto.NewReader(bufio.NewReader(strings.NewReader(mail.data + "\n"))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("Unable to read mail headers: %v", err)
+	}
+	if headers.Get("To") != "dev@utdnebula.com" {
+		t.Errorf("Expected To header dev@utdnebula.com, got %q", headers.Get("To"))
+	}
+	if headers.Get("Subject") != notification.Subject {
+		t.Errorf("Expected Subject header %q, got %q", notification.Subject, headers.Get("Subject"))
+	}
+	if !strings.HasSuffix(mail.data, notification.Message) {
+		t.Errorf("Expected mail body %q, got %q", notification.Message, mail.data)
+	}
+}
+
+func TestSMTPNotifierDoesNotInjectHeaders(t *testing.T) {
+	address, received := startSMTPStandIn(t)
+	notifier := NewSMTPNotifier(address, "", "", "kms@utdnebula.com")
+
+	keyName := "key_TEST\r\nBcc: attacker@example.com\r\n\r\nInjected body"
+	notification := Notification{
+		Event:     "QuotaThreshold",
+		Recipient: models.User{ID: primitive.NewObjectID(), Email: "dev@utdnebula.com"},
+		Subject:   "Your key '" + keyName + "' has used 80% of its quota",
+		Message:   "Your key has used 80 of its 100 requests.",
+	}
+
+	err := notifier.Notify(context.Background(), notification)
+	if err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	mail := <-received
+	if len(mail.to) != 1 || mail.to[0] != "dev@utdnebula.com" {
+		t.Errorf("Expected mail to dev@utdnebula.com only, got %v", mail.to)
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(mail.data + "\n")))
+	headers, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("Unable to read mail headers: %v", err)
+	}
+	if headers.Get("Bcc") != "" {
+		t.Errorf("Expected no Bcc header, got %q", headers.Get("Bcc"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(headers.Get("Subject"))
+	if err != nil {
+		t.Fatalf("Unable to decode Subject header: %v", err)
+	}
+	if want := "Your key 'key_TEST Bcc: attacker@example.com  Injected body' has used 80% of its quota"; subject != want {
+		t.Errorf("Expected Subject header %q, got %q", want, subject)
+	}
+
+	body, err := io.ReadAll(reader.R)
+	if err != nil {
+		t.Fatalf("Unable to read mail body: %v", err)
+	}
+	if strings.TrimSpace(string(body)) != notification.Message {
+		t.Errorf("Expected mail body %q, got %q", notification.Message, string(body))
+	}
+}
+
+func TestSMTPNotifierRequiresRecipientAddress(t *testing.T) {
+	// @INFO: No server is listening, so any delivery attempt would fail differently
+	notifier := NewSMTPNotifier("127.0.0.1:1", "", "", "kms@utdnebula.com")
+
+	err := notifier.Notify(context.Background(), Notification{Event: "QuotaThreshold", Recipient: models.User{ID: primitive.NewObjectID()}})
+	if err != ErrNoRecipientAddress {
+		t.Errorf("Expected ErrNoRecipientAddress, got %v", err)
+	}
+}
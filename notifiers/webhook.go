@@ -0,0 +1,65 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookNotifier POSTs notifications as JSON to a configured url
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Body of webhook requests
+type webhookPayload struct {
+	Event          string                 `json:"event"`
+	UserID         string                 `json:"user_id"`
+	PlatformUserID string                 `json:"platform_user_id"`
+	Email          string                 `json:"email,omitempty"`
+	Subject        string                 `json:"subject"`
+	Message        string                 `json:"message"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+	SentAt         time.Time              `json:"sent_at"`
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	payload, err := json.Marshal(webhookPayload{
+		Event:          notification.Event,
+		UserID:         notification.Recipient.ID.Hex(),
+		PlatformUserID: notification.Recipient.PlatformUserID.Hex(),
+		Email:          notification.Recipient.Email,
+		Subject:        notification.Subject,
+		Message:        notification.Message,
+		Data:           notification.Data,
+		SentAt:         time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
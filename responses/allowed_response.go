@@ -5,4 +5,11 @@ type AllowedResponse struct {
 	Message   string      `json:"message"`
 	Data      interface{} `json:"data"`
 	IsAllowed bool        `json:"is_allowed"`
+
+	// Seconds until the key has quota again, set when the quota is reached
+	RetryAfter int `json:"retry_after,omitempty"`
+
+	// Reason the key is suspended, set when the key is suspended
+	SuspensionCategory string `json:"suspension_category,omitempty"`
+	SuspensionMessage  string `json:"suspension_message,omitempty"`
 }
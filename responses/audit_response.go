@@ -0,0 +1,7 @@
+package responses
+
+type AuditResponse struct {
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
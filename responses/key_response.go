@@ -1,7 +1,132 @@
 package responses
 
+import (
+	"time"
+
+	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
 type KeyResponse struct {
 	Status  int         `json:"status"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
 }
+
+// KeyData is a key as returned by endpoints listing keys. Unlike models.Key, it
+// leaves out the state used to consume and notify the key's quota (e.g. its usage
+// buckets and the thresholds its owner has been notified of).
+type KeyData struct {
+	ID          primitive.ObjectID `json:"_id"`
+	Key         string             `json:"key"`
+	Type        string             `json:"key_type"`
+	Environment string             `json:"environment,omitempty"`
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Contact     string             `json:"contact,omitempty"`
+	Labels      map[string]string  `json:"labels,omitempty"`
+	OwnerID     primitive.ObjectID `json:"owner_id"`
+	ServiceID   primitive.ObjectID `json:"service_id,omitempty"`
+
+	PlanID        primitive.ObjectID `json:"plan_id,omitempty"`
+	QuotaOverride bool               `json:"quota_override,omitempty"`
+	Overage       int                `json:"overage,omitempty"`
+
+	PoolID   primitive.ObjectID `json:"pool_id,omitempty"`
+	PoolOnly bool               `json:"pool_only,omitempty"`
+
+	QuotaMode           string               `json:"quota_mode,omitempty"`
+	QuotaWindowHours    int                  `json:"quota_window_hours,omitempty"`
+	CreditBalance       int                  `json:"credit_balance,omitempty"`
+	CreditAlertBalances []int                `json:"credit_alert_balances,omitempty"`
+	QuotaWindows        []models.QuotaWindow `json:"quota_windows,omitempty"`
+
+	Quota          int    `json:"quota"`
+	QuotaNumDays   int    `json:"quota_num_days"`
+	UsageRemaining int    `json:"usage_remaining"`
+	QuotaTimestamp string `json:"quota_timestamp"`
+
+	Timezone        string `json:"timezone,omitempty"`
+	ResetHour       *int   `json:"reset_hour,omitempty"`
+	RolloverPercent *int   `json:"rollover_percent,omitempty"`
+	RolloverCap     *int   `json:"rollover_cap,omitempty"`
+	RolloverCarried int    `json:"rollover_carried"`
+
+	LastUsed  string `json:"last_used"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	IsActive  bool   `json:"is_active"`
+
+	Schedules   []models.KeySchedule   `json:"schedules,omitempty"`
+	Suspensions []models.KeySuspension `json:"suspensions,omitempty"`
+
+	InactivityExempt     bool   `json:"inactivity_exempt,omitempty"`
+	InactivityWarnedAt   string `json:"inactivity_warned_at,omitempty"`
+	InactivityDisabledAt string `json:"inactivity_disabled_at,omitempty"`
+}
+
+// Build the KeyData of a key, hiding the actual key should hideKey be set
+func NewKeyData(key models.Key, hideKey bool) KeyData {
+	data := KeyData{
+		ID:          key.ID,
+		Key:         key.Key,
+		Type:        key.Type,
+		Environment: key.Environment,
+		Name:        key.Name,
+		Description: key.Description,
+		Contact:     key.Contact,
+		Labels:      key.Labels,
+		OwnerID:     key.OwnerID,
+		ServiceID:   key.ServiceID,
+
+		PlanID:        key.PlanID,
+		QuotaOverride: key.QuotaOverride,
+		Overage:       key.Overage,
+
+		PoolID:   key.PoolID,
+		PoolOnly: key.PoolOnly,
+
+		QuotaMode:           key.QuotaMode,
+		QuotaWindowHours:    key.QuotaWindowHours,
+		CreditBalance:       key.CreditBalance,
+		CreditAlertBalances: key.CreditAlertBalances,
+		QuotaWindows:        key.QuotaWindows,
+
+		Quota:          key.Quota,
+		QuotaNumDays:   key.QuotaNumDays,
+		UsageRemaining: key.UsageRemaining,
+		QuotaTimestamp: key.QuotaTimestamp.Format(configs.DateLayout),
+
+		Timezone:        key.Timezone,
+		ResetHour:       key.ResetHour,
+		RolloverPercent: key.RolloverPercent,
+		RolloverCap:     key.RolloverCap,
+		RolloverCarried: key.RolloverCarried,
+
+		// use the desired date layout
+		LastUsed:  key.LastUsed.Format(configs.DateLayout),
+		CreatedAt: key.CreatedAt.Format(configs.DateLayout),
+		UpdatedAt: key.UpdatedAt.Format(configs.DateLayout),
+		IsActive:  key.IsActive,
+
+		Schedules:   key.Schedules,
+		Suspensions: key.Suspensions,
+
+		InactivityExempt:     key.InactivityExempt,
+		InactivityWarnedAt:   formatOptionalTime(key.InactivityWarnedAt),
+		InactivityDisabledAt: formatOptionalTime(key.InactivityDisabledAt),
+	}
+	if hideKey {
+		data.Key = "_HIDDEN_"
+	}
+	return data
+}
+
+// Format a time using the desired date layout, or leave it empty should it not be set
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(configs.DateLayout)
+}
@@ -0,0 +1,7 @@
+package responses
+
+type PoolResponse struct {
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/UTDNebula/kms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AuditRoute(router *gin.Engine) {
+
+	// All routes related to the audit log come here
+	auditGroup := router.Group("/audit")
+
+	// Get Audit Events
+	auditGroup.GET("/events", controllers.GetAuditEvents())
+
+}
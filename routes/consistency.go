@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/UTDNebula/kms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ConsistencyRoute(router *gin.Engine) {
+
+	// All routes related to data consistency come here
+	consistencyGroup := router.Group("/consistency")
+
+	// Check Consistency, optionally repairing violations
+	consistencyGroup.POST("/check", controllers.CheckConsistency())
+
+	// Get Consistency Reports
+	consistencyGroup.GET("/reports", controllers.GetConsistencyReports())
+
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/UTDNebula/kms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func JobRoute(router *gin.Engine) {
+
+	// All routes related to background jobs come here
+	jobGroup := router.Group("/job")
+
+	// Trigger Job
+	jobGroup.POST("/run", controllers.TriggerJob())
+
+	// Get Latest Job Run
+	jobGroup.GET("/latest", controllers.GetLatestJobRun())
+
+}
@@ -13,6 +13,9 @@ func KeyRoute(router *gin.Engine) {
 
 	// Enable CORS (?)
 
+	// List Keys
+	router.GET("/keys", controllers.GetKeys())
+
 	// Create Basic Key
 	keyGroup.POST("/create-basic-key", controllers.CreateBasicKey())
 
@@ -22,6 +25,9 @@ func KeyRoute(router *gin.Engine) {
 	// Delete Key
 	keyGroup.DELETE("/delete", controllers.DeleteKey())
 
+	// Restore Deleted Key
+	keyGroup.PATCH("/restore", controllers.RestoreKey())
+
 	// Disable Key
 	keyGroup.PATCH("/disable", controllers.DisableKey())
 
@@ -34,12 +40,54 @@ func KeyRoute(router *gin.Engine) {
 	// Regenerate Key
 	keyGroup.PATCH("/rename", controllers.RenameKey())
 
+	// Claim a New Key
+	keyGroup.POST("/claim", controllers.ClaimKey())
+
+	// Request a Code to Reveal a Key
+	keyGroup.POST("/reveal-challenge", controllers.CreateKeyRevealChallenge())
+
+	// Reveal Key
+	keyGroup.POST("/reveal", controllers.RevealKey())
+
+	// Set Description, Contact, and Labels of a Key
+	keyGroup.PATCH("/set-details", controllers.SetKeyDetails())
+
+	// Import Labels of Keys in Bulk
+	keyGroup.PATCH("/import-labels", controllers.ImportKeyLabels())
+
+	// Schedule a Window During Which a Key is Disabled
+	keyGroup.POST("/schedule", controllers.ScheduleKeyWindow())
+
+	// Cancel a Schedule of a Key
+	keyGroup.PATCH("/cancel-schedule", controllers.CancelKeySchedule())
+
+	// Suspend a Key
+	keyGroup.PATCH("/suspend", controllers.SuspendKey())
+
+	// Lift the Suspension of a Key
+	keyGroup.PATCH("/lift-suspension", controllers.LiftKeySuspension())
+
+	// Exempt a Key from the Inactivity Policy
+	keyGroup.PATCH("/set-inactivity-exempt", controllers.SetKeyInactivityExempt())
+
 	// Set Quota for a Key
 	keyGroup.PATCH("/set-quota", controllers.SetKeyQuota())
 
 	// Restore Quota for a Key
 	keyGroup.PATCH("/restore-quota", controllers.RestoreKeyQuota())
 
+	// Top Up Credit Balance of a Key
+	keyGroup.PATCH("/top-up-credit", controllers.TopUpKeyCredit())
+
+	// Set Plan for a Key
+	keyGroup.PATCH("/set-plan", controllers.SetKeyPlan())
+
+	// Get Usage History of a Key
+	keyGroup.GET("/usage-history", controllers.GetKeyUsageHistory())
+
+	// Update Keys in Bulk
+	keyGroup.PATCH("/bulk", controllers.BulkUpdateKeys())
+
 	// Change Key Holder
 	keyGroup.PATCH("/change-holder", controllers.ChangeKeyHolder())
 
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/UTDNebula/kms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PlanRoute(router *gin.Engine) {
+
+	// All routes related to plans come here
+	planGroup := router.Group("/plan")
+
+	// Create Plan
+	planGroup.POST("/create", controllers.CreatePlan())
+
+	// Update Plan
+	planGroup.PATCH("/update", controllers.UpdatePlan())
+
+	// Get Plans
+	planGroup.GET("/all", controllers.GetPlans())
+
+}
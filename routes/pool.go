@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/UTDNebula/kms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PoolRoute(router *gin.Engine) {
+
+	// All routes related to quota pools come here
+	poolGroup := router.Group("/pool")
+
+	// Create Pool
+	poolGroup.POST("/create", controllers.CreatePool())
+
+	// Add Key to a Pool
+	poolGroup.PATCH("/add-key", controllers.AddKeyToPool())
+
+	// Remove Key from its Pool
+	poolGroup.PATCH("/remove-key", controllers.RemoveKeyFromPool())
+
+	// Get Pools of a Service
+	poolGroup.GET("/service", controllers.GetServicePools())
+
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/UTDNebula/kms/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func QuotaRequestRoute(router *gin.Engine) {
+
+	// All routes related to quota increase requests come here
+	quotaRequestGroup := router.Group("/quota-request")
+
+	// Create Quota Request
+	quotaRequestGroup.POST("/create", controllers.CreateQuotaRequest())
+
+	// Approve Quota Request
+	quotaRequestGroup.PATCH("/approve", controllers.ApproveQuotaRequest())
+
+	// Deny Quota Request
+	quotaRequestGroup.PATCH("/deny", controllers.DenyQuotaRequest())
+
+	// Get Quota Requests filed by a User
+	quotaRequestGroup.GET("/user", controllers.GetUserQuotaRequests())
+
+	// Get Quota Requests for Services
+	quotaRequestGroup.GET("/service", controllers.GetServiceQuotaRequests())
+
+}
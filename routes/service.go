@@ -15,3 +15,22 @@ func ServiceRoute(router *gin.Engine) {
 	serviceGroup.POST("/create", controllers.CreateService())
 
 }
+
+// Settings of existing services, which only Admins can set
+func ServiceSettingsRoute(router *gin.Engine) {
+
+	serviceGroup := router.Group("/service")
+
+	// Set Quota Schedule for a Service
+	serviceGroup.PATCH("/set-quota-schedule", controllers.SetServiceQuotaSchedule())
+
+	// Set Rollover Policy for a Service
+	serviceGroup.PATCH("/set-rollover-policy", controllers.SetServiceRolloverPolicy())
+
+	// Set Aggregate Quota for a Service
+	serviceGroup.PATCH("/set-aggregate-quota", controllers.SetServiceAggregateQuota())
+
+	// Set Inactivity Policy for a Service
+	serviceGroup.PATCH("/set-inactivity-policy", controllers.SetServiceInactivityPolicy())
+
+}
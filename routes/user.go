@@ -25,4 +25,7 @@ func UserRoute(router *gin.Engine) {
 	// Create User
 	userGroup.POST("/create", controllers.CreateUser())
 
+	// Set User Email
+	userGroup.PATCH("/set-email", controllers.SetUserEmail())
+
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"time"
+
 	"github.com/UTDNebula/kms/configs"
+	"github.com/UTDNebula/kms/controllers"
+	"github.com/UTDNebula/kms/jobs"
 	"github.com/UTDNebula/kms/routes"
 	"github.com/gin-gonic/gin"
 )
@@ -10,7 +14,18 @@ func main() {
 
 	// Config
 	configs.InitConfig()
-	configs.RefreshUsageRemainingGoroutine()
+
+	// Background Jobs
+	// @INFO: Quotas reset at each key's local reset hour, so the refresh runs throughout the day.
+	// Keys are also reset as they are consumed, so the refresh only keeps key views up to date.
+	if configs.GetEnvQuotaBatchRefresh() {
+		jobs.Register(jobs.Job{Name: "RefreshUsageRemaining", Next: jobs.Every(15 * time.Minute), Run: configs.RefreshUsageRemainingOperation, RunOnStart: true})
+	}
+	jobs.Register(jobs.Job{Name: "PurgeDeletedKeys", Next: jobs.Every(time.Hour), Run: configs.PurgeDeletedKeysOperation})
+	jobs.Register(jobs.Job{Name: "ApplyKeySchedules", Next: jobs.Every(time.Minute), Run: controllers.ApplyKeySchedulesOperation, RunOnStart: true})
+	jobs.Register(jobs.Job{Name: "LiftKeySuspensions", Next: jobs.Every(time.Minute), Run: controllers.LiftKeySuspensionsOperation, RunOnStart: true})
+	jobs.Register(jobs.Job{Name: "EnforceKeyInactivity", Next: jobs.Every(time.Hour), Run: controllers.EnforceKeyInactivityOperation})
+	jobs.Start()
 
 	// Configure Gin Router
 	router := gin.Default()
@@ -19,6 +34,14 @@ func main() {
 	routes.AllowedRoute(router)
 	routes.KeyRoute(router)
 	routes.UserRoute(router)
+	routes.AuditRoute(router)
+	routes.JobRoute(router)
+	routes.PlanRoute(router)
+	routes.QuotaRequestRoute(router)
+	routes.PoolRoute(router)
+	routes.ConsistencyRoute(router)
+
+	routes.ServiceSettingsRoute(router)
 
 	// @INFO: Do not uncomment
 	// routes.ServiceRoute(router)